@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bocchi-the-cache/inspector/pkg/index"
+	"github.com/bocchi-the-cache/inspector/pkg/validator"
+	"github.com/spf13/viper"
+)
+
+// commands are subcommands of inspector, eg: inspector cases -state CONTENT_NOT_MATCH
+// Without subcommand, inspector runs the server.
+var commands = map[string]func(args []string) error{
+	"cases":    casesCommand,
+	"reverify": reverifyCommand,
+	"export":   exportCommand,
+	"diff":     diffCommand,
+}
+
+func runCommand(name string, args []string) {
+	cmd, ok := commands[name]
+	if !ok {
+		names := make([]string, 0, len(commands))
+		for n := range commands {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(os.Stderr, "unknown command: %s, commands: %s\n", name, strings.Join(names, ", "))
+		os.Exit(2)
+	}
+	if err := cmd(args); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", name, err)
+		}
+		os.Exit(1)
+	}
+}
+
+// defaultServer returns address of the local inspector by config, if it can be read.
+func defaultServer() string {
+	v := viper.New()
+	v.SetConfigName("config")
+	v.SetConfigType("yaml")
+	v.AddConfigPath("config/")
+	v.SetDefault("http.admin_listen", "127.0.0.1:4398")
+	_ = v.ReadInConfig()
+	addr := v.GetString("http.admin_listen")
+	if strings.HasPrefix(addr, ":") || strings.HasPrefix(addr, "0.0.0.0:") {
+		addr = "127.0.0.1:" + addr[strings.LastIndex(addr, ":")+1:]
+	}
+	return "http://" + addr
+}
+
+// apiGet gets path of inspector server, and decodes json response into v.
+func apiGet(server string, path string, v interface{}) error {
+	return apiDo(http.MethodGet, server, path, v)
+}
+
+// apiRaw gets path of inspector server, and returns the response body.
+func apiRaw(server string, path string) ([]byte, error) {
+	resp, err := http.Get(strings.TrimSuffix(server, "/") + path)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func apiDo(method string, server string, path string, v interface{}) error {
+	req, err := http.NewRequest(method, strings.TrimSuffix(server, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// queryFlags defines case filters on fs, and returns a function building url query of /api/cases.
+func queryFlags(fs *flag.FlagSet) func() url.Values {
+	host := fs.String("host", "", "request host")
+	path := fs.String("path", "", "exact url path")
+	pathPrefix := fs.String("path-prefix", "", "url path prefix")
+	state := fs.String("state", "", "result state, eg: CONTENT_NOT_MATCH")
+	target := fs.String("target", "", "test target name")
+	hash := fs.String("hash", "", "baseline or test body md5")
+	since := fs.String("since", "", "RFC3339 time, or duration before now, eg: 24h")
+	until := fs.String("until", "", "RFC3339 time, or duration before now")
+	return func() url.Values {
+		values := index.Query{
+			Host:       *host,
+			Path:       *path,
+			PathPrefix: *pathPrefix,
+			State:      *state,
+			Target:     *target,
+			Hash:       *hash,
+		}.Values()
+		if *since != "" {
+			values.Set("since", *since)
+		}
+		if *until != "" {
+			values.Set("until", *until)
+		}
+		return values
+	}
+}
+
+// casesCommand searches bad cases through /api/cases of a running inspector, or in an index file directly.
+func casesCommand(args []string) error {
+	fs := flag.NewFlagSet("cases", flag.ContinueOnError)
+	server := fs.String("server", defaultServer(), "address of running inspector")
+	indexPath := fs.String("index", "", "search the index file directly instead, inspector must not be running")
+	query := queryFlags(fs)
+	limit := fs.Int("limit", index.DefaultLimit, "cases per page")
+	cursor := fs.String("cursor", "", "cursor of next page, printed after results")
+	asJSON := fs.Bool("json", false, "print json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	values := query()
+	values.Set("limit", strconv.Itoa(*limit))
+	if *cursor != "" {
+		values.Set("cursor", *cursor)
+	}
+
+	res := &index.Result{}
+	if *indexPath != "" {
+		q, err := index.ParseQuery(values)
+		if err != nil {
+			return err
+		}
+		idx, err := index.Open(*indexPath, true)
+		if err != nil {
+			return fmt.Errorf("open index %s: %w", *indexPath, err)
+		}
+		defer idx.Close()
+		if res, err = idx.Search(q); err != nil {
+			return err
+		}
+	} else if err := apiGet(*server, "/api/cases?"+values.Encode(), res); err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(res)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTIME\tSTATE\tTARGET\tHOST\tURL\tREASON")
+	for _, e := range res.Cases {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.ID, e.Time.Local().Format(time.RFC3339), e.State, e.Target, e.Host, e.URL, e.Reason)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if res.Next != "" {
+		fmt.Printf("next page: -cursor %s\n", res.Next)
+	}
+	return nil
+}
+
+// reverifyCommand re-verifies stored cases through /api/cases/reverify of a running inspector.
+func reverifyCommand(args []string) error {
+	fs := flag.NewFlagSet("reverify", flag.ContinueOnError)
+	server := fs.String("server", defaultServer(), "address of running inspector")
+	query := queryFlags(fs)
+	all := fs.Bool("all", false, "re-verify all cases, required without filters")
+	archive := fs.Bool("archive", false, "archive fixed cases")
+	asJSON := fs.Bool("json", false, "print json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	values := query()
+	if *all {
+		values.Set("all", "true")
+	}
+	if *archive {
+		values.Set("archive", "true")
+	}
+	job := &validator.ReverifyJob{}
+	if err := apiDo(http.MethodPost, *server, "/api/cases/reverify?"+values.Encode(), job); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "reverify job %s started\n", job.ID)
+	for job.State == validator.JobRunning {
+		time.Sleep(time.Second)
+		if err := apiGet(*server, "/api/cases/reverify?job="+url.QueryEscape(job.ID), job); err != nil {
+			return err
+		}
+	}
+	if job.State != validator.JobDone {
+		return fmt.Errorf("reverify job %s failed: %s", job.ID, job.Error)
+	}
+	report := job.Report
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tRESULT\tOLD STATE\tSTATE\tTARGET\tHOST\tURL")
+	for _, rc := range report.Cases {
+		result := rc.Result
+		switch {
+		case rc.Error != "":
+			result += ": " + rc.Error
+		case rc.Archived:
+			result += ", ARCHIVED"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", rc.ID, result, rc.OldState, rc.State, rc.Target, rc.Host, rc.URL)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("report %s: total: %d, fixed: %d, broken: %d, inconclusive: %d, failed: %d\n",
+		report.ID, report.Total, report.Fixed, report.Broken, report.Inconclusive, report.Failed)
+	return nil
+}
+
+// exportCommand exports a stored case through /api/cases/export of a running inspector,
+// requests are built with rewrite rules and addresses of its config.
+func exportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	server := fs.String("server", defaultServer(), "address of running inspector")
+	id := fs.String("id", "", "case id")
+	format := fs.String("format", validator.ExportCurl, "curl: shell script of curl commands, go: Go test with httptest servers")
+	out := fs.String("o", "", "output file, default stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *id == "" {
+		return errors.New("-id is required")
+	}
+
+	values := url.Values{"id": {*id}, "format": {*format}}
+	data, err := apiRaw(*server, "/api/cases/export?"+values.Encode())
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	mode := os.FileMode(0644)
+	if *format == validator.ExportCurl {
+		mode = 0755
+	}
+	return os.WriteFile(*out, data, mode)
+}
+
+// diffCommand prints the diff artifact of a stored case through /api/cases/diff of a running inspector.
+func diffCommand(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	server := fs.String("server", defaultServer(), "address of running inspector")
+	id := fs.String("id", "", "case id")
+	asJSON := fs.Bool("json", false, "print json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *id == "" {
+		return errors.New("-id is required")
+	}
+
+	values := url.Values{"id": {*id}}
+	if *asJSON {
+		values.Set("format", "json")
+	}
+	data, err := apiRaw(*server, "/api/cases/diff?"+values.Encode())
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
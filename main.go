@@ -4,10 +4,13 @@ import (
 	"github.com/bocchi-the-cache/inspector/pkg/client"
 	"github.com/bocchi-the-cache/inspector/pkg/common/logger"
 	"github.com/bocchi-the-cache/inspector/pkg/common/result_logger"
+	"github.com/bocchi-the-cache/inspector/pkg/index"
 	"github.com/bocchi-the-cache/inspector/pkg/monitor"
 	"github.com/bocchi-the-cache/inspector/pkg/server"
 	"github.com/bocchi-the-cache/inspector/pkg/storage"
+	"github.com/bocchi-the-cache/inspector/pkg/validator"
 	"github.com/spf13/viper"
+	"os"
 )
 
 func initLog() {
@@ -33,6 +36,10 @@ func initStorage() {
 	storage.Init()
 }
 
+func initIndex() {
+	index.Init()
+}
+
 func initClient() {
 	client.Init()
 }
@@ -41,13 +48,24 @@ func initMonitor() {
 	monitor.Init()
 }
 
+func initValidator() {
+	validator.Init()
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1], os.Args[2:])
+		return
+	}
+
 	initLog()
 	initResultLog()
 	initConfig()
 	initStorage()
+	initIndex()
 	initClient()
 	initMonitor()
+	initValidator()
 
 	logger.Info("all init done, start server")
 	server.Serve()
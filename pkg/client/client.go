@@ -1,70 +1,192 @@
 package client
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/bocchi-the-cache/inspector/pkg/common/logger"
 	"github.com/bocchi-the-cache/inspector/pkg/monitor"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/spf13/viper"
 )
 
+const (
+	BaselineName = "baseline"
+	// SecondaryName is the name of secondary baseline, an identical build of baseline used to learn noise.
+	SecondaryName = "secondary"
+)
+
+// defaults of stream timeouts, by validator.stream.read_timeout and validator.stream.timeout
+const (
+	defaultStreamReadTimeout = 30 * time.Second
+	defaultStreamTimeout     = 10 * time.Minute
+)
+
+// ErrStreamReadTimeout means no body bytes are received within the read timeout of Open.
+var ErrStreamReadTimeout = errors.New("stream read timeout")
+
+// HopHeaders are hop-by-hop headers, they are neither forwarded to the caller nor compared.
+var HopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
 var BaselineFetcher *Fetcher
-var TestFetcher *Fetcher
+
+// SecondaryFetcher fetches from secondary baseline, nil if host.secondary is unset.
+var SecondaryFetcher *Fetcher
+
+// TestFetchers are all test targets, each one is compared against baseline.
+var TestFetchers []*Fetcher
+
+// Target is a named test target.
+type Target struct {
+	Name string `mapstructure:"name"`
+	Host string `mapstructure:"host"`
+}
 
 func Init() {
 	BaselineFetcher = NewHttpFetcher(viper.GetString("host.baseline"))
-	TestFetcher = NewHttpFetcher(viper.GetString("host.test"))
+	BaselineFetcher.Name = BaselineName
+	BaselineFetcher.Rewriter = NewRewriterFromConfig("rewrite." + BaselineName)
+	if host := viper.GetString("host.secondary"); host != "" {
+		SecondaryFetcher = NewHttpFetcher(host)
+		SecondaryFetcher.Name = SecondaryName
+		SecondaryFetcher.Rewriter = NewRewriterFromConfig("rewrite." + SecondaryName)
+	}
+
+	var targets []Target
+	if err := viper.UnmarshalKey("host.tests", &targets); err != nil {
+		logger.Panicf("parse test targets error, err: %s", err)
+	}
+	if len(targets) == 0 {
+		targets = []Target{{Name: "test", Host: viper.GetString("host.test")}}
+	}
+	TestFetchers = make([]*Fetcher, 0, len(targets))
+	names := map[string]struct{}{BaselineName: {}, SecondaryName: {}}
+	for _, t := range targets {
+		if t.Name == "" || t.Host == "" {
+			logger.Panicf("test target name and host are required, name: %q, host: %q", t.Name, t.Host)
+		}
+		if _, ok := names[t.Name]; ok {
+			logger.Panicf("duplicated test target name: %s", t.Name)
+		}
+		names[t.Name] = struct{}{}
+
+		f := NewHttpFetcher(t.Host)
+		f.Name = t.Name
+		f.Rewriter = NewRewriterFromConfig("rewrite." + t.Name)
+		TestFetchers = append(TestFetchers, f)
+	}
+
+	fetchers := append([]*Fetcher{BaselineFetcher}, TestFetchers...)
+	if SecondaryFetcher != nil {
+		fetchers = append(fetchers, SecondaryFetcher)
+	}
+	for _, f := range fetchers {
+		if viper.IsSet("validator.stream.read_timeout") {
+			f.StreamReadTimeout = viper.GetDuration("validator.stream.read_timeout")
+		}
+		if viper.IsSet("validator.stream.timeout") {
+			f.StreamTimeout = viper.GetDuration("validator.stream.timeout")
+		}
+	}
 }
 
 type Fetcher struct {
-	HttpClient  *http.Client
+	// Name is the target name, eg: baseline, test
+	Name       string
+	HttpClient *http.Client
+	// StreamClient has no overall timeout, used by Open.
+	StreamClient *http.Client
+	// StreamReadTimeout limits the time waiting for body bytes in Open, 0 means no limit.
+	StreamReadTimeout time.Duration
+	// StreamTimeout limits the whole request in Open, including reading the body, 0 means no limit.
+	StreamTimeout time.Duration
+	// RewriteHost is the address to connect to.
 	RewriteHost string
+	// Rewriter rewrites requests before sending, nil means no rewrite.
+	Rewriter *Rewriter
 }
 
 func NewHttpFetcher(rHost string) *Fetcher {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          500,
+		MaxIdleConnsPerHost:   500,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		ResponseHeaderTimeout: 15 * time.Second,
+		// send Accept-Encoding as the caller does, and keep bodies encoded as origins send
+		DisableCompression: true,
+	}
 	c := &http.Client{
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			DialContext: (&net.Dialer{
-				Timeout:   30 * time.Second,
-				KeepAlive: 30 * time.Second,
-			}).DialContext,
-			ForceAttemptHTTP2:     true,
-			MaxIdleConns:          500,
-			MaxIdleConnsPerHost:   500,
-			IdleConnTimeout:       90 * time.Second,
-			TLSHandshakeTimeout:   10 * time.Second,
-			ExpectContinueTimeout: 1 * time.Second,
-		},
-		Timeout: time.Duration(15) * time.Second,
+		Transport: transport,
+		Timeout:   time.Duration(15) * time.Second,
 	}
 	f := &Fetcher{
-		HttpClient:  c,
-		RewriteHost: rHost,
+		HttpClient: c,
+		// large body may take long to read, limited by StreamTimeout and StreamReadTimeout instead
+		StreamClient:      &http.Client{Transport: transport},
+		StreamReadTimeout: defaultStreamReadTimeout,
+		StreamTimeout:     defaultStreamTimeout,
+		RewriteHost:       rHost,
 	}
 	return f
 }
 
-func (f *Fetcher) Do(r *http.Request) (int, http.Header, []byte, error) {
-	// TODO: Host rewrite
+// NewRequest builds the outgoing request exactly as Do sends it.
+func (f *Fetcher) NewRequest(r *Request) (*http.Request, error) {
+	r = f.Rewriter.Rewrite(r)
+	var reqBody io.Reader
+	if len(r.Body) > 0 {
+		reqBody = bytes.NewReader(r.Body)
+	}
 	// For client requests, the URL's Host specifies the server to
 	// connect to, while the Request's Host field optionally
-	req, err := http.NewRequest(r.Method, fmt.Sprintf("http://%s%s", r.Host, r.URL.String()), r.Body)
+	// specifies the Host header value to send in the HTTP request.
+	req, err := http.NewRequest(r.Method, fmt.Sprintf("http://%s%s", r.Host, r.URL), reqBody)
 	if err != nil {
-		monitor.RequestSendTotalCounterIncr(r.Method, r.Host, f.RewriteHost, "ErrorRequest")
-		logger.Error("new request error, err: %s", err)
+		return nil, err
 	}
 	req.Header = r.Header
 	req.URL.Host = f.RewriteHost
+	return req, nil
+}
+
+func (f *Fetcher) Do(r *Request) (int, http.Header, []byte, error) {
+	req, err := f.NewRequest(r)
+	if err != nil {
+		monitor.RequestSendTotalCounterIncr(r.Method, r.Host, f.RewriteHost, "ErrorRequest")
+		logger.Errorf("new request error, err: %s", err)
+		return 0, nil, nil, err
+	}
 	resp, err := f.HttpClient.Do(req)
 	if err != nil {
 		monitor.RequestSendTotalCounterIncr(r.Method, r.Host, f.RewriteHost, "ErrorSend")
 		return 0, nil, nil, err
 	}
+	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		monitor.RequestSendTotalCounterIncr(r.Method, r.Host, f.RewriteHost, "ErrorReadBody")
@@ -74,3 +196,98 @@ func (f *Fetcher) Do(r *http.Request) (int, http.Header, []byte, error) {
 	monitor.RequestSendTotalCounterIncr(r.Method, r.Host, f.RewriteHost, resp.Status)
 	return resp.StatusCode, resp.Header, body, nil
 }
+
+// Open sends the request, and returns the response with body unread.
+// Caller must close resp.Body. Reading fails with ErrStreamReadTimeout if body stalls longer than StreamReadTimeout.
+func (f *Fetcher) Open(r *Request) (*http.Response, error) {
+	req, err := f.NewRequest(r)
+	if err != nil {
+		monitor.RequestSendTotalCounterIncr(r.Method, r.Host, f.RewriteHost, "ErrorRequest")
+		logger.Errorf("new request error, err: %s", err)
+		return nil, err
+	}
+	return f.open(r, req)
+}
+
+// OpenWithBody is Open sending body instead of r.Body, size is the body size, or -1 if unknown.
+// It's for bodies which are not buffered, eg: larger than max_body_size.
+func (f *Fetcher) OpenWithBody(r *Request, body io.Reader, size int64) (*http.Response, error) {
+	head := *r
+	head.Body = nil
+	req, err := f.NewRequest(&head)
+	if err != nil {
+		monitor.RequestSendTotalCounterIncr(r.Method, r.Host, f.RewriteHost, "ErrorRequest")
+		logger.Errorf("new request error, err: %s", err)
+		return nil, err
+	}
+	if body != nil && size != 0 {
+		req.Body = io.NopCloser(body)
+		req.ContentLength = size
+	}
+	return f.open(r, req)
+}
+
+func (f *Fetcher) open(r *Request, req *http.Request) (*http.Response, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if f.StreamTimeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), f.StreamTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	resp, err := f.StreamClient.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		monitor.RequestSendTotalCounterIncr(r.Method, r.Host, f.RewriteHost, "ErrorSend")
+		return nil, err
+	}
+	resp.Body = newIdleBody(resp.Body, f.StreamReadTimeout, cancel)
+	monitor.RequestSendTotalCounterIncr(r.Method, r.Host, f.RewriteHost, resp.Status)
+	return resp, nil
+}
+
+// idleBody cancels the request when a read waits longer than idle, or when it's closed.
+// Time between reads is not counted, bodies compared in lockstep wait for each other.
+type idleBody struct {
+	io.ReadCloser
+	idle    time.Duration
+	timer   *time.Timer
+	cancel  context.CancelFunc
+	expired atomic.Bool
+}
+
+func newIdleBody(body io.ReadCloser, idle time.Duration, cancel context.CancelFunc) *idleBody {
+	b := &idleBody{ReadCloser: body, idle: idle, cancel: cancel}
+	if idle > 0 {
+		b.timer = time.AfterFunc(idle, func() {
+			b.expired.Store(true)
+			cancel()
+		})
+		b.timer.Stop()
+	}
+	return b
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	if b.timer == nil {
+		return b.ReadCloser.Read(p)
+	}
+	if b.expired.Load() {
+		return 0, ErrStreamReadTimeout
+	}
+	b.timer.Reset(b.idle)
+	n, err := b.ReadCloser.Read(p)
+	b.timer.Stop()
+	if err != nil && b.expired.Load() {
+		err = ErrStreamReadTimeout
+	}
+	return n, err
+}
+
+func (b *idleBody) Close() error {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	b.cancel()
+	return b.ReadCloser.Close()
+}
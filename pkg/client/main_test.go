@@ -0,0 +1,14 @@
+package client
+
+import (
+	"os"
+	"testing"
+
+	"github.com/bocchi-the-cache/inspector/pkg/common/logger"
+)
+
+func TestMain(m *testing.M) {
+	// logs go next to the test binary
+	logger.InitLogger("log", "log.txt", "error")
+	os.Exit(m.Run())
+}
@@ -1,9 +1,93 @@
 package client
 
-import "net/http"
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var ErrBodyTooLarge = errors.New("request body too large")
 
 type Content struct {
 	Status  int
 	Header  http.Header
 	Content []byte
+	// Size is the body size in bytes.
+	Size int64 `json:",omitempty"`
+	// MD5 is md5 hex of the whole body as received, empty if not computed or body is not read to the end.
+	MD5 string `json:",omitempty"`
+	// DecodedMD5 is md5 hex of the whole decoded body, and RawSize is the body size as received,
+	// when it's decoded while reading in stream mode.
+	DecodedMD5 string `json:",omitempty"`
+	RawSize    int64  `json:",omitempty"`
+	// Truncated is true when Content doesn't hold the whole body, eg: large body in stream mode.
+	// Content is the prefix kept then, it's not stored in bad cases.
+	Truncated bool `json:",omitempty"`
+	// Decoded is true when Content is decoded by Content-Encoding in Header.
+	Decoded bool `json:",omitempty"`
+	// Latency is the time from sending the request until the body is read, or stopped reading in stream mode.
+	Latency time.Duration `json:",omitempty"`
+}
+
+// Request is a snapshot of the inbound request.
+// Body is buffered once, so it can be replayed identically to baseline and test.
+type Request struct {
+	Method string
+	Host   string
+	URL    string // request uri, eg: /blabla/123/abc.txt?a=b
+	Header http.Header
+	Body   []byte
+}
+
+// NewRequest buffers r.Body up to maxBodySize bytes, 0 means no limit.
+func NewRequest(r *http.Request, maxBodySize int64) (*Request, error) {
+	req := NewRequestHead(r)
+	if err := req.ReadBody(r.Body, maxBodySize); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// NewRequestHead snapshots r without its body, which is still unread.
+func NewRequestHead(r *http.Request) *Request {
+	return &Request{
+		Method: r.Method,
+		Host:   r.Host,
+		URL:    r.URL.RequestURI(),
+		Header: r.Header.Clone(),
+	}
+}
+
+// ReadBody buffers body up to maxBodySize bytes as r.Body, 0 means no limit.
+// If body is larger, ErrBodyTooLarge is returned, r.Body holds the first maxBodySize+1 bytes and the rest is unread.
+func (r *Request) ReadBody(body io.Reader, maxBodySize int64) error {
+	if body == nil || body == http.NoBody {
+		return nil
+	}
+	if maxBodySize > 0 {
+		body = io.LimitReader(body, maxBodySize+1)
+	}
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if len(b) > 0 {
+		r.Body = b
+	}
+	if maxBodySize > 0 && int64(len(b)) > maxBodySize {
+		return ErrBodyTooLarge
+	}
+	return nil
+}
+
+// Path returns the unescaped path of request uri.
+func (r *Request) Path() string {
+	u, err := url.ParseRequestURI(r.URL)
+	if err != nil {
+		return r.URL
+	}
+	return u.Path
 }
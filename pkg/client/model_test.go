@@ -0,0 +1,67 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReadBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     io.Reader
+		max      int64
+		want     []byte
+		wantRest string
+		wantErr  error
+	}{
+		{"no body", http.NoBody, 10, nil, "", nil},
+		{"nil body", nil, 10, nil, "", nil},
+		{"empty", strings.NewReader(""), 10, nil, "", nil},
+		{"no limit", strings.NewReader("0123456789abc"), 0, []byte("0123456789abc"), "", nil},
+		{"below limit", strings.NewReader("01234"), 10, []byte("01234"), "", nil},
+		{"at limit", strings.NewReader("0123456789"), 10, []byte("0123456789"), "", nil},
+		{"too large", strings.NewReader("0123456789abc"), 10, []byte("0123456789a"), "bc", ErrBodyTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Request{}
+			err := r.ReadBody(tt.body, tt.max)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReadBody: got error %v, want %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(r.Body, tt.want) || (r.Body == nil) != (tt.want == nil) {
+				t.Fatalf("ReadBody: got body %q, want %q", r.Body, tt.want)
+			}
+			if tt.body == nil || tt.body == http.NoBody {
+				return
+			}
+			// the rest is left for forwarding
+			rest, _ := io.ReadAll(tt.body)
+			if string(rest) != tt.wantRest {
+				t.Fatalf("ReadBody: got unread %q, want %q", rest, tt.wantRest)
+			}
+		})
+	}
+}
+
+func TestRequestPath(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"/a/b.txt", "/a/b.txt"},
+		{"/a/b.txt?x=1", "/a/b.txt"},
+		{"/a%20b/c%2Fd", "/a b/c/d"},
+		{"/", "/"},
+		{"%zz", "%zz"},
+	}
+	for _, tt := range tests {
+		if got := (&Request{URL: tt.url}).Path(); got != tt.want {
+			t.Errorf("Path of %q: got %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
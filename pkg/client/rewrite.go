@@ -0,0 +1,119 @@
+package client
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/bocchi-the-cache/inspector/pkg/common/logger"
+	"github.com/spf13/viper"
+)
+
+// Rewriter rewrites requests before sending to a target.
+type Rewriter struct {
+	// HostHeader overrides Host header, empty keeps the original one.
+	// The address to connect to is always Fetcher.RewriteHost.
+	HostHeader    string
+	SetHeaders    []NameValue
+	AddHeaders    []NameValue
+	RemoveHeaders []string
+	PathPrefixes  []PathPrefix
+	// AddQuery is appended to the raw query, in order.
+	AddQuery []NameValue
+}
+
+// NameValue is a header or query parameter.
+// Rules are lists instead of maps, viper lowercases map keys.
+type NameValue struct {
+	Name  string `mapstructure:"name"`
+	Value string `mapstructure:"value"`
+}
+
+// PathPrefix rewrites path prefix From to To, eg: /v1/ -> /v2/
+type PathPrefix struct {
+	From string `mapstructure:"from"`
+	To   string `mapstructure:"to"`
+}
+
+// NewRewriterFromConfig builds rewriter from config key, eg: rewrite.baseline
+// nil is returned if no rule is configured.
+func NewRewriterFromConfig(key string) *Rewriter {
+	if !viper.IsSet(key) {
+		return nil
+	}
+	rw := &Rewriter{
+		HostHeader:    viper.GetString(key + ".host_header"),
+		RemoveHeaders: viper.GetStringSlice(key + ".headers.remove"),
+	}
+	decode := func(sub string, out interface{}) {
+		if err := viper.UnmarshalKey(key+sub, out); err != nil {
+			logger.Panicf("parse rewrite rules error, key: %s%s, err: %s", key, sub, err)
+		}
+	}
+	decode(".headers.set", &rw.SetHeaders)
+	decode(".headers.add", &rw.AddHeaders)
+	decode(".path_prefix", &rw.PathPrefixes)
+	decode(".query.add", &rw.AddQuery)
+
+	// a map instead of a list of name and value is decoded to empty names
+	for _, rules := range [][]NameValue{rw.SetHeaders, rw.AddHeaders, rw.AddQuery} {
+		for _, nv := range rules {
+			if nv.Name == "" {
+				logger.Panicf("rewrite rule name is required, key: %s, rule: %+v", key, nv)
+			}
+		}
+	}
+	for _, p := range rw.PathPrefixes {
+		if p.From == "" {
+			logger.Panicf("rewrite path_prefix from is required, key: %s, rule: %+v", key, p)
+		}
+	}
+	return rw
+}
+
+// Rewrite returns a rewritten copy of r, r itself is not modified.
+func (rw *Rewriter) Rewrite(r *Request) *Request {
+	nr := *r
+	nr.Header = r.Header.Clone()
+	if rw == nil {
+		return &nr
+	}
+
+	if rw.HostHeader != "" {
+		nr.Host = rw.HostHeader
+	}
+	for _, k := range rw.RemoveHeaders {
+		nr.Header.Del(k)
+	}
+	for _, h := range rw.SetHeaders {
+		nr.Header.Set(h.Name, h.Value)
+	}
+	for _, h := range rw.AddHeaders {
+		nr.Header.Add(h.Name, h.Value)
+	}
+
+	if len(rw.PathPrefixes) == 0 && len(rw.AddQuery) == 0 {
+		return &nr
+	}
+	p, q := nr.URL, ""
+	if i := strings.Index(nr.URL, "?"); i >= 0 {
+		p, q = nr.URL[:i], nr.URL[i+1:]
+	}
+	for _, pp := range rw.PathPrefixes {
+		if strings.HasPrefix(p, pp.From) {
+			p = pp.To + strings.TrimPrefix(p, pp.From)
+			break
+		}
+	}
+	// the original query is kept as it is, parsing and encoding it would reorder and re-escape it
+	for _, kv := range rw.AddQuery {
+		if q != "" {
+			q += "&"
+		}
+		q += url.QueryEscape(kv.Name) + "=" + url.QueryEscape(kv.Value)
+	}
+	nr.URL = p
+	if q != "" {
+		nr.URL = p + "?" + q
+	}
+	return &nr
+}
@@ -0,0 +1,159 @@
+package client
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestRewrite(t *testing.T) {
+	tests := []struct {
+		name       string
+		rw         *Rewriter
+		url        string
+		header     http.Header
+		wantHost   string
+		wantURL    string
+		wantHeader http.Header
+	}{
+		{"nil rewriter", nil, "/a?x=1", http.Header{"A": {"1"}},
+			"origin", "/a?x=1", http.Header{"A": {"1"}}},
+		{"host header", &Rewriter{HostHeader: "test.example.com"}, "/a", http.Header{},
+			"test.example.com", "/a", http.Header{}},
+		{"set header", &Rewriter{SetHeaders: []NameValue{{"X-Env", "test"}}}, "/a", http.Header{"X-Env": {"prod", "x"}},
+			"origin", "/a", http.Header{"X-Env": {"test"}}},
+		{"add header", &Rewriter{AddHeaders: []NameValue{{"X-Env", "test"}, {"x-env", "b"}}}, "/a", http.Header{"X-Env": {"prod"}},
+			"origin", "/a", http.Header{"X-Env": {"prod", "test", "b"}}},
+		{"remove before set", &Rewriter{RemoveHeaders: []string{"cookie", "X-Env"}, SetHeaders: []NameValue{{"X-Env", "test"}}},
+			"/a", http.Header{"Cookie": {"a=b"}, "X-Env": {"prod"}},
+			"origin", "/a", http.Header{"X-Env": {"test"}}},
+		{"path prefix", &Rewriter{PathPrefixes: []PathPrefix{{"/v1/", "/v2/"}}}, "/v1/a?x=/v1/", http.Header{},
+			"origin", "/v2/a?x=/v1/", http.Header{}},
+		{"first path prefix wins", &Rewriter{PathPrefixes: []PathPrefix{{"/v1/", "/v2/"}, {"/v", "/w"}}}, "/v1/a", http.Header{},
+			"origin", "/v2/a", http.Header{}},
+		{"path prefix not matched", &Rewriter{PathPrefixes: []PathPrefix{{"/v1/", "/v2/"}}}, "/v10/a", http.Header{},
+			"origin", "/v10/a", http.Header{}},
+		{"add query", &Rewriter{AddQuery: []NameValue{{"debug", "1"}}}, "/a", http.Header{},
+			"origin", "/a?debug=1", http.Header{}},
+		{"add query keeps raw query", &Rewriter{AddQuery: []NameValue{{"b", "2"}, {"a", "1"}}}, "/a?z=%2f&y=a+b&z=", http.Header{},
+			"origin", "/a?z=%2f&y=a+b&z=&b=2&a=1", http.Header{}},
+		{"add query escapes", &Rewriter{AddQuery: []NameValue{{"k y", "a&b=c/d"}}}, "/a?", http.Header{},
+			"origin", "/a?k+y=a%26b%3Dc%2Fd", http.Header{}},
+		{"path prefix and query", &Rewriter{PathPrefixes: []PathPrefix{{"/old", "/new"}}, AddQuery: []NameValue{{"t", "1"}}},
+			"/old/a?x=1", http.Header{},
+			"origin", "/new/a?x=1&t=1", http.Header{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Request{Method: http.MethodGet, Host: "origin", URL: tt.url, Header: tt.header}
+			origURL, origHeader := r.URL, r.Header.Clone()
+
+			got := tt.rw.Rewrite(r)
+			if got.Host != tt.wantHost || got.URL != tt.wantURL {
+				t.Fatalf("Rewrite: got host %q, url %q, want %q, %q", got.Host, got.URL, tt.wantHost, tt.wantURL)
+			}
+			if !reflect.DeepEqual(got.Header, tt.wantHeader) {
+				t.Fatalf("Rewrite: got header %v, want %v", got.Header, tt.wantHeader)
+			}
+			if r.Host != "origin" || r.URL != origURL || !reflect.DeepEqual(r.Header, origHeader) {
+				t.Fatalf("Rewrite modified the original request: %+v", r)
+			}
+		})
+	}
+}
+
+func TestNewRewriterFromConfig(t *testing.T) {
+	defer viper.Reset()
+	viper.SetConfigType("yaml")
+	err := viper.ReadConfig(strings.NewReader(`
+rewrite:
+  test:
+    host_header: test.example.com
+    headers:
+      set:
+        - name: X-Request-From
+          value: inspector
+      add:
+        - name: X-Tag
+          value: a
+      remove: [Cookie]
+    path_prefix:
+      - from: /v1/
+        to: /v2/
+    query:
+      add:
+        - name: Debug
+          value: "1"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rw := NewRewriterFromConfig("rewrite.baseline"); rw != nil {
+		t.Fatalf("NewRewriterFromConfig of unset key: got %+v, want nil", rw)
+	}
+	want := &Rewriter{
+		HostHeader:    "test.example.com",
+		SetHeaders:    []NameValue{{"X-Request-From", "inspector"}},
+		AddHeaders:    []NameValue{{"X-Tag", "a"}},
+		RemoveHeaders: []string{"Cookie"},
+		PathPrefixes:  []PathPrefix{{"/v1/", "/v2/"}},
+		// names keep their case, viper only lowercases map keys
+		AddQuery: []NameValue{{"Debug", "1"}},
+	}
+	if got := NewRewriterFromConfig("rewrite.test"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("NewRewriterFromConfig: got %+v, want %+v", got, want)
+	}
+}
+
+func TestNewRewriterFromConfigInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"headers as a map", `
+rewrite:
+  test:
+    headers:
+      set:
+        X-Env: test
+`},
+		{"headers as a string", `
+rewrite:
+  test:
+    headers:
+      add: "X-Env: test"
+`},
+		{"query without name", `
+rewrite:
+  test:
+    query:
+      add:
+        - value: "1"
+`},
+		{"path prefix as a map", `
+rewrite:
+  test:
+    path_prefix:
+      /v1/: /v2/
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer viper.Reset()
+			viper.SetConfigType("yaml")
+			if err := viper.ReadConfig(strings.NewReader(tt.config)); err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if recover() == nil {
+					t.Fatal("NewRewriterFromConfig: got no panic")
+				}
+			}()
+			NewRewriterFromConfig("rewrite.test")
+		})
+	}
+}
@@ -5,11 +5,14 @@ package result_logger
 // many thanks to the author.
 
 import (
+	"encoding/json"
 	"fmt"
 	"gopkg.in/natefinch/lumberjack.v2"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -19,20 +22,28 @@ var log *zap.SugaredLogger
 
 var logLevel = zap.NewAtomicLevel()
 
+// records are written as they are, to the same file and stdout
+var (
+	recordMu  sync.Mutex
+	recordOut io.Writer = io.Discard
+)
+
 func InitLogger(folder, file, level string) {
 	SetLevel(configLevel[level])
 	filePath := getFilePath(folder, file)
 
 	fmt.Println("[INFO] logger init filePath: ", filePath)
 
-	w := zapcore.AddSync(&lumberjack.Logger{
+	rotator := &lumberjack.Logger{
 		Filename:   filePath,
 		MaxSize:    1024, //MB
 		MaxBackups: 7,
 		MaxAge:     7, //days
 		LocalTime:  true,
 		Compress:   false,
-	})
+	}
+	w := zapcore.AddSync(rotator)
+	recordOut = io.MultiWriter(rotator, os.Stdout)
 
 	encoderConfig := zap.NewProductionEncoderConfig()
 	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
@@ -139,6 +150,21 @@ func Panicf(template string, args ...interface{}) {
 	log.Panicf(template, args...)
 }
 
+// Record writes v as one json object per line, without log level, time or caller.
+func Record(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Errorf("marshal record error, err: %s", err)
+		return
+	}
+	data = append(data, '\n')
+	recordMu.Lock()
+	defer recordMu.Unlock()
+	if _, err = recordOut.Write(data); err != nil {
+		log.Errorf("write record error, err: %s", err)
+	}
+}
+
 func With(args ...interface{}) *zap.SugaredLogger {
 	return log.With(args...)
 }
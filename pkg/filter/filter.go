@@ -0,0 +1,261 @@
+package filter
+
+import (
+	"math/rand"
+	"net"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+	"github.com/spf13/viper"
+)
+
+// Reasons of filtered out requests, used as metric label.
+const (
+	ReasonHostNotAllowed   = "HostNotAllowed"
+	ReasonHostDenied       = "HostDenied"
+	ReasonPathNotIncluded  = "PathNotIncluded"
+	ReasonPathExcluded     = "PathExcluded"
+	ReasonQueryNotIncluded = "QueryNotIncluded"
+	ReasonQueryExcluded    = "QueryExcluded"
+	ReasonExtNotIncluded   = "ExtNotIncluded"
+	ReasonExtExcluded      = "ExtExcluded"
+	ReasonSampledOut       = "SampledOut"
+)
+
+// Rule returns the reason if request should be filtered out, or "" if passed.
+type Rule func(r *client.Request) string
+
+// Chain filters requests by rules in order, the first failed rule wins.
+type Chain struct {
+	rules []Rule
+}
+
+type Options struct {
+	HostAllow    []string
+	HostDeny     []string
+	PathInclude  []string
+	PathExclude  []string
+	QueryInclude []string
+	QueryExclude []string
+	ExtInclude   []string
+	ExtExclude   []string
+	// SampleDefault is the percentage of requests to validate, 100 if unset.
+	SampleDefault *float64
+	// SampleHosts is the percentage of requests to validate per host.
+	SampleHosts map[string]float64
+}
+
+// NewFromConfig builds filter chain from `filter` config.
+func NewFromConfig() (*Chain, error) {
+	opt := Options{
+		HostAllow:    viper.GetStringSlice("filter.host.allow"),
+		HostDeny:     viper.GetStringSlice("filter.host.deny"),
+		PathInclude:  viper.GetStringSlice("filter.path.include"),
+		PathExclude:  viper.GetStringSlice("filter.path.exclude"),
+		QueryInclude: viper.GetStringSlice("filter.query.include"),
+		QueryExclude: viper.GetStringSlice("filter.query.exclude"),
+		ExtInclude:   viper.GetStringSlice("filter.extension.include"),
+		ExtExclude:   viper.GetStringSlice("filter.extension.exclude"),
+		SampleHosts:  map[string]float64{},
+	}
+	if viper.IsSet("filter.sample.default") {
+		d := viper.GetFloat64("filter.sample.default")
+		opt.SampleDefault = &d
+	}
+	for h := range viper.GetStringMap("filter.sample.hosts") {
+		// viper lowercases map keys, hosts are case-insensitive anyway
+		opt.SampleHosts[h] = viper.GetFloat64("filter.sample.hosts." + h)
+	}
+	return New(opt)
+}
+
+func New(opt Options) (*Chain, error) {
+	c := &Chain{}
+	if len(opt.HostAllow) > 0 {
+		hosts := newHostSet(opt.HostAllow)
+		c.rules = append(c.rules, func(r *client.Request) string {
+			if !hosts.match(r.Host) {
+				return ReasonHostNotAllowed
+			}
+			return ""
+		})
+	}
+	if len(opt.HostDeny) > 0 {
+		hosts := newHostSet(opt.HostDeny)
+		c.rules = append(c.rules, func(r *client.Request) string {
+			if hosts.match(r.Host) {
+				return ReasonHostDenied
+			}
+			return ""
+		})
+	}
+
+	getPath := func(r *client.Request) string { return r.Path() }
+	getQuery := func(r *client.Request) string {
+		if i := strings.Index(r.URL, "?"); i >= 0 {
+			return r.URL[i+1:]
+		}
+		return ""
+	}
+	for _, rg := range []struct {
+		patterns []string
+		include  bool
+		get      func(r *client.Request) string
+		reason   string
+	}{
+		{opt.PathInclude, true, getPath, ReasonPathNotIncluded},
+		{opt.PathExclude, false, getPath, ReasonPathExcluded},
+		{opt.QueryInclude, true, getQuery, ReasonQueryNotIncluded},
+		{opt.QueryExclude, false, getQuery, ReasonQueryExcluded},
+	} {
+		if len(rg.patterns) == 0 {
+			continue
+		}
+		rule, err := regexpRule(rg.patterns, rg.include, rg.get, rg.reason)
+		if err != nil {
+			return nil, err
+		}
+		c.rules = append(c.rules, rule)
+	}
+
+	if len(opt.ExtInclude) > 0 {
+		exts := newExtSet(opt.ExtInclude)
+		c.rules = append(c.rules, func(r *client.Request) string {
+			if _, ok := exts[strings.ToLower(path.Ext(r.Path()))]; !ok {
+				return ReasonExtNotIncluded
+			}
+			return ""
+		})
+	}
+	if len(opt.ExtExclude) > 0 {
+		exts := newExtSet(opt.ExtExclude)
+		c.rules = append(c.rules, func(r *client.Request) string {
+			if _, ok := exts[strings.ToLower(path.Ext(r.Path()))]; ok {
+				return ReasonExtExcluded
+			}
+			return ""
+		})
+	}
+
+	if opt.SampleDefault != nil || len(opt.SampleHosts) > 0 {
+		def := 100.0
+		if opt.SampleDefault != nil {
+			def = *opt.SampleDefault
+		}
+		hosts := make(map[string]float64, len(opt.SampleHosts))
+		for h, p := range opt.SampleHosts {
+			hosts[strings.ToLower(h)] = p
+		}
+		c.rules = append(c.rules, func(r *client.Request) string {
+			p, ok := hosts[strings.ToLower(r.Host)]
+			if !ok {
+				p, ok = hosts[hostname(r.Host)]
+			}
+			if !ok {
+				p = def
+			}
+			if rand.Float64()*100 >= p {
+				return ReasonSampledOut
+			}
+			return ""
+		})
+	}
+	return c, nil
+}
+
+// Check returns the reason if request should be filtered out, or "" if passed.
+func (c *Chain) Check(r *client.Request) string {
+	if c == nil {
+		return ""
+	}
+	for _, rule := range c.rules {
+		if reason := rule(r); reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
+
+func regexpRule(patterns []string, include bool, get func(r *client.Request) string, reason string) (Rule, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	return func(r *client.Request) string {
+		s := get(r)
+		matched := false
+		for _, re := range res {
+			if re.MatchString(s) {
+				matched = true
+				break
+			}
+		}
+		if matched != include {
+			return reason
+		}
+		return ""
+	}, nil
+}
+
+// hostSet matches host exactly, or by wildcard suffix, eg: *.example.com
+type hostSet struct {
+	exact    map[string]struct{}
+	suffixes []string
+}
+
+func newHostSet(hosts []string) *hostSet {
+	s := &hostSet{exact: map[string]struct{}{}}
+	for _, h := range hosts {
+		h = strings.ToLower(h)
+		if strings.HasPrefix(h, "*.") {
+			s.suffixes = append(s.suffixes, h[1:])
+			continue
+		}
+		s.exact[h] = struct{}{}
+	}
+	return s
+}
+
+// match checks both "host:port" and "host"
+func (s *hostSet) match(host string) bool {
+	host = strings.ToLower(host)
+	name := hostname(host)
+	if _, ok := s.exact[host]; ok {
+		return true
+	}
+	if _, ok := s.exact[name]; ok {
+		return true
+	}
+	for _, suffix := range s.suffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func newExtSet(exts []string) map[string]struct{} {
+	m := make(map[string]struct{}, len(exts))
+	for _, e := range exts {
+		e = strings.ToLower(e)
+		if !strings.HasPrefix(e, ".") {
+			e = "." + e
+		}
+		m[e] = struct{}{}
+	}
+	return m
+}
+
+func hostname(host string) string {
+	h, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return strings.ToLower(host)
+	}
+	return strings.ToLower(h)
+}
@@ -0,0 +1,136 @@
+package filter
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+	"github.com/spf13/viper"
+)
+
+func float(f float64) *float64 {
+	return &f
+}
+
+func TestChainCheck(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  Options
+		host string
+		url  string
+		want string
+	}{
+		{"no rules", Options{}, "a.com", "/x", ""},
+
+		{"host allowed", Options{HostAllow: []string{"a.com"}}, "a.com", "/x", ""},
+		{"host allowed with port", Options{HostAllow: []string{"a.com"}}, "a.com:8080", "/x", ""},
+		{"host allowed by port", Options{HostAllow: []string{"a.com:8080"}}, "a.com:8080", "/x", ""},
+		{"host allowed case insensitive", Options{HostAllow: []string{"A.com"}}, "a.COM", "/x", ""},
+		{"host allowed by wildcard", Options{HostAllow: []string{"*.a.com"}}, "img.a.com", "/x", ""},
+		{"wildcard doesn't match apex", Options{HostAllow: []string{"*.a.com"}}, "a.com", "/x", ReasonHostNotAllowed},
+		{"wildcard doesn't match suffix", Options{HostAllow: []string{"*.a.com"}}, "xa.com", "/x", ReasonHostNotAllowed},
+		{"host not allowed", Options{HostAllow: []string{"a.com"}}, "b.com", "/x", ReasonHostNotAllowed},
+		{"host denied", Options{HostDeny: []string{"*.b.com"}}, "x.b.com:80", "/x", ReasonHostDenied},
+		{"host not denied", Options{HostDeny: []string{"b.com"}}, "a.com", "/x", ""},
+		{"allow before deny", Options{HostAllow: []string{"a.com"}, HostDeny: []string{"a.com"}}, "b.com", "/x", ReasonHostNotAllowed},
+
+		{"path included", Options{PathInclude: []string{"^/static/", "^/img/"}}, "a.com", "/img/a.png", ""},
+		{"path not included", Options{PathInclude: []string{"^/static/"}}, "a.com", "/api/a", ReasonPathNotIncluded},
+		{"path excluded", Options{PathExclude: []string{"^/api/"}}, "a.com", "/api/a", ReasonPathExcluded},
+		{"path is unescaped", Options{PathExclude: []string{"^/a b/"}}, "a.com", "/a%20b/c", ReasonPathExcluded},
+		{"path excludes query", Options{PathInclude: []string{`\.png$`}}, "a.com", "/a.png?v=1", ""},
+
+		{"query included", Options{QueryInclude: []string{"(^|&)v="}}, "a.com", "/a?x=1&v=2", ""},
+		{"query not included", Options{QueryInclude: []string{"(^|&)v="}}, "a.com", "/a?x=1", ReasonQueryNotIncluded},
+		{"query not included without query", Options{QueryInclude: []string{"."}}, "a.com", "/a", ReasonQueryNotIncluded},
+		{"query excluded", Options{QueryExclude: []string{"nocache"}}, "a.com", "/a?nocache=1", ReasonQueryExcluded},
+
+		{"ext included", Options{ExtInclude: []string{"png", ".JPG"}}, "a.com", "/a.jpg", ""},
+		{"ext not included", Options{ExtInclude: []string{"png"}}, "a.com", "/a.gif", ReasonExtNotIncluded},
+		{"ext not included without ext", Options{ExtInclude: []string{"png"}}, "a.com", "/a", ReasonExtNotIncluded},
+		{"ext excluded", Options{ExtExclude: []string{".m3u8"}}, "a.com", "/live/a.M3U8?t=1", ReasonExtExcluded},
+
+		{"sample all", Options{SampleDefault: float(100)}, "a.com", "/x", ""},
+		{"sample none", Options{SampleDefault: float(0)}, "a.com", "/x", ReasonSampledOut},
+		{"sample host", Options{SampleDefault: float(0), SampleHosts: map[string]float64{"A.com": 100}}, "a.com:8080", "/x", ""},
+		{"sample host with port", Options{SampleHosts: map[string]float64{"a.com:8080": 0}}, "a.com:8080", "/x", ReasonSampledOut},
+		{"sample other host by default", Options{SampleHosts: map[string]float64{"a.com": 0}}, "b.com", "/x", ""},
+
+		{"first failed rule wins", Options{HostDeny: []string{"a.com"}, PathExclude: []string{"."}}, "a.com", "/x", ReasonHostDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := New(tt.opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := &client.Request{Method: http.MethodGet, Host: tt.host, URL: tt.url, Header: http.Header{}}
+			if got := c.Check(r); got != tt.want {
+				t.Fatalf("Check(%s%s): got %q, want %q", tt.host, tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewInvalidRegexp(t *testing.T) {
+	for _, opt := range []Options{
+		{PathInclude: []string{"("}},
+		{PathExclude: []string{"["}},
+		{QueryInclude: []string{"a**"}},
+		{QueryExclude: []string{`\`}},
+	} {
+		if _, err := New(opt); err == nil {
+			t.Errorf("New(%+v): got no error", opt)
+		}
+	}
+}
+
+func TestNilChain(t *testing.T) {
+	var c *Chain
+	if got := c.Check(&client.Request{URL: "/"}); got != "" {
+		t.Fatalf("Check of nil chain: got %q, want pass", got)
+	}
+}
+
+func TestNewFromConfig(t *testing.T) {
+	defer viper.Reset()
+	viper.SetConfigType("yaml")
+	err := viper.ReadConfig(strings.NewReader(`
+filter:
+  host:
+    deny: [admin.a.com]
+  path:
+    exclude: ["^/api/"]
+  extension:
+    exclude: [m3u8]
+  sample:
+    default: 0
+    hosts:
+      IMG.a.com: 100
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewFromConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		host string
+		url  string
+		want string
+	}{
+		{"admin.a.com", "/a.png", ReasonHostDenied},
+		{"img.a.com", "/api/a", ReasonPathExcluded},
+		{"img.a.com", "/live/a.m3u8", ReasonExtExcluded},
+		{"img.a.com", "/a.png", ""},
+		{"www.a.com", "/a.png", ReasonSampledOut},
+	}
+	for _, tt := range tests {
+		r := &client.Request{Method: http.MethodGet, Host: tt.host, URL: tt.url, Header: http.Header{}}
+		if got := c.Check(r); got != tt.want {
+			t.Errorf("Check(%s%s): got %q, want %q", tt.host, tt.url, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package index
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bocchi-the-cache/inspector/pkg/common/logger"
+	"github.com/spf13/viper"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	DefaultLimit = 50
+	MaxLimit     = 1000
+)
+
+// ErrDisabled means the case index is not enabled.
+var ErrDisabled = errors.New("case index is disabled")
+
+var (
+	bucketCases = []byte("cases")
+	// secondary indexes, keyed by <value>\x00<case id>
+	bucketHost   = []byte("host")
+	bucketPath   = []byte("path")
+	bucketState  = []byte("state")
+	bucketTarget = []byte("target")
+	bucketHash   = []byte("hash")
+)
+
+// Entry is the indexed summary of a stored bad case.
+type Entry struct {
+	ID   string
+	Time time.Time
+	Host string
+	Path string
+	// URL is the request uri with query
+	URL            string
+	State          string
+	Target         string
+	BaselineStatus int
+	TestStatus     int
+	BaselineHash   string
+	TestHash       string
+	Reason         string `json:",omitempty"`
+}
+
+// Query filters cases, empty fields match all. Results are newest first.
+type Query struct {
+	Host string
+	// Path is the exact url path, PathPrefix matches paths starting with it.
+	Path       string
+	PathPrefix string
+	State      string
+	Target     string
+	// Hash matches either baseline or test hash.
+	Hash  string
+	Since time.Time
+	Until time.Time
+	// Cursor is the Next of previous page.
+	Cursor string
+	Limit  int
+}
+
+type Result struct {
+	Cases []*Entry
+	// Next is the cursor of next page, empty if no more cases.
+	Next string
+}
+
+// Index is a local index of bad cases in an embedded bolt database.
+type Index struct {
+	DB *bolt.DB
+}
+
+var DefaultIndex *Index
+
+func Init() {
+	if !viper.GetBool("index.enable") {
+		return
+	}
+	var err error
+	DefaultIndex, err = Open(viper.GetString("index.path"), false)
+	if err != nil {
+		logger.Panicf("open case index error, path: %s, err: %s", viper.GetString("index.path"), err)
+	}
+}
+
+// Open opens the index file, read only index can be opened by several processes, but not with a writer.
+func Open(path string, readOnly bool) (*Index, error) {
+	if !readOnly {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second, ReadOnly: readOnly})
+	if err != nil {
+		return nil, err
+	}
+	if readOnly {
+		return &Index{DB: db}, nil
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketCases, bucketHost, bucketPath, bucketState, bucketTarget, bucketHash} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Index{DB: db}, nil
+}
+
+func (idx *Index) Close() error {
+	return idx.DB.Close()
+}
+
+// Enabled returns whether the default index is enabled.
+func Enabled() bool {
+	return DefaultIndex != nil
+}
+
+func Add(e *Entry) error {
+	if DefaultIndex == nil {
+		return nil
+	}
+	return DefaultIndex.Add(e)
+}
+
+func Delete(id string) error {
+	if DefaultIndex == nil {
+		return nil
+	}
+	return DefaultIndex.Delete(id)
+}
+
+func Search(q Query) (*Result, error) {
+	if DefaultIndex == nil {
+		return nil, ErrDisabled
+	}
+	return DefaultIndex.Search(q)
+}
+
+func indexKey(value string, id string) []byte {
+	return []byte(value + "\x00" + id)
+}
+
+// secondaryKeys returns keys of the entry in secondary indexes.
+func (e *Entry) secondaryKeys() map[string][][]byte {
+	keys := map[string][][]byte{
+		string(bucketHost):   {indexKey(e.Host, e.ID)},
+		string(bucketPath):   {indexKey(e.Path, e.ID)},
+		string(bucketState):  {indexKey(e.State, e.ID)},
+		string(bucketTarget): {indexKey(e.Target, e.ID)},
+	}
+	for _, h := range []string{e.BaselineHash, e.TestHash} {
+		if h != "" {
+			keys[string(bucketHash)] = append(keys[string(bucketHash)], indexKey(h, e.ID))
+		}
+	}
+	return keys
+}
+
+// Add indexes the entry, an existing entry of the same id is replaced.
+func (idx *Index) Add(e *Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return idx.DB.Update(func(tx *bolt.Tx) error {
+		if err := deleteEntry(tx, e.ID); err != nil {
+			return err
+		}
+		if err := tx.Bucket(bucketCases).Put([]byte(e.ID), data); err != nil {
+			return err
+		}
+		for bucket, keys := range e.secondaryKeys() {
+			for _, k := range keys {
+				if err := tx.Bucket([]byte(bucket)).Put(k, nil); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Delete removes the case from index, it's not an error if the case isn't indexed.
+func (idx *Index) Delete(id string) error {
+	return idx.DB.Update(func(tx *bolt.Tx) error {
+		return deleteEntry(tx, id)
+	})
+}
+
+func deleteEntry(tx *bolt.Tx, id string) error {
+	cases := tx.Bucket(bucketCases)
+	data := cases.Get([]byte(id))
+	if data == nil {
+		return nil
+	}
+	e := &Entry{}
+	if err := json.Unmarshal(data, e); err != nil {
+		return err
+	}
+	for bucket, keys := range e.secondaryKeys() {
+		for _, k := range keys {
+			if err := tx.Bucket([]byte(bucket)).Delete(k); err != nil {
+				return err
+			}
+		}
+	}
+	return cases.Delete([]byte(id))
+}
+
+// Empty returns whether no case is indexed.
+func (idx *Index) Empty() bool {
+	empty := true
+	_ = idx.DB.View(func(tx *bolt.Tx) error {
+		k, _ := tx.Bucket(bucketCases).Cursor().First()
+		empty = k == nil
+		return nil
+	})
+	return empty
+}
+
+// Filtered returns whether any filter of the query is set, Cursor and Limit are not filters.
+func (q *Query) Filtered() bool {
+	return q.Host != "" || q.Path != "" || q.PathPrefix != "" || q.State != "" || q.Target != "" || q.Hash != "" ||
+		!q.Since.IsZero() || !q.Until.IsZero()
+}
+
+// Match returns whether the entry matches all fields of the query, except Cursor and Limit.
+func (e *Entry) Match(q *Query) bool {
+	switch {
+	case q.Host != "" && e.Host != q.Host,
+		q.Path != "" && e.Path != q.Path,
+		q.PathPrefix != "" && !strings.HasPrefix(e.Path, q.PathPrefix),
+		q.State != "" && e.State != q.State,
+		q.Target != "" && e.Target != q.Target,
+		q.Hash != "" && e.BaselineHash != q.Hash && e.TestHash != q.Hash,
+		!q.Since.IsZero() && e.Time.Before(q.Since),
+		!q.Until.IsZero() && e.Time.After(q.Until):
+		return false
+	}
+	return true
+}
+
+// Search returns cases matching the query, newest first.
+// The most selective secondary index of the query is scanned, other fields are filtered.
+func (idx *Index) Search(q Query) (*Result, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultLimit
+	}
+	if q.Limit > MaxLimit {
+		q.Limit = MaxLimit
+	}
+	var bucket []byte
+	var prefix []byte
+	switch {
+	case q.Hash != "":
+		bucket, prefix = bucketHash, indexKey(q.Hash, "")
+	case q.Path != "":
+		bucket, prefix = bucketPath, indexKey(q.Path, "")
+	case q.Host != "":
+		bucket, prefix = bucketHost, indexKey(q.Host, "")
+	case q.Target != "":
+		bucket, prefix = bucketTarget, indexKey(q.Target, "")
+	case q.State != "":
+		bucket, prefix = bucketState, indexKey(q.State, "")
+	default:
+		bucket = bucketCases
+	}
+
+	res := &Result{}
+	err := idx.DB.View(func(tx *bolt.Tx) error {
+		cases := tx.Bucket(bucketCases)
+		c := tx.Bucket(bucket).Cursor()
+		// case ids start with time, iterate backward from cursor
+		start := append(append([]byte{}, prefix...), 0xff)
+		if q.Cursor != "" {
+			start = append(append([]byte{}, prefix...), q.Cursor...)
+		}
+		k, _ := c.Seek(start)
+		if k == nil {
+			k, _ = c.Last()
+		}
+		for k != nil && bytes.Compare(k, start) >= 0 {
+			k, _ = c.Prev()
+		}
+		for ; k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Prev() {
+			id := k[len(prefix):]
+			data := cases.Get(id)
+			if data == nil {
+				continue
+			}
+			e := &Entry{}
+			if err := json.Unmarshal(data, e); err != nil {
+				return err
+			}
+			if !q.Since.IsZero() && e.Time.Before(q.Since) {
+				// older cases are all before since
+				break
+			}
+			if !e.Match(&q) {
+				continue
+			}
+			if len(res.Cases) == q.Limit {
+				res.Next = res.Cases[len(res.Cases)-1].ID
+				break
+			}
+			res.Cases = append(res.Cases, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
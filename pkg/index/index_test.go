@@ -0,0 +1,298 @@
+package index
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var baseTime = time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+
+// entry returns an entry of the minute after base time, ids sort by time as case ids do.
+func entry(minute int, host, path, state, target string) *Entry {
+	t := baseTime.Add(time.Duration(minute) * time.Minute)
+	return &Entry{
+		ID:           fmt.Sprintf("%s-%06d", t.Format("20060102T150405.000"), minute),
+		Time:         t,
+		Host:         host,
+		Path:         path,
+		URL:          path + "?x=1",
+		State:        state,
+		Target:       target,
+		BaselineHash: fmt.Sprintf("b%d", minute),
+		TestHash:     fmt.Sprintf("t%d", minute),
+	}
+}
+
+func openTestIndex(t *testing.T) *Index {
+	idx, err := Open(filepath.Join(t.TempDir(), "index", "cases.db"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { idx.Close() })
+	return idx
+}
+
+func ids(entries []*Entry) []string {
+	res := []string{}
+	for _, e := range entries {
+		res = append(res, e.ID)
+	}
+	return res
+}
+
+func TestSearch(t *testing.T) {
+	idx := openTestIndex(t)
+	if !idx.Empty() {
+		t.Fatal("Empty: got false for a new index")
+	}
+	entries := []*Entry{
+		entry(0, "a.com", "/a", "CONTENT_NOT_MATCH", "hitori"),
+		entry(1, "a.com", "/a/b", "STATUS_NOT_MATCH", "hitori"),
+		entry(2, "b.com", "/a", "CONTENT_NOT_MATCH", "nginx"),
+		entry(3, "a.com", "/c", "HEADER_NOT_MATCH", "nginx"),
+		entry(4, "b.com", "/a/b", "CONTENT_NOT_MATCH", "hitori"),
+	}
+	for _, e := range entries {
+		if err := idx.Add(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if idx.Empty() {
+		t.Fatal("Empty: got true after adding")
+	}
+	id := func(i ...int) []string {
+		res := []string{}
+		for _, n := range i {
+			res = append(res, entries[n].ID)
+		}
+		return res
+	}
+
+	tests := []struct {
+		name string
+		q    Query
+		want []string
+	}{
+		{"all newest first", Query{}, id(4, 3, 2, 1, 0)},
+		{"host", Query{Host: "a.com"}, id(3, 1, 0)},
+		{"path", Query{Path: "/a"}, id(2, 0)},
+		{"path prefix", Query{PathPrefix: "/a"}, id(4, 2, 1, 0)},
+		{"state", Query{State: "CONTENT_NOT_MATCH"}, id(4, 2, 0)},
+		{"target", Query{Target: "nginx"}, id(3, 2)},
+		{"baseline hash", Query{Hash: "b2"}, id(2)},
+		{"test hash", Query{Hash: "t3"}, id(3)},
+		{"since", Query{Since: entries[2].Time}, id(4, 3, 2)},
+		{"until", Query{Until: entries[1].Time}, id(1, 0)},
+		{"since and until", Query{Since: entries[1].Time, Until: entries[3].Time}, id(3, 2, 1)},
+		{"all fields", Query{Host: "b.com", Path: "/a/b", PathPrefix: "/a", State: "CONTENT_NOT_MATCH", Target: "hitori", Hash: "t4", Since: baseTime}, id(4)},
+		{"host and state", Query{Host: "a.com", State: "CONTENT_NOT_MATCH"}, id(0)},
+		{"since in secondary index", Query{Host: "a.com", Since: entries[1].Time}, id(3, 1)},
+		{"no match", Query{Host: "c.com"}, id()},
+		{"no match of prefix value", Query{Host: "a"}, id()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := idx.Search(tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ids(res.Cases); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Search: got %v, want %v", got, tt.want)
+			}
+			if res.Next != "" {
+				t.Fatalf("Search: got next %s, want none", res.Next)
+			}
+		})
+	}
+}
+
+func TestSearchPages(t *testing.T) {
+	idx := openTestIndex(t)
+	// newest first
+	var all []*Entry
+	for i := 0; i < 7; i++ {
+		host := "a.com"
+		if i%2 == 1 {
+			host = "b.com"
+		}
+		e := entry(i, host, "/a", "CONTENT_NOT_MATCH", "hitori")
+		if err := idx.Add(e); err != nil {
+			t.Fatal(err)
+		}
+		all = append([]*Entry{e}, all...)
+	}
+
+	for _, q := range []Query{{Limit: 3}, {Host: "a.com", Limit: 3}, {Host: "a.com", State: "CONTENT_NOT_MATCH", Limit: 1}} {
+		var want []string
+		for _, e := range all {
+			if e.Match(&q) {
+				want = append(want, e.ID)
+			}
+		}
+
+		var got []string
+		pages := 0
+		for {
+			res, err := idx.Search(q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(res.Cases) > q.Limit {
+				t.Fatalf("Search %+v: got %d cases, beyond limit", q, len(res.Cases))
+			}
+			got = append(got, ids(res.Cases)...)
+			pages++
+			if res.Next == "" {
+				break
+			}
+			if res.Next != res.Cases[len(res.Cases)-1].ID {
+				t.Fatalf("Search %+v: got next %s, not the last case", q, res.Next)
+			}
+			q.Cursor = res.Next
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("Search %+v by pages: got %v, want %v", q, got, want)
+		}
+		if wantPages := (len(want) + q.Limit - 1) / q.Limit; pages != wantPages {
+			t.Fatalf("Search %+v: got %d pages, want %d", q, pages, wantPages)
+		}
+	}
+
+	// limits
+	res, err := idx.Search(Query{Limit: MaxLimit + 1})
+	if err != nil || len(res.Cases) != 7 {
+		t.Fatalf("Search beyond max limit: got %d cases, err: %v", len(res.Cases), err)
+	}
+}
+
+func TestAddDelete(t *testing.T) {
+	idx := openTestIndex(t)
+	e := entry(0, "a.com", "/a", "CONTENT_NOT_MATCH", "hitori")
+	if err := idx.Add(e); err != nil {
+		t.Fatal(err)
+	}
+
+	// replaced with the same id, old secondary keys are removed
+	replaced := *e
+	replaced.Host, replaced.State, replaced.BaselineHash = "b.com", "STATUS_NOT_MATCH", "b9"
+	if err := idx.Add(&replaced); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []Query{{Host: "a.com"}, {State: "CONTENT_NOT_MATCH"}, {Hash: "b0"}} {
+		if res, _ := idx.Search(q); len(res.Cases) != 0 {
+			t.Fatalf("Search %+v after replacing: got %v", q, ids(res.Cases))
+		}
+	}
+	res, _ := idx.Search(Query{Host: "b.com", Hash: "b9"})
+	if len(res.Cases) != 1 || !reflect.DeepEqual(res.Cases[0], &replaced) {
+		t.Fatalf("Search replaced: got %v", res.Cases)
+	}
+
+	if err := idx.Delete(e.ID); err != nil {
+		t.Fatal(err)
+	}
+	// not indexed
+	if err := idx.Delete(e.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []Query{{}, {Host: "b.com"}, {Path: "/a"}, {Target: "hitori"}, {Hash: "t0"}} {
+		if res, _ := idx.Search(q); len(res.Cases) != 0 {
+			t.Fatalf("Search %+v after deleting: got %v", q, ids(res.Cases))
+		}
+	}
+	if !idx.Empty() {
+		t.Fatal("Empty: got false after deleting all")
+	}
+}
+
+func TestDefaultIndexDisabled(t *testing.T) {
+	old := DefaultIndex
+	DefaultIndex = nil
+	defer func() { DefaultIndex = old }()
+	if Enabled() {
+		t.Fatal("Enabled: got true")
+	}
+	if err := Add(entry(0, "a.com", "/a", "", "")); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if err := Delete("x"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := Search(Query{}); err != ErrDisabled {
+		t.Fatalf("Search: got %v, want %v", err, ErrDisabled)
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cases.db")
+	idx, err := Open(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := entry(0, "a.com", "/a", "CONTENT_NOT_MATCH", "hitori")
+	_ = idx.Add(e)
+	idx.Close()
+
+	ro, err := Open(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	res, err := ro.Search(Query{Host: "a.com"})
+	if err != nil || len(res.Cases) != 1 {
+		t.Fatalf("Search read only: got %v, err: %v", res, err)
+	}
+	if err = ro.Add(entry(1, "a.com", "/a", "", "")); err == nil {
+		t.Fatal("Add to read only index: got no error")
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	since := baseTime
+	tests := []struct {
+		name    string
+		values  string
+		want    Query
+		wantErr bool
+	}{
+		{"empty", "", Query{}, false},
+		{"fields", "host=a.com&path=/a&path_prefix=/a/&state=CONTENT_NOT_MATCH&target=hitori&hash=abc&cursor=c1&limit=10",
+			Query{Host: "a.com", Path: "/a", PathPrefix: "/a/", State: "CONTENT_NOT_MATCH", Target: "hitori", Hash: "abc", Cursor: "c1", Limit: 10}, false},
+		{"rfc3339", "since=2026-10-18T10:00:00Z&until=2026-10-18T11:00:00Z",
+			Query{Since: since, Until: since.Add(time.Hour)}, false},
+		{"invalid since", "since=yesterday", Query{}, true},
+		{"invalid until", "until=1", Query{}, true},
+		{"invalid limit", "limit=ten", Query{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, _ := url.ParseQuery(tt.values)
+			got, err := ParseQuery(v)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseQuery: got error %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("ParseQuery: got %+v, want %+v", got, tt.want)
+			}
+			// parsed back from values
+			if back, err := ParseQuery(got.Values()); err != nil || !reflect.DeepEqual(back, got) {
+				t.Fatalf("ParseQuery(Values()): got %+v, %v, want %+v", back, err, got)
+			}
+		})
+	}
+
+	q, err := ParseQuery(url.Values{"since": {"24h"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(q.Since); d < 24*time.Hour || d > 24*time.Hour+time.Minute {
+		t.Fatalf("ParseQuery since 24h: got %s", q.Since)
+	}
+}
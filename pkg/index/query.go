@@ -0,0 +1,72 @@
+package index
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ParseQuery parses url query parameters of case search.
+// since and until are RFC3339 time, or duration before now, eg: 24h
+func ParseQuery(v url.Values) (Query, error) {
+	q := Query{
+		Host:       v.Get("host"),
+		Path:       v.Get("path"),
+		PathPrefix: v.Get("path_prefix"),
+		State:      v.Get("state"),
+		Target:     v.Get("target"),
+		Hash:       v.Get("hash"),
+		Cursor:     v.Get("cursor"),
+	}
+	var err error
+	if q.Since, err = parseTime(v.Get("since")); err != nil {
+		return q, fmt.Errorf("invalid since: %w", err)
+	}
+	if q.Until, err = parseTime(v.Get("until")); err != nil {
+		return q, fmt.Errorf("invalid until: %w", err)
+	}
+	if s := v.Get("limit"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil {
+			return q, fmt.Errorf("invalid limit: %w", err)
+		}
+	}
+	return q, nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// Values returns url query parameters of the query, which can be parsed by ParseQuery.
+func (q Query) Values() url.Values {
+	v := url.Values{}
+	set := func(k, s string) {
+		if s != "" {
+			v.Set(k, s)
+		}
+	}
+	set("host", q.Host)
+	set("path", q.Path)
+	set("path_prefix", q.PathPrefix)
+	set("state", q.State)
+	set("target", q.Target)
+	set("hash", q.Hash)
+	set("cursor", q.Cursor)
+	if !q.Since.IsZero() {
+		v.Set("since", q.Since.Format(time.RFC3339))
+	}
+	if !q.Until.IsZero() {
+		v.Set("until", q.Until.Format(time.RFC3339))
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	return v
+}
@@ -0,0 +1,214 @@
+package ingest
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bocchi-the-cache/inspector/pkg/common/logger"
+	"github.com/bocchi-the-cache/inspector/pkg/monitor"
+	"github.com/bocchi-the-cache/inspector/pkg/validator"
+	"github.com/spf13/viper"
+)
+
+const followInterval = 500 * time.Millisecond
+
+// Ingest replays access log files into validator.
+type Ingest struct {
+	Files  []string
+	Format string
+	Host   string
+	Follow bool
+	// Concurrency is the number of lines parsed at the same time, they're validated by validator workers.
+	Concurrency int
+	QPS         int
+
+	parser Parser
+}
+
+func Enabled() bool {
+	return viper.GetBool("ingest.enable")
+}
+
+// Start replays access logs configured by `ingest` in background.
+func Start() {
+	in := &Ingest{
+		Files:       viper.GetStringSlice("ingest.files"),
+		Format:      viper.GetString("ingest.format"),
+		Host:        viper.GetString("ingest.host"),
+		Follow:      viper.GetBool("ingest.follow"),
+		Concurrency: viper.GetInt("ingest.concurrency"),
+		QPS:         viper.GetInt("ingest.qps"),
+	}
+	go func() {
+		if err := in.Run(); err != nil {
+			logger.Errorf("ingest error, err: %s", err)
+		}
+	}()
+}
+
+// Run reads all files, and returns when all lines are pushed into the validate queue,
+// the last ones may be still waiting for validator workers then.
+// Reading waits while the queue is full, so no line is dropped. In follow mode, it never returns unless error occurs.
+func (in *Ingest) Run() error {
+	if in.Format == "" {
+		in.Format = FormatCombined
+	}
+	parser, err := GetParser(in.Format)
+	if err != nil {
+		return err
+	}
+	in.parser = parser
+	if in.Concurrency <= 0 {
+		in.Concurrency = 1
+	}
+	logger.Infof("ingest start, files: %v, format: %s, follow: %v, concurrency: %d, qps: %d",
+		in.Files, in.Format, in.Follow, in.Concurrency, in.QPS)
+
+	lines := make(chan string, in.Concurrency)
+	wg := sync.WaitGroup{}
+	limit := newLimiter(in.QPS)
+	defer limit.Stop()
+	for i := 0; i < in.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for line := range lines {
+				limit.Wait()
+				in.handleLine(line)
+			}
+		}()
+	}
+
+	var errs []error
+	fwg := sync.WaitGroup{}
+	mu := sync.Mutex{}
+	for _, file := range in.Files {
+		fwg.Add(1)
+		go func(file string) {
+			defer fwg.Done()
+			if err := in.readFile(file, lines); err != nil {
+				logger.Errorf("ingest read file error, file: %s, err: %s", file, err)
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(file)
+	}
+	fwg.Wait()
+	close(lines)
+	wg.Wait()
+	logger.Infof("ingest done, files: %v", in.Files)
+	return errors.Join(errs...)
+}
+
+func (in *Ingest) handleLine(line string) {
+	r, err := in.parser(line, in.Host)
+	if errors.Is(err, errEmptyLine) {
+		return
+	}
+	if err != nil {
+		logger.Debugf("ingest parse line error, err: %s", err)
+		monitor.IngestLineTotalCounterIncr(in.Format, "ParseError")
+		return
+	}
+	// access logs have no request body, only GET requests can be replayed.
+	if r.Method != http.MethodGet {
+		monitor.IngestLineTotalCounterIncr(in.Format, "Skip")
+		return
+	}
+	// validated by the validate queue, with its spool and workers, waiting while the queue is full
+	if !validator.Push(r) {
+		monitor.IngestLineTotalCounterIncr(in.Format, "Filtered")
+		return
+	}
+	monitor.IngestLineTotalCounterIncr(in.Format, "OK")
+}
+
+func (in *Ingest) readFile(file string, lines chan<- string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer func() { f.Close() }()
+
+	var offset int64
+	reader := bufio.NewReader(f)
+	partial := ""
+	for {
+		line, err := reader.ReadString('\n')
+		offset += int64(len(line))
+		if err == nil {
+			lines <- partial + line[:len(line)-1]
+			partial = ""
+			continue
+		}
+		if err != io.EOF {
+			return err
+		}
+		if !in.Follow {
+			if partial+line != "" {
+				lines <- partial + line
+			}
+			return nil
+		}
+
+		// follow mode: keep the partial line, and wait for more content
+		partial += line
+		time.Sleep(followInterval)
+		stat, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		if stat.Size() < offset || !sameFile(f, stat) {
+			// truncated or rotated, reopen from the beginning
+			logger.Infof("ingest file rotated, reopen, file: %s", file)
+			nf, err := os.Open(file)
+			if err != nil {
+				continue
+			}
+			f.Close()
+			f = nf
+			reader.Reset(f)
+			offset = 0
+			partial = ""
+		}
+	}
+}
+
+func sameFile(f *os.File, stat os.FileInfo) bool {
+	fstat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return os.SameFile(fstat, stat)
+}
+
+type limiter struct {
+	ticker *time.Ticker
+}
+
+// newLimiter limits Wait() to qps times per second, no limit if qps <= 0,
+// or qps is above one per nanosecond, which a ticker can't tick.
+func newLimiter(qps int) *limiter {
+	if qps <= 0 || int64(qps) > int64(time.Second) {
+		return &limiter{}
+	}
+	return &limiter{ticker: time.NewTicker(time.Second / time.Duration(qps))}
+}
+
+func (l *limiter) Wait() {
+	if l.ticker != nil {
+		<-l.ticker.C
+	}
+}
+
+func (l *limiter) Stop() {
+	if l.ticker != nil {
+		l.ticker.Stop()
+	}
+}
@@ -0,0 +1,59 @@
+package ingest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{"empty", "", nil},
+		{"lines", "a\nb\n", []string{"a", "b"}},
+		{"last line without newline", "a\nb", []string{"a", "b"}},
+		{"empty lines are kept for parsers", "a\n\nb\n", []string{"a", "", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "access.log")
+			if err := os.WriteFile(file, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			lines := make(chan string, 10)
+			if err := (&Ingest{}).readFile(file, lines); err != nil {
+				t.Fatalf("readFile: %v", err)
+			}
+			close(lines)
+			var got []string
+			for l := range lines {
+				got = append(got, l)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) || len(got) != len(tt.want) {
+				t.Fatalf("readFile: got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if err := (&Ingest{}).readFile(filepath.Join(t.TempDir(), "missing.log"), nil); err == nil {
+		t.Fatal("readFile missing file: got no error")
+	}
+}
+
+func TestNewLimiter(t *testing.T) {
+	for _, qps := range []int{0, -1, 2e9} {
+		if l := newLimiter(qps); l.ticker != nil {
+			t.Errorf("newLimiter(%d): got a ticker, want no limit", qps)
+		}
+	}
+	l := newLimiter(1000)
+	defer l.Stop()
+	if l.ticker == nil {
+		t.Fatal("newLimiter(1000): got no limit")
+	}
+	l.Wait()
+}
@@ -0,0 +1,137 @@
+package ingest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+)
+
+const (
+	// FormatCombined is nginx combined log format:
+	// $remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"
+	FormatCombined = "combined"
+	// FormatJSON is one json object per line, with fields: method, host, uri (or url), headers.
+	FormatJSON = "json"
+	// FormatHitori is hitori's access log, zap json lines with fields: method, host, url.
+	FormatHitori = "hitori"
+)
+
+var errEmptyLine = errors.New("empty line")
+
+// Parser parses one log line into a request, host is used when the line has no host.
+type Parser func(line, host string) (*client.Request, error)
+
+var parsers = map[string]Parser{
+	FormatCombined: parseCombined,
+	FormatJSON:     parseJSON,
+	FormatHitori:   parseHitori,
+}
+
+func GetParser(format string) (Parser, error) {
+	p, ok := parsers[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown ingest format: %s", format)
+	}
+	return p, nil
+}
+
+var combinedRegexp = regexp.MustCompile(`^(\S+) \S+ (\S+) \[([^]]+)\] "([^"]*)" (\d{3}) (\S+)(?: "([^"]*)" "([^"]*)")?`)
+
+func parseCombined(line, host string) (*client.Request, error) {
+	if strings.TrimSpace(line) == "" {
+		return nil, errEmptyLine
+	}
+	m := combinedRegexp.FindStringSubmatch(line)
+	if m == nil {
+		return nil, fmt.Errorf("invalid combined log line: %s", line)
+	}
+	// "$request" eg: GET /blabla/123/abc.txt HTTP/1.1
+	fields := strings.Fields(m[4])
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("invalid request line: %s", m[4])
+	}
+	header := http.Header{}
+	if m[7] != "" && m[7] != "-" {
+		header.Set("Referer", m[7])
+	}
+	if m[8] != "" && m[8] != "-" {
+		header.Set("User-Agent", m[8])
+	}
+	return newRequest(fields[0], host, fields[1], header)
+}
+
+type jsonLine struct {
+	Method  string            `json:"method"`
+	Host    string            `json:"host"`
+	URI     string            `json:"uri"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+}
+
+func parseJSON(line, host string) (*client.Request, error) {
+	if strings.TrimSpace(line) == "" {
+		return nil, errEmptyLine
+	}
+	l := &jsonLine{}
+	if err := json.Unmarshal([]byte(line), l); err != nil {
+		return nil, err
+	}
+	uri := l.URI
+	if uri == "" {
+		uri = l.URL
+	}
+	if l.Host != "" {
+		host = l.Host
+	}
+	header := http.Header{}
+	for k, v := range l.Headers {
+		header.Set(k, v)
+	}
+	return newRequest(l.Method, host, uri, header)
+}
+
+func parseHitori(line, host string) (*client.Request, error) {
+	if strings.TrimSpace(line) == "" {
+		return nil, errEmptyLine
+	}
+	l := &jsonLine{}
+	if err := json.Unmarshal([]byte(line), l); err != nil {
+		return nil, err
+	}
+	if l.Host != "" {
+		host = l.Host
+	}
+	return newRequest(l.Method, host, l.URL, http.Header{})
+}
+
+func newRequest(method, host, uri string, header http.Header) (*client.Request, error) {
+	if method == "" || uri == "" {
+		return nil, fmt.Errorf("missing method or uri, method: %q, uri: %q", method, uri)
+	}
+	if host == "" {
+		return nil, errors.New("missing host, set ingest.host for logs without host")
+	}
+	// full url in request line, eg: GET http://example.com/abc.txt HTTP/1.1
+	if strings.HasPrefix(uri, "http://") || strings.HasPrefix(uri, "https://") {
+		rest := uri[strings.Index(uri, "//")+2:]
+		if i := strings.Index(rest, "/"); i >= 0 {
+			host, uri = rest[:i], rest[i:]
+		} else {
+			host, uri = rest, "/"
+		}
+	}
+	if !strings.HasPrefix(uri, "/") {
+		return nil, fmt.Errorf("invalid uri: %s", uri)
+	}
+	return &client.Request{
+		Method: strings.ToUpper(method),
+		Host:   host,
+		URL:    uri,
+		Header: header,
+	}, nil
+}
@@ -0,0 +1,91 @@
+package ingest
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+)
+
+func TestParsers(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		line    string
+		host    string
+		want    *client.Request
+		wantErr bool
+	}{
+		{"combined", FormatCombined,
+			`127.0.0.1 - - [18/Oct/2026:10:00:00 +0000] "GET /a/b.txt?x=1 HTTP/1.1" 200 612 "http://ref.com/" "curl/8.0"`,
+			"a.com", &client.Request{Method: http.MethodGet, Host: "a.com", URL: "/a/b.txt?x=1",
+				Header: http.Header{"Referer": {"http://ref.com/"}, "User-Agent": {"curl/8.0"}}}, false},
+		{"combined without referer and user agent", FormatCombined,
+			`127.0.0.1 - - [18/Oct/2026:10:00:00 +0000] "GET /a HTTP/1.1" 304 0 "-" "-"`,
+			"a.com", &client.Request{Method: http.MethodGet, Host: "a.com", URL: "/a", Header: http.Header{}}, false},
+		{"common log format", FormatCombined,
+			`127.0.0.1 - bob [18/Oct/2026:10:00:00 +0000] "HEAD /a HTTP/1.0" 200 -`,
+			"a.com", &client.Request{Method: http.MethodHead, Host: "a.com", URL: "/a", Header: http.Header{}}, false},
+		{"combined full url", FormatCombined,
+			`127.0.0.1 - - [18/Oct/2026:10:00:00 +0000] "GET http://b.com:8080/a?x=1 HTTP/1.1" 200 1 "-" "-"`,
+			"a.com", &client.Request{Method: http.MethodGet, Host: "b.com:8080", URL: "/a?x=1", Header: http.Header{}}, false},
+		{"combined full url without path", FormatCombined,
+			`127.0.0.1 - - [18/Oct/2026:10:00:00 +0000] "GET https://b.com HTTP/1.1" 200 1 "-" "-"`,
+			"a.com", &client.Request{Method: http.MethodGet, Host: "b.com", URL: "/", Header: http.Header{}}, false},
+		{"combined invalid request line", FormatCombined,
+			`127.0.0.1 - - [18/Oct/2026:10:00:00 +0000] "-" 400 0 "-" "-"`, "a.com", nil, true},
+		{"combined without host", FormatCombined,
+			`127.0.0.1 - - [18/Oct/2026:10:00:00 +0000] "GET /a HTTP/1.1" 200 1 "-" "-"`, "", nil, true},
+		{"combined garbage", FormatCombined, `not a log line`, "a.com", nil, true},
+
+		{"json", FormatJSON, `{"method":"get","host":"b.com","uri":"/a?x=1","headers":{"user-agent":"curl"}}`,
+			"a.com", &client.Request{Method: http.MethodGet, Host: "b.com", URL: "/a?x=1",
+				Header: http.Header{"User-Agent": {"curl"}}}, false},
+		{"json url and default host", FormatJSON, `{"method":"GET","url":"/a"}`,
+			"a.com", &client.Request{Method: http.MethodGet, Host: "a.com", URL: "/a", Header: http.Header{}}, false},
+		{"json uri before url", FormatJSON, `{"method":"GET","uri":"/uri","url":"/url"}`,
+			"a.com", &client.Request{Method: http.MethodGet, Host: "a.com", URL: "/uri", Header: http.Header{}}, false},
+		{"json without method", FormatJSON, `{"uri":"/a"}`, "a.com", nil, true},
+		{"json relative uri", FormatJSON, `{"method":"GET","uri":"a"}`, "a.com", nil, true},
+		{"json invalid", FormatJSON, `{"method":`, "a.com", nil, true},
+
+		{"hitori", FormatHitori, `{"level":"info","ts":1760000000,"msg":"access","method":"GET","host":"b.com","url":"/a?x=1","status":200}`,
+			"a.com", &client.Request{Method: http.MethodGet, Host: "b.com", URL: "/a?x=1", Header: http.Header{}}, false},
+		{"hitori ignores uri", FormatHitori, `{"method":"GET","uri":"/a"}`, "a.com", nil, true},
+		{"hitori invalid", FormatHitori, `access GET /a`, "a.com", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parse, err := GetParser(tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parse(tt.line, tt.host)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parse %q: got error %v, want error %v", tt.line, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parse %q: got %+v, want %+v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsersEmptyLine(t *testing.T) {
+	for format := range parsers {
+		parse, _ := GetParser(format)
+		for _, line := range []string{"", "  ", "\r"} {
+			if _, err := parse(line, "a.com"); !errors.Is(err, errEmptyLine) {
+				t.Errorf("%s parse %q: got error %v, want %v", format, line, err, errEmptyLine)
+			}
+		}
+	}
+}
+
+func TestGetParserUnknown(t *testing.T) {
+	if _, err := GetParser("apache"); err == nil {
+		t.Fatal("GetParser(apache): got no error")
+	}
+}
@@ -20,7 +20,7 @@ var (
 	ResultTotalCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "bocchi_inspector_result_total",
 		Help: "result of http content checking",
-	}, []string{"node", "method", "status"})
+	}, []string{"node", "method", "target", "status"})
 
 	ErrorTotalCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "bocchi_inspector_error_total",
@@ -31,7 +31,47 @@ var (
 		Name:    "RequestTimeConsumingStatistics",
 		Help:    "api request elapsed time histogram",
 		Buckets: []float64{0.1, 0.5, 1, 5, 10, 20, 50, 100, 500, 1000, 5000},
-	}, []string{"node", "process"})
+	}, []string{"node", "process", "target"})
+
+	RequestDropTotalCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "bocchi_inspector_request_drop_total",
+		Help: "total number of requests dropped before validating",
+	}, []string{"node", "method", "reason"})
+
+	ValidateQueueDepthGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "bocchi_inspector_validate_queue_depth",
+		Help: "number of requests waiting in validate queue",
+	}, []string{"node"})
+
+	ValidateBusyWorkersGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "bocchi_inspector_validate_busy_workers",
+		Help: "number of validate workers which are busy",
+	}, []string{"node"})
+
+	RequestFilterTotalCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "bocchi_inspector_request_filter_total",
+		Help: "total number of requests filtered out by filter rules",
+	}, []string{"node", "method", "reason"})
+
+	IngestLineTotalCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "bocchi_inspector_ingest_line_total",
+		Help: "total number of access log lines ingested",
+	}, []string{"node", "format", "status"})
+
+	BadCaseEvictTotalCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "bocchi_inspector_bad_case_evict_total",
+		Help: "total number of bad cases evicted from storage",
+	}, []string{"node", "reason"})
+
+	BadCaseStoreSizeGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "bocchi_inspector_bad_case_store_bytes",
+		Help: "total size of bad cases in storage",
+	}, []string{"node"})
+
+	BadCaseStoreCasesGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "bocchi_inspector_bad_case_store_cases",
+		Help: "number of bad cases in storage",
+	}, []string{"node"})
 
 	node = "unknown"
 )
@@ -44,21 +84,56 @@ func RequestSendTotalCounterIncr(method, host, dst, status string) {
 	RequestSendTotalCounter.WithLabelValues(node, method, host, dst, status).Inc()
 }
 
-func ResultTotalCounterIncr(method, status string) {
-	ResultTotalCounter.WithLabelValues(node, method, status).Inc()
+func ResultTotalCounterIncr(method, target, status string) {
+	ResultTotalCounter.WithLabelValues(node, method, target, status).Inc()
 }
 
 func ErrorTotalCounterIncr(method, process, error string) {
 	ErrorTotalCounter.WithLabelValues(node, method, process, error).Inc()
 }
 
-func ElapsedMonitorIncr(process string, elapsed float64) {
-	ElapsedMonitor.WithLabelValues(node, process).Observe(elapsed)
+func ElapsedMonitorIncr(process, target string, elapsed float64) {
+	ElapsedMonitor.WithLabelValues(node, process, target).Observe(elapsed)
+}
+
+func RequestDropTotalCounterIncr(method, reason string) {
+	RequestDropTotalCounter.WithLabelValues(node, method, reason).Inc()
+}
+
+func ValidateQueueDepthSet(depth int) {
+	ValidateQueueDepthGauge.WithLabelValues(node).Set(float64(depth))
+}
+
+func ValidateBusyWorkersIncr() {
+	ValidateBusyWorkersGauge.WithLabelValues(node).Inc()
+}
+
+func ValidateBusyWorkersDecr() {
+	ValidateBusyWorkersGauge.WithLabelValues(node).Dec()
+}
+
+func RequestFilterTotalCounterIncr(method, reason string) {
+	RequestFilterTotalCounter.WithLabelValues(node, method, reason).Inc()
+}
+
+func IngestLineTotalCounterIncr(format, status string) {
+	IngestLineTotalCounter.WithLabelValues(node, format, status).Inc()
+}
+
+func BadCaseEvictTotalCounterIncr(reason string) {
+	BadCaseEvictTotalCounter.WithLabelValues(node, reason).Inc()
+}
+
+func BadCaseStoreSet(size int64, cases int) {
+	BadCaseStoreSizeGauge.WithLabelValues(node).Set(float64(size))
+	BadCaseStoreCasesGauge.WithLabelValues(node).Set(float64(cases))
 }
 
 func Init() {
 	node = getNodeIp()
-	prometheus.MustRegister(RequestReceiveTotalCounter, RequestSendTotalCounter, ResultTotalCounter, ErrorTotalCounter, ElapsedMonitor)
+	prometheus.MustRegister(RequestReceiveTotalCounter, RequestSendTotalCounter, ResultTotalCounter, ErrorTotalCounter, ElapsedMonitor,
+		RequestDropTotalCounter, ValidateQueueDepthGauge, ValidateBusyWorkersGauge, IngestLineTotalCounter,
+		RequestFilterTotalCounter, BadCaseEvictTotalCounter, BadCaseStoreSizeGauge, BadCaseStoreCasesGauge)
 }
 
 // Get node ip by net.InterfaceAddrs()
@@ -1,7 +1,11 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
 	"github.com/bocchi-the-cache/inspector/pkg/common/logger"
+	"github.com/bocchi-the-cache/inspector/pkg/index"
+	"github.com/bocchi-the-cache/inspector/pkg/ingest"
 	"github.com/bocchi-the-cache/inspector/pkg/validator"
 	"github.com/spf13/viper"
 	"io"
@@ -10,23 +14,72 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+const (
+	// ModeMirror returns 200 OK immediately, and validate the request in background.
+	ModeMirror = "mirror"
+	// ModeProxy returns baseline response to the caller, and validate the request in background.
+	ModeProxy = "proxy"
+)
+
 func Serve() {
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", promhttp.Handler())
-	mux.HandleFunc("/", dispatchRequest)
 
-	logger.Infof("*** start http server, listen port: %s", viper.GetString("http.listen_port"))
+	mode := viper.GetString("http.mode")
+	switch mode {
+	case ModeProxy:
+		mux.HandleFunc("/", proxyRequest)
+	case ModeMirror, "":
+		mode = ModeMirror
+		mux.HandleFunc("/", dispatchRequest)
+	default:
+		logger.Panicf("unknown http mode: %s", mode)
+	}
+
+	logger.Infof("*** start http server, listen port: %s, mode: %s", viper.GetString("http.listen_port"), mode)
 	logger.Infof("*** metrics endpoint: %s", "/metrics")
-	logger.Infof("*** note: Inspector returns 200 OK immediately, and validate the request in background.")
-	logger.Infof("*** only **GET** requests will be validated. ")
+	if addr := viper.GetString("http.admin_listen"); addr != "" {
+		go serveAdmin(addr)
+	} else {
+		logger.Infof("*** admin api disabled, http.admin_listen is empty")
+	}
+	if mode == ModeProxy {
+		logger.Infof("*** note: Inspector returns baseline response, and validate the request in background.")
+	} else {
+		logger.Infof("*** note: Inspector returns 200 OK immediately, and validate the request in background.")
+	}
+	logger.Infof("*** only %v requests will be validated. ", validator.Methods())
+	if ingest.Enabled() {
+		logger.Infof("*** access log ingest enabled, files: %v", viper.GetStringSlice("ingest.files"))
+		ingest.Start()
+	}
 	err := http.ListenAndServe(":"+viper.GetString("http.listen_port"), mux)
 	if err != nil {
 		logger.Panicf("http server error, err: %s", err)
 	}
 }
 
+// serveAdmin serves case apis on their own listener, apart from the traffic being validated.
+func serveAdmin(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/api/cases", searchCases)
+	mux.HandleFunc("/api/cases/reverify", reverifyCases)
+	mux.HandleFunc("/api/cases/export", exportCase)
+	mux.HandleFunc("/api/cases/diff", caseDiff)
+
+	logger.Infof("*** start admin http server, listen: %s", addr)
+	logger.Infof("*** case export endpoint: %s", "/api/cases/export")
+	if index.Enabled() {
+		logger.Infof("*** case search endpoint: %s", "/api/cases")
+	}
+	err := http.ListenAndServe(addr, mux)
+	if err != nil {
+		logger.Panicf("admin http server error, err: %s", err)
+	}
+}
+
 func dispatchRequest(w http.ResponseWriter, r *http.Request) {
-	// TODO Chan Pipeline
 	validator.PushRequest(r)
 	_, err := io.WriteString(w, "Hello, HTTP!\n")
 	if err != nil {
@@ -34,3 +87,132 @@ func dispatchRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+func proxyRequest(w http.ResponseWriter, r *http.Request) {
+	// baseline response is streamed to the caller, an error means nothing is written yet
+	if err := validator.ProxyRequest(w, r); err != nil {
+		logger.Errorf("proxy baseline request error, host: %s, url: %s, err: %s", r.Host, r.URL, err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+	}
+}
+
+// searchCases searches bad cases by query parameters, see index.ParseQuery.
+func searchCases(w http.ResponseWriter, r *http.Request) {
+	q, err := index.ParseQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	res, err := index.Search(q)
+	if errors.Is(err, index.ErrDisabled) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Errorf("search cases error, query: %s, err: %s", r.URL.RawQuery, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, res)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Errorf("write response error, err: %s", err)
+	}
+}
+
+// reverifyCases starts a reverify job by POST, of stored cases selected by query parameters of searchCases,
+// all=true is required without filters, fixed cases are archived if archive=true.
+// GET returns the job of job=<id>.
+func reverifyCases(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		job, err := validator.GetReverifyJob(r.URL.Query().Get("job"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, job)
+		return
+	case http.MethodPost:
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	q, err := index.ParseQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	job, err := validator.StartReverify(validator.ReverifyOptions{
+		Query:   q,
+		All:     r.URL.Query().Get("all") == "true",
+		Archive: r.URL.Query().Get("archive") == "true",
+	})
+	switch {
+	case errors.Is(err, validator.ErrReverifyNoFilter):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, validator.ErrReverifyRunning):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		logger.Errorf("reverify cases error, query: %s, err: %s", r.URL.RawQuery, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", "/api/cases/reverify?job="+job.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err = json.NewEncoder(w).Encode(job); err != nil {
+		logger.Errorf("write response error, err: %s", err)
+	}
+}
+
+// exportCase exports the case of id as format=curl (default) or format=go, see validator.Export.
+func exportCase(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	data, err := validator.Export(id, r.URL.Query().Get("format"))
+	switch {
+	case errors.Is(err, validator.ErrCaseNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, validator.ErrExportFormat):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		logger.Errorf("export case error, id: %s, err: %s", id, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if _, err = w.Write(data); err != nil {
+		logger.Errorf("write response error, err: %s", err)
+	}
+}
+
+// caseDiff returns the diff artifact of the case of id, as text, or json if format=json.
+func caseDiff(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	d, err := validator.ReadDiff(id)
+	if errors.Is(err, validator.ErrCaseNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Errorf("read case diff error, id: %s, err: %s", id, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if r.URL.Query().Get("format") == "json" {
+		writeJSON(w, d)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if _, err = io.WriteString(w, d.String()); err != nil {
+		logger.Errorf("write response error, err: %s", err)
+	}
+}
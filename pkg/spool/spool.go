@@ -0,0 +1,220 @@
+package spool
+
+// Spool is a write-ahead log of accepted requests.
+// Each accepted request appends an "add" record, and an "done" record is appended after validating.
+// On startup, requests without "done" record are pending, and will be validated again.
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+	"github.com/bocchi-the-cache/inspector/pkg/common/logger"
+)
+
+const (
+	opAdd  = "add"
+	opDone = "done"
+
+	fileName = "spool.log"
+
+	// compact spool file after so many done records
+	compactThreshold = 10000
+)
+
+type record struct {
+	Op      string          `json:"op"`
+	ID      uint64          `json:"id"`
+	Request *client.Request `json:"req,omitempty"`
+}
+
+type Entry struct {
+	ID      uint64
+	Request *client.Request
+}
+
+type Spool struct {
+	mu      sync.Mutex
+	path    string
+	sync    bool
+	f       *os.File
+	w       *bufio.Writer
+	seq     uint64
+	done    int
+	pending map[uint64]*client.Request
+}
+
+// Open opens the spool in dir, and loads pending requests from the last run.
+func Open(dir string, sync bool) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &Spool{
+		path:    filepath.Join(dir, fileName),
+		sync:    sync,
+		pending: make(map[uint64]*client.Request),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Spool) load() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024*1024)
+	for scanner.Scan() {
+		rec := &record{}
+		if err := json.Unmarshal(scanner.Bytes(), rec); err != nil {
+			// the last record may be partially written when crashed
+			logger.Warnf("skip broken spool record, err: %s", err)
+			continue
+		}
+		switch rec.Op {
+		case opAdd:
+			s.pending[rec.ID] = rec.Request
+		case opDone:
+			delete(s.pending, rec.ID)
+		}
+		if rec.ID > s.seq {
+			s.seq = rec.ID
+		}
+	}
+	return scanner.Err()
+}
+
+// Pending returns requests which are accepted but not validated, in accepted order.
+func (s *Spool) Pending() []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make([]Entry, 0, len(s.pending))
+	for id, r := range s.pending {
+		entries = append(entries, Entry{ID: id, Request: r})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+	return entries
+}
+
+// Add appends an accepted request, and returns its id.
+func (s *Spool) Add(r *client.Request) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	id := s.seq
+	if err := s.append(&record{Op: opAdd, ID: id, Request: r}); err != nil {
+		return 0, err
+	}
+	s.pending[id] = r
+	return id, nil
+}
+
+// Done marks the request as validated.
+func (s *Spool) Done(id uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.pending[id]; !ok {
+		return nil
+	}
+	delete(s.pending, id)
+	if err := s.append(&record{Op: opDone, ID: id}); err != nil {
+		return err
+	}
+	s.done++
+	if s.done >= compactThreshold {
+		return s.compact()
+	}
+	return nil
+}
+
+func (s *Spool) append(rec *record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err = s.w.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if err = s.w.Flush(); err != nil {
+		return err
+	}
+	if s.sync {
+		return s.f.Sync()
+	}
+	return nil
+}
+
+// compact rewrites the spool file with pending requests only.
+func (s *Spool) compact() error {
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	ids := make([]uint64, 0, len(s.pending))
+	for id := range s.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		if err = enc.Encode(&record{Op: opAdd, ID: id, Request: s.pending[id]}); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+
+	if s.f != nil {
+		s.f.Close()
+	}
+	s.f, err = os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	s.w = bufio.NewWriter(s.f)
+	s.done = 0
+	return nil
+}
+
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
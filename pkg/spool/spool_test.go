@@ -0,0 +1,164 @@
+package spool
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+	"github.com/bocchi-the-cache/inspector/pkg/common/logger"
+)
+
+func TestMain(m *testing.M) {
+	// logs go next to the test binary
+	logger.InitLogger("log", "log.txt", "error")
+	os.Exit(m.Run())
+}
+
+func pendingURLs(entries []Entry) []string {
+	urls := make([]string, 0, len(entries))
+	for _, e := range entries {
+		urls = append(urls, e.Request.URL)
+	}
+	return urls
+}
+
+func TestSpoolReplay(t *testing.T) {
+	tests := []struct {
+		name string
+		urls []string
+		// done are indexes of urls validated before crashing
+		done []int
+		// tail is appended to the spool file, eg: a partially written record
+		tail string
+		want []string
+	}{
+		{"empty", nil, nil, "", []string{}},
+		{"all pending", []string{"/a", "/b", "/c"}, nil, "", []string{"/a", "/b", "/c"}},
+		{"all done", []string{"/a", "/b"}, []int{1, 0}, "", []string{}},
+		{"some done", []string{"/a", "/b", "/c", "/d"}, []int{0, 2}, "", []string{"/b", "/d"}},
+		{"partial record", []string{"/a", "/b"}, []int{0}, `{"op":"add","id":3,"req":{"Meth`, []string{"/b"}},
+		{"broken record in the middle", []string{"/a"}, nil, "garbage\n" + `{"op":"done","id":1}` + "\n", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s, err := Open(dir, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []uint64
+			for _, u := range tt.urls {
+				id, err := s.Add(&client.Request{Method: http.MethodPost, Host: "a.com", URL: u,
+					Header: http.Header{"X-Url": {u}}, Body: []byte("body of " + u)})
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids = append(ids, id)
+			}
+			for _, i := range tt.done {
+				if err = s.Done(ids[i]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// done twice is a no-op
+			for _, i := range tt.done {
+				if err = s.Done(ids[i]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err = s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if tt.tail != "" {
+				f, err := os.OpenFile(filepath.Join(dir, fileName), os.O_APPEND|os.O_WRONLY, 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, _ = f.WriteString(tt.tail)
+				f.Close()
+			}
+
+			s, err = Open(dir, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			pending := s.Pending()
+			if got := pendingURLs(pending); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Fatalf("Pending: got %v, want %v", got, tt.want)
+			}
+			for _, e := range pending {
+				r := e.Request
+				if r.Method != http.MethodPost || r.Host != "a.com" || r.Header.Get("X-Url") != r.URL || string(r.Body) != "body of "+r.URL {
+					t.Fatalf("Pending: request is not replayed as it is: %+v", r)
+				}
+			}
+
+			// the file is compacted to pending requests on open
+			f, err := os.Open(filepath.Join(dir, fileName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := 0
+			for scanner := bufio.NewScanner(f); scanner.Scan(); lines++ {
+				if !strings.HasPrefix(scanner.Text(), `{"op":"add"`) {
+					t.Fatalf("compacted record is not an add: %s", scanner.Text())
+				}
+			}
+			f.Close()
+			if lines != len(tt.want) {
+				t.Fatalf("compacted records: got %d, want %d", lines, len(tt.want))
+			}
+
+			// ids keep growing after replay
+			id, err := s.Add(&client.Request{Method: http.MethodGet, URL: "/new"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range pending {
+				if id <= e.ID {
+					t.Fatalf("Add after replay: got id %d, not after pending id %d", id, e.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestSpoolDoneAfterReplay(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []string{"/a", "/b", "/c"} {
+		if _, err = s.Add(&client.Request{Method: http.MethodGet, URL: u}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+
+	// replayed requests are marked done by their ids, across another restart
+	s, err = Open(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending := s.Pending()
+	if err = s.Done(pending[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = Open(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got := pendingURLs(s.Pending()); fmt.Sprint(got) != "[/a /c]" {
+		t.Fatalf("Pending: got %v, want [/a /c]", got)
+	}
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bocchi-the-cache/inspector/pkg/common/logger"
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltBucket = []byte("inspector")
+
+// BoltStorage stores keys in an embedded bolt database file.
+// Values are prefixed by 8 bytes of write time in unix nanoseconds.
+type BoltStorage struct {
+	DB *bolt.DB
+}
+
+func NewBoltStorage(path string) (*BoltStorage, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStorage{DB: db}, nil
+}
+
+func (b *BoltStorage) Write(key string, content []byte) error {
+	value := make([]byte, 8+len(content))
+	binary.BigEndian.PutUint64(value, uint64(time.Now().UnixNano()))
+	copy(value[8:], content)
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), value)
+	})
+}
+
+func (b *BoltStorage) Read(key string) ([]byte, bool) {
+	var content []byte
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(boltBucket).Get([]byte(key))
+		if len(value) >= 8 {
+			// value is only valid in the transaction
+			content = append([]byte{}, value[8:]...)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Errorf("read bolt error, key: %s, err: %s", key, err)
+		return nil, false
+	}
+	return content, content != nil
+}
+
+func (b *BoltStorage) Delete(key string) error {
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete([]byte(key))
+	})
+}
+
+// Walk collects keys in a read transaction before calling fn, so fn may modify the storage.
+func (b *BoltStorage) Walk(fn WalkFunc) error {
+	type entry struct {
+		key     string
+		size    int64
+		modTime time.Time
+	}
+	var entries []entry
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).ForEach(func(k, v []byte) error {
+			if len(v) < 8 {
+				return nil
+			}
+			entries = append(entries, entry{
+				key:     string(k),
+				size:    int64(len(v) - 8),
+				modTime: time.Unix(0, int64(binary.BigEndian.Uint64(v))),
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err = fn(e.key, e.size, e.modTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
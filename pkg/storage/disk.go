@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	diskv "github.com/peterbourgon/diskv/v3"
+)
+
+// DiskStorage stores keys as files under base path, key "a/b" is file "a/b.file".
+type DiskStorage struct {
+	C *diskv.Diskv
+}
+
+func NewDiskStorage(basePath string) *DiskStorage {
+	// Disk K-V storage storage
+	storage := diskv.New(diskv.Options{
+		BasePath:          basePath,
+		AdvancedTransform: DiskCacheAdvancedTransform,
+		InverseTransform:  DiskCacheInverseTransform,
+		CacheSizeMax:      1024 * 1024 * 1024 * 1, // 1GB
+	})
+	return &DiskStorage{
+		C: storage,
+	}
+}
+
+func (d *DiskStorage) Write(key string, content []byte) error {
+	return d.C.Write(key, content)
+}
+
+func (d *DiskStorage) Read(key string) ([]byte, bool) {
+	content, err := d.C.Read(key)
+	if err != nil {
+		return nil, false
+	}
+	return content, true
+}
+
+// Delete erases the key, and removes empty directories.
+func (d *DiskStorage) Delete(key string) error {
+	if err := d.C.Erase(key); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Walk calls fn for each stored key, and stops at the first error.
+func (d *DiskStorage) Walk(fn WalkFunc) error {
+	base := d.C.BasePath
+	err := filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".file") {
+			return nil
+		}
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		key := strings.TrimSuffix(filepath.ToSlash(rel), ".file")
+		return fn(key, info.Size(), info.ModTime())
+	})
+	return err
+}
+
+func DiskCacheAdvancedTransform(key string) *diskv.PathKey {
+	path := strings.Split(key, "/")
+	last := len(path) - 1
+	return &diskv.PathKey{
+		Path:     path[:last],
+		FileName: path[last] + ".file",
+	}
+}
+
+func DiskCacheInverseTransform(pathKey *diskv.PathKey) (key string) {
+	txt := pathKey.FileName[len(pathKey.FileName)-5:]
+	if txt != ".file" {
+		panic("Invalid file found in storage folder!")
+	}
+	return strings.Join(pathKey.Path, "/") + pathKey.FileName[:len(pathKey.FileName)-4]
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/bocchi-the-cache/inspector/pkg/common/logger"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// timeout of each request to object storage
+const s3Timeout = 30 * time.Second
+
+type S3Options struct {
+	// Endpoint is host[:port] of object storage, eg: s3.amazonaws.com, 127.0.0.1:9000
+	Endpoint string
+	Region   string
+	// Bucket is created if it doesn't exist.
+	Bucket string
+	// Prefix is prepended to all keys, eg: "inspector/"
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	// Secure uses https.
+	Secure bool
+	// PathStyle addresses bucket by path instead of virtual host, as most self-hosted stores need.
+	PathStyle bool
+}
+
+// S3Storage stores keys as objects of S3-compatible object storage.
+type S3Storage struct {
+	C      *minio.Client
+	bucket string
+	prefix string
+}
+
+func NewS3Storage(opt S3Options) (*S3Storage, error) {
+	lookup := minio.BucketLookupAuto
+	if opt.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+	c, err := minio.New(opt.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(opt.AccessKey, opt.SecretKey, ""),
+		Secure:       opt.Secure,
+		Region:       opt.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+	ok, err := c.BucketExists(ctx, opt.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if err = c.MakeBucket(ctx, opt.Bucket, minio.MakeBucketOptions{Region: opt.Region}); err != nil {
+			return nil, err
+		}
+		logger.Infof("s3 bucket created, bucket: %s", opt.Bucket)
+	}
+	return &S3Storage{
+		C:      c,
+		bucket: opt.Bucket,
+		prefix: opt.Prefix,
+	}, nil
+}
+
+func (s *S3Storage) Write(key string, content []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+	_, err := s.C.PutObject(ctx, s.bucket, s.prefix+key, bytes.NewReader(content), int64(len(content)),
+		minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	return err
+}
+
+func (s *S3Storage) Read(key string) ([]byte, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+	obj, err := s.C.GetObject(ctx, s.bucket, s.prefix+key, minio.GetObjectOptions{})
+	if err != nil {
+		logger.Errorf("get s3 object error, key: %s, err: %s", key, err)
+		return nil, false
+	}
+	defer obj.Close()
+	content, err := io.ReadAll(obj)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+			logger.Errorf("read s3 object error, key: %s, err: %s", key, err)
+		}
+		return nil, false
+	}
+	return content, true
+}
+
+func (s *S3Storage) Delete(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+	return s.C.RemoveObject(ctx, s.bucket, s.prefix+key, minio.RemoveObjectOptions{})
+}
+
+func (s *S3Storage) Walk(fn WalkFunc) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for obj := range s.C.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: s.prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return obj.Err
+		}
+		if err := fn(strings.TrimPrefix(obj.Key, s.prefix), obj.Size, obj.LastModified); err != nil {
+			return err
+		}
+	}
+	return nil
+}
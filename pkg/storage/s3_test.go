@@ -0,0 +1,343 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bocchi-the-cache/inspector/pkg/common/logger"
+)
+
+func TestMain(m *testing.M) {
+	// logs go next to the test binary
+	logger.InitLogger("log", "log.txt", "error")
+	os.Exit(m.Run())
+}
+
+// fakeS3 is a path-style S3 stand-in, serving just what S3Storage calls.
+// Listing returns pageSize objects per page, so pagination is exercised with a few objects.
+type fakeS3 struct {
+	pageSize int
+
+	mu      sync.Mutex
+	buckets map[string]map[string][]byte
+	lists   int
+}
+
+type fakeS3Object struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+}
+
+type fakeS3ListResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	Name                  string
+	Prefix                string
+	KeyCount              int
+	MaxKeys               int
+	IsTruncated           bool
+	ContinuationToken     string `xml:",omitempty"`
+	NextContinuationToken string `xml:",omitempty"`
+	Contents              []fakeS3Object
+}
+
+type fakeS3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string
+	Message string
+}
+
+func newFakeS3(pageSize int) *fakeS3 {
+	return &fakeS3{pageSize: pageSize, buckets: map[string]map[string][]byte{}}
+}
+
+func (f *fakeS3) error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_ = xml.NewEncoder(w).Encode(fakeS3Error{Code: code, Message: code})
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key, _ := strings.Cut(path, "/")
+	objects, ok := f.buckets[bucket]
+
+	if key == "" {
+		switch r.Method {
+		case http.MethodHead:
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case http.MethodPut:
+			f.buckets[bucket] = map[string][]byte{}
+		case http.MethodGet:
+			if !ok {
+				f.error(w, http.StatusNotFound, "NoSuchBucket")
+				return
+			}
+			f.list(w, bucket, objects, r.URL.Query())
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	if !ok {
+		f.error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		body, err := readS3Body(r)
+		if err != nil {
+			f.error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		objects[key] = body
+		w.Header().Set("ETag", etag(body))
+	case http.MethodGet, http.MethodHead:
+		body, ok := objects[key]
+		if !ok {
+			f.error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", etag(body))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(body)
+		}
+	case http.MethodDelete:
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// list serves ListObjectsV2, the continuation token is the last key of previous page.
+func (f *fakeS3) list(w http.ResponseWriter, bucket string, objects map[string][]byte, q map[string][]string) {
+	f.lists++
+	get := func(k string) string {
+		if v := q[k]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	prefix, token := get("prefix"), get("continuation-token")
+	var keys []string
+	for k := range objects {
+		if strings.HasPrefix(k, prefix) && k > token {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	res := fakeS3ListResult{Name: bucket, Prefix: prefix, MaxKeys: f.pageSize, ContinuationToken: token}
+	if len(keys) > f.pageSize {
+		keys = keys[:f.pageSize]
+		res.IsTruncated = true
+		res.NextContinuationToken = keys[len(keys)-1]
+	}
+	for _, k := range keys {
+		res.Contents = append(res.Contents, fakeS3Object{
+			Key:          k,
+			LastModified: time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
+			ETag:         etag(objects[k]),
+			Size:         int64(len(objects[k])),
+		})
+	}
+	res.KeyCount = len(res.Contents)
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(res)
+}
+
+// readS3Body reads the object body, decoding aws-chunked bodies which minio-go sends over http.
+func readS3Body(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+	var body bytes.Buffer
+	br := bufio.NewReader(r.Body)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return body.Bytes(), nil
+		}
+		if _, err = io.CopyN(&body, br, size); err != nil {
+			return nil, err
+		}
+		if _, err = br.Discard(2); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func etag(body []byte) string {
+	sum := md5.Sum(body)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func newTestS3Storage(t *testing.T, f *fakeS3, prefix string) *S3Storage {
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	s, err := NewS3Storage(S3Options{
+		Endpoint:  strings.TrimPrefix(srv.URL, "http://"),
+		Region:    "us-east-1",
+		Bucket:    "cases",
+		Prefix:    prefix,
+		AccessKey: "test",
+		SecretKey: "testtesttest",
+		PathStyle: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestS3StorageReadWriteDelete(t *testing.T) {
+	f := newFakeS3(1000)
+	s := newTestS3Storage(t, f, "inspector/")
+	if _, ok := f.buckets["cases"]; !ok {
+		t.Fatal("bucket is not created")
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		content []byte
+	}{
+		{"text", "20231018/a/case.file", []byte(`{"ID":"a"}`)},
+		{"binary", "20231018/a/baseline.file", []byte{0, 1, 2, 0xff, '\n'}},
+		{"empty", "20231018/a/test.file", []byte{}},
+		{"large", "20231018/b/baseline.file", bytes.Repeat([]byte("0123456789abcdef"), 1<<14)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.Write(tt.key, tt.content); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			if _, ok := f.buckets["cases"]["inspector/"+tt.key]; !ok {
+				t.Fatalf("object inspector/%s is not stored", tt.key)
+			}
+			got, ok := s.Read(tt.key)
+			if !ok {
+				t.Fatal("Read: not found")
+			}
+			if !bytes.Equal(got, tt.content) {
+				t.Fatalf("Read: got %d bytes, want %d bytes", len(got), len(tt.content))
+			}
+			if err := s.Delete(tt.key); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if _, ok = s.Read(tt.key); ok {
+				t.Fatal("Read after Delete: found")
+			}
+		})
+	}
+
+	if _, ok := s.Read("missing"); ok {
+		t.Fatal("Read missing key: found")
+	}
+}
+
+func TestS3StorageWalk(t *testing.T) {
+	tests := []struct {
+		name      string
+		pageSize  int
+		keys      []string
+		wantLists int
+	}{
+		{"empty", 2, nil, 1},
+		{"one page", 10, []string{"a/1", "a/2", "b/1"}, 1},
+		{"exact pages", 2, []string{"a/1", "a/2", "b/1", "b/2"}, 2},
+		{"partial last page", 2, []string{"a/1", "a/2", "b/1", "b/2", "c/1"}, 3},
+		{"one per page", 1, []string{"a/1", "a/2", "b/1"}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeS3(tt.pageSize)
+			s := newTestS3Storage(t, f, "inspector/")
+			// objects out of prefix are not walked
+			f.buckets["cases"]["other/x"] = []byte("x")
+			for _, k := range tt.keys {
+				if err := s.Write(k, []byte(k)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			f.lists = 0
+			var got []string
+			err := s.Walk(func(key string, size int64, modTime time.Time) error {
+				if size != int64(len(key)) {
+					t.Errorf("size of %s: got %d, want %d", key, size, len(key))
+				}
+				if modTime.IsZero() {
+					t.Errorf("modTime of %s is zero", key)
+				}
+				got = append(got, key)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Walk: %v", err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.keys) {
+				t.Fatalf("Walk: got %v, want %v", got, tt.keys)
+			}
+			if f.lists != tt.wantLists {
+				t.Fatalf("list requests: got %d, want %d", f.lists, tt.wantLists)
+			}
+		})
+	}
+}
+
+func TestS3StorageWalkStop(t *testing.T) {
+	f := newFakeS3(1)
+	s := newTestS3Storage(t, f, "")
+	for _, k := range []string{"a", "b", "c"} {
+		if err := s.Write(k, []byte(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errStop := errors.New("stop")
+	var got []string
+	err := s.Walk(func(key string, size int64, modTime time.Time) error {
+		got = append(got, key)
+		if key == "b" {
+			return errStop
+		}
+		return nil
+	})
+	if !errors.Is(err, errStop) {
+		t.Fatalf("Walk: got error %v, want %v", err, errStop)
+	}
+	if fmt.Sprint(got) != "[a b]" {
+		t.Fatalf("Walk: got %v, want [a b]", got)
+	}
+}
@@ -1,64 +1,77 @@
 package storage
 
 import (
-	"strings"
+	"time"
 
-	diskv "github.com/peterbourgon/diskv/v3"
+	"github.com/bocchi-the-cache/inspector/pkg/common/logger"
 	"github.com/spf13/viper"
 )
 
-var DefaultDiskStorage *DiskStorage
+// storage backends
+const (
+	// BackendDisk stores keys as files on local disk.
+	BackendDisk = "disk"
+	// BackendS3 stores keys as objects of S3-compatible object storage, which survives the pod.
+	BackendS3 = "s3"
+	// BackendBolt stores keys in an embedded bolt database file.
+	BackendBolt = "bolt"
+)
 
-func Init() {
-	// Disk K-V storage storage
-	storage := diskv.New(diskv.Options{
-		BasePath:          viper.GetString("storage.base_case_path"),
-		AdvancedTransform: DiskCacheAdvancedTransform,
-		InverseTransform:  DiskCacheInverseTransform,
-		CacheSizeMax:      1024 * 1024 * 1024 * 1, // 1GB
-	})
-	DefaultDiskStorage = &DiskStorage{
-		C: storage,
-	}
+// Storage stores contents by key, keys are paths separated by "/".
+// It's safe for concurrent use.
+type Storage interface {
+	Write(key string, content []byte) error
+	// Read returns false if the key doesn't exist or can't be read.
+	Read(key string) ([]byte, bool)
+	// Delete removes the key, it's not an error if the key doesn't exist.
+	Delete(key string) error
+	// Walk calls fn for each stored key, and stops at the first error.
+	Walk(fn WalkFunc) error
 }
 
-type DiskStorage struct {
-	C *diskv.Diskv
-}
+// WalkFunc is called for each stored key, with its size and modification time.
+type WalkFunc func(key string, size int64, modTime time.Time) error
 
-func Write(key string, content []byte) error {
-	return DefaultDiskStorage.Write(key, content)
-}
+var DefaultStorage Storage
 
-func (d *DiskStorage) Write(key string, content []byte) error {
-	return d.C.Write(key, content)
+func Init() {
+	var err error
+	switch backend := viper.GetString("storage.backend"); backend {
+	case BackendDisk, "":
+		DefaultStorage = NewDiskStorage(viper.GetString("storage.base_case_path"))
+	case BackendS3:
+		DefaultStorage, err = NewS3Storage(S3Options{
+			Endpoint:  viper.GetString("storage.s3.endpoint"),
+			Region:    viper.GetString("storage.s3.region"),
+			Bucket:    viper.GetString("storage.s3.bucket"),
+			Prefix:    viper.GetString("storage.s3.prefix"),
+			AccessKey: viper.GetString("storage.s3.access_key"),
+			SecretKey: viper.GetString("storage.s3.secret_key"),
+			Secure:    viper.GetBool("storage.s3.secure"),
+			PathStyle: viper.GetBool("storage.s3.path_style"),
+		})
+	case BackendBolt:
+		DefaultStorage, err = NewBoltStorage(viper.GetString("storage.bolt.path"))
+	default:
+		logger.Panicf("unknown storage backend: %s", backend)
+	}
+	if err != nil {
+		logger.Panicf("init storage error, backend: %s, err: %s", viper.GetString("storage.backend"), err)
+	}
 }
 
-func Read(key string) ([]byte, bool) {
-	return DefaultDiskStorage.Read(key)
+func Write(key string, content []byte) error {
+	return DefaultStorage.Write(key, content)
 }
 
-func (d *DiskStorage) Read(key string) ([]byte, bool) {
-	content, err := d.C.Read(key)
-	if err != nil {
-		return nil, false
-	}
-	return content, true
+func Read(key string) ([]byte, bool) {
+	return DefaultStorage.Read(key)
 }
 
-func DiskCacheAdvancedTransform(key string) *diskv.PathKey {
-	path := strings.Split(key, "/")
-	last := len(path) - 1
-	return &diskv.PathKey{
-		Path:     path[:last],
-		FileName: path[last] + ".file",
-	}
+func Delete(key string) error {
+	return DefaultStorage.Delete(key)
 }
 
-func DiskCacheInverseTransform(pathKey *diskv.PathKey) (key string) {
-	txt := pathKey.FileName[len(pathKey.FileName)-5:]
-	if txt != ".file" {
-		panic("Invalid file found in storage folder!")
-	}
-	return strings.Join(pathKey.Path, "/") + pathKey.FileName[:len(pathKey.FileName)-4]
+func Walk(fn WalkFunc) error {
+	return DefaultStorage.Walk(fn)
 }
@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testStorage checks behaviors of the Storage interface, s is empty.
+func testStorage(t *testing.T, s Storage) {
+	if _, ok := s.Read("a/missing"); ok {
+		t.Fatal("Read missing key: got ok")
+	}
+	if err := s.Delete("a/missing"); err != nil {
+		t.Fatalf("Delete missing key: %v", err)
+	}
+
+	before := time.Now().Add(-time.Second)
+	contents := map[string]string{
+		"top":                      "top",
+		"20261018/20261018T0/case": `{"a":1}`,
+		"20261018/20261018T0/test": "",
+		"archive/a/b/c":            "nested",
+	}
+	for k, v := range contents {
+		if err := s.Write(k, []byte(v)); err != nil {
+			t.Fatalf("Write %s: %v", k, err)
+		}
+	}
+	// overwritten
+	contents["top"] = "top again"
+	if err := s.Write("top", []byte(contents["top"])); err != nil {
+		t.Fatal(err)
+	}
+	after := time.Now().Add(time.Second)
+	for k, v := range contents {
+		got, ok := s.Read(k)
+		if !ok || string(got) != v {
+			t.Fatalf("Read %s: got %q, %v, want %q", k, got, ok, v)
+		}
+	}
+
+	walked := map[string]string{}
+	err := s.Walk(func(key string, size int64, modTime time.Time) error {
+		if size != int64(len(contents[key])) {
+			t.Errorf("Walk %s: got size %d, want %d", key, size, len(contents[key]))
+		}
+		if modTime.Before(before) || modTime.After(after) {
+			t.Errorf("Walk %s: got time %s, not when it's written", key, modTime)
+		}
+		data, _ := s.Read(key)
+		walked[key] = string(data)
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(walked, contents) {
+		t.Fatalf("Walk: got %v, %v, want %v", walked, err, contents)
+	}
+
+	// stops at the first error
+	errStop := errors.New("stop")
+	calls := 0
+	err = s.Walk(func(key string, size int64, modTime time.Time) error {
+		calls++
+		return errStop
+	})
+	if !errors.Is(err, errStop) || calls != 1 {
+		t.Fatalf("Walk stopped: got %v after %d calls", err, calls)
+	}
+
+	// keys can be deleted while walking
+	err = s.Walk(func(key string, size int64, modTime time.Time) error {
+		return s.Delete(key)
+	})
+	if err != nil {
+		t.Fatalf("Walk and delete: %v", err)
+	}
+	for k := range contents {
+		if _, ok := s.Read(k); ok {
+			t.Fatalf("Read deleted %s: got ok", k)
+		}
+	}
+	if keys := walkKeys(t, s); len(keys) != 0 {
+		t.Fatalf("Walk after deleting all: got %v", keys)
+	}
+
+	// concurrent writes
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := s.Write(fmt.Sprintf("c/%d", i), []byte("x")); err != nil {
+				t.Errorf("Write: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if keys := walkKeys(t, s); len(keys) != 20 {
+		t.Fatalf("Walk after concurrent writes: got %d keys, want 20", len(keys))
+	}
+}
+
+func walkKeys(t *testing.T, s Storage) []string {
+	var keys []string
+	err := s.Walk(func(key string, size int64, modTime time.Time) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestDiskStorage(t *testing.T) {
+	testStorage(t, NewDiskStorage(t.TempDir()))
+
+	// nothing is stored yet
+	if keys := walkKeys(t, NewDiskStorage(filepath.Join(t.TempDir(), "none"))); len(keys) != 0 {
+		t.Fatalf("Walk of missing base path: got %v", keys)
+	}
+}
+
+func TestBoltStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db", "cases.db")
+	s, err := NewBoltStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStorage(t, s)
+
+	// keys and their time survive reopening
+	if err = s.Write("persist", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	var written time.Time
+	_ = s.Walk(func(key string, size int64, modTime time.Time) error {
+		if key == "persist" {
+			written = modTime
+		}
+		return nil
+	})
+	s.DB.Close()
+	if s, err = NewBoltStorage(path); err != nil {
+		t.Fatal(err)
+	}
+	defer s.DB.Close()
+	if got, ok := s.Read("persist"); !ok || string(got) != "value" {
+		t.Fatalf("Read after reopen: got %q, %v", got, ok)
+	}
+	_ = s.Walk(func(key string, size int64, modTime time.Time) error {
+		if key == "persist" && !modTime.Equal(written) {
+			t.Fatalf("Walk after reopen: got time %s, want %s", modTime, written)
+		}
+		return nil
+	})
+}
+
+func TestS3StorageInterface(t *testing.T) {
+	testStorage(t, newTestS3Storage(t, newFakeS3(3), "inspector/"))
+}
@@ -0,0 +1,217 @@
+package validator
+
+// Range assembly verification: the full object is fetched from baseline once,
+// then the same object is pulled from test as many byte ranges, reassembled, and compared to baseline.
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"math/rand"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+	"github.com/bocchi-the-cache/inspector/pkg/common/result_logger"
+	"github.com/bocchi-the-cache/inspector/pkg/monitor"
+)
+
+const (
+	// AssemblySequential pulls ranges from the beginning to the end.
+	AssemblySequential = "sequential"
+	// AssemblyRandom pulls ranges of random sizes, in random order.
+	AssemblyRandom = "random"
+
+	defaultAssemblyChunkSize = 1024 * 1024 // 1MB
+
+	// StateAssemblyFetchError means a range can't be fetched from test.
+	StateAssemblyFetchError = "ASSEMBLY_FETCH_ERROR"
+	// StateAssemblyRangeInvalid means test doesn't answer a range with exact 206 Content-Range.
+	StateAssemblyRangeInvalid = "ASSEMBLY_RANGE_INVALID"
+	// StateAssemblyNotMatch means bytes of a range differ from baseline.
+	StateAssemblyNotMatch = "ASSEMBLY_NOT_MATCH"
+)
+
+type AssemblyOptions struct {
+	Enable bool
+	// MinSize is the min object size to verify, in bytes.
+	MinSize int64
+	// ChunkSize is the range size, the max range size in random order.
+	ChunkSize int64
+	// Order is sequential or random.
+	Order string
+}
+
+// needAssembly checks whether the request is a plain GET of a large identity object.
+// Baseline bodies not kept in stream mode are verified by size and md5, if they are read to the end.
+func (v *Validator) needAssembly(r *client.Request, BaselineContent *client.Content, errBaseline error) bool {
+	if !v.assembly.Enable || errBaseline != nil || BaselineContent == nil {
+		return false
+	}
+	if r.Method != http.MethodGet || isRangeRequest(r) {
+		return false
+	}
+	if BaselineContent.Status != http.StatusOK || BaselineContent.Decoded {
+		return false
+	}
+	if BaselineContent.Truncated && BaselineContent.MD5 == "" {
+		return false
+	}
+	// byte ranges apply to encoded representation, keep it simple
+	if len(contentEncodings(BaselineContent)) > 0 {
+		return false
+	}
+	return BaselineContent.Size >= v.assembly.MinSize
+}
+
+// assemblyRanges splits [0, size) into ranges, in fetching order.
+func (v *Validator) assemblyRanges(size int64) []interval {
+	chunk := v.assembly.ChunkSize
+	var ranges []interval
+	for start := int64(0); start < size; {
+		n := chunk
+		if v.assembly.Order == AssemblyRandom {
+			n = rand.Int63n(chunk) + 1
+		}
+		end := start + n - 1
+		if end >= size {
+			end = size - 1
+		}
+		ranges = append(ranges, interval{start, end})
+		start = end + 1
+	}
+	if v.assembly.Order == AssemblyRandom {
+		rand.Shuffle(len(ranges), func(i, j int) { ranges[i], ranges[j] = ranges[j], ranges[i] })
+	}
+	return ranges
+}
+
+// CheckRangeAssembly pulls the object from test target f as byte ranges, compares them to baseline, and reports the result.
+func (v *Validator) CheckRangeAssembly(r *client.Request, f *client.Fetcher, BaselineContent *client.Content) {
+	record := v.assemble(r, f, BaselineContent)
+	monitor.ResultTotalCounterIncr("RangeAssembly", f.Name, record.State)
+	result_logger.Record(record)
+}
+
+// assemble pulls the object from test target f as byte ranges, and compares them to baseline.
+// If baseline body is not kept, ranges are pulled in order and hashed, and compared to baseline size and md5.
+func (v *Validator) assemble(r *client.Request, f *client.Fetcher, BaselineContent *client.Content) *RangeResult {
+	baseline := BaselineContent.Content
+	size := BaselineContent.Size
+	ranges := v.assemblyRanges(size)
+	var h hash.Hash
+	// sums are md5 of each range, to locate the differing range
+	var sums []string
+	if BaselineContent.Truncated {
+		h = md5.New()
+		sums = make([]string, len(ranges))
+		// bodies are hashed in order, random order keeps random sizes only
+		sort.Slice(ranges, func(i, j int) bool { return ranges[i].start < ranges[j].start })
+	}
+
+	state := StatePass
+	var failed *interval
+	var errFetch error
+	for i, rg := range ranges {
+		body, st, err := fetchRange(f, r, rg, size)
+		if st != StatePass {
+			state, failed, errFetch = st, &ranges[i], err
+			break
+		}
+		if h != nil {
+			h.Write(body)
+			sums[i] = md5Hex(body)
+			continue
+		}
+		if idx := firstDiff(baseline[rg.start:rg.end+1], body); idx >= 0 {
+			state, failed = StateAssemblyNotMatch, &ranges[i]
+			errFetch = fmt.Errorf("first differing offset %d", rg.start+int64(idx))
+			break
+		}
+	}
+	if h != nil && state == StatePass {
+		if sum := hex.EncodeToString(h.Sum(nil)); sum != BaselineContent.MD5 {
+			state = StateAssemblyNotMatch
+			var err error
+			failed, err = locateRange(r, ranges, sums, size)
+			errFetch = fmt.Errorf("md5 %s, baseline md5 %s, %s", sum, BaselineContent.MD5, err)
+		}
+	}
+
+	failedRange := ""
+	if failed != nil {
+		failedRange = fmt.Sprintf("bytes=%d-%d", failed.start, failed.end)
+	}
+	record := &RangeResult{
+		Version:     ResultVersion,
+		Kind:        KindRange,
+		Time:        time.Now(),
+		Target:      f.Name,
+		Method:      r.Method,
+		Host:        r.Host,
+		Path:        r.Path(),
+		URL:         r.URL,
+		State:       state,
+		Size:        size,
+		Ranges:      len(ranges),
+		FailedRange: failedRange,
+	}
+	if errFetch != nil {
+		record.Error = errFetch.Error()
+	}
+	if state == StateAssemblyFetchError {
+		record.ErrorClass = errorClass(errFetch)
+	}
+	return record
+}
+
+// fetchRange pulls range rg of the object of size from f, state is not PASS if it fails.
+func fetchRange(f *client.Fetcher, r *client.Request, rg interval, size int64) ([]byte, string, error) {
+	rr := *r
+	rr.Header = r.Header.Clone()
+	rr.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", rg.start, rg.end))
+	rr.Header.Set("Accept-Encoding", "identity")
+
+	status, header, body, err := f.Do(&rr)
+	if err != nil {
+		return nil, StateAssemblyFetchError, err
+	}
+	if status != http.StatusPartialContent {
+		return nil, StateAssemblyRangeInvalid, fmt.Errorf("status %d", status)
+	}
+	cr, err := parseContentRange(header.Get("Content-Range"))
+	if err == nil && (cr.interval != rg || (cr.size >= 0 && cr.size != size)) {
+		err = fmt.Errorf("content range %d-%d/%d", cr.start, cr.end, cr.size)
+	}
+	if err == nil && int64(len(body)) != rg.end-rg.start+1 {
+		err = fmt.Errorf("body length %d", len(body))
+	}
+	if err != nil {
+		return nil, StateAssemblyRangeInvalid, err
+	}
+	return body, StatePass, nil
+}
+
+// locateRange finds the first range differing from baseline, by md5 of test ranges.
+// Baseline body is not kept, so the same ranges are pulled from baseline until one differs.
+// The returned error tells what differs, or why the range is unknown.
+func locateRange(r *client.Request, ranges []interval, sums []string, size int64) (*interval, error) {
+	for i, rg := range ranges {
+		body, state, err := fetchRange(client.BaselineFetcher, r, rg, size)
+		if state != StatePass {
+			return nil, fmt.Errorf("the differing range is unknown, baseline range %d-%d error: %s", rg.start, rg.end, err)
+		}
+		if sum := md5Hex(body); sum != sums[i] {
+			return &ranges[i], fmt.Errorf("range md5 %s, baseline range md5 %s", sums[i], sum)
+		}
+	}
+	return nil, errors.New("the differing range is unknown, baseline ranges are the same")
+}
+
+func md5Hex(b []byte) string {
+	sum := md5.Sum(b)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,114 @@
+package validator
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+)
+
+func TestAssemblyRanges(t *testing.T) {
+	tests := []struct {
+		name      string
+		order     string
+		chunkSize int64
+		size      int64
+		want      []interval // in fetching order, nil to check coverage only
+	}{
+		{"sequential exact chunks", AssemblySequential, 10, 30, []interval{{0, 9}, {10, 19}, {20, 29}}},
+		{"sequential partial last chunk", AssemblySequential, 10, 25, []interval{{0, 9}, {10, 19}, {20, 24}}},
+		{"sequential chunk larger than size", AssemblySequential, 100, 25, []interval{{0, 24}}},
+		{"sequential byte chunks", AssemblySequential, 1, 3, []interval{{0, 0}, {1, 1}, {2, 2}}},
+		{"empty", AssemblySequential, 10, 0, nil},
+		{"random", AssemblyRandom, 10, 1000, nil},
+		{"random byte chunks", AssemblyRandom, 1, 5, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &Validator{assembly: AssemblyOptions{Order: tt.order, ChunkSize: tt.chunkSize}}
+			got := v.assemblyRanges(tt.size)
+			if tt.want != nil && !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("assemblyRanges(%d): got %v, want %v", tt.size, got, tt.want)
+			}
+
+			// ranges are disjoint, and assemble into the whole object
+			var total int64
+			for _, iv := range got {
+				if iv.start > iv.end || iv.end-iv.start+1 > tt.chunkSize {
+					t.Fatalf("range %v is not within chunk size %d", iv, tt.chunkSize)
+				}
+				total += iv.end - iv.start + 1
+			}
+			merged := mergeIntervals(got)
+			if tt.size == 0 {
+				if len(got) != 0 {
+					t.Fatalf("assemblyRanges(0): got %v, want none", got)
+				}
+				return
+			}
+			if total != tt.size || !reflect.DeepEqual(merged, []interval{{0, tt.size - 1}}) {
+				t.Fatalf("assemblyRanges(%d): %v doesn't assemble into [0, %d]", tt.size, got, tt.size-1)
+			}
+		})
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	object := strings.Repeat("0123456789", 5)
+	corrupt := object[:23] + "x" + object[24:]
+	serve := func(body string, ranges bool) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !ranges {
+				r.Header.Del("Range")
+			}
+			http.ServeContent(w, r, "", time.Time{}, strings.NewReader(body))
+		}))
+	}
+	tests := []struct {
+		name            string
+		test            string
+		testRanges      bool
+		baselineRanges  bool
+		truncated       bool
+		wantState       string
+		wantFailedRange string
+		wantErr         string
+	}{
+		{"kept same", object, true, true, false, StatePass, "", ""},
+		{"kept differ", corrupt, true, true, false, StateAssemblyNotMatch, "bytes=20-29", "first differing offset 23"},
+		{"hashed same", object, true, true, true, StatePass, "", ""},
+		{"hashed differ", corrupt, true, true, true, StateAssemblyNotMatch, "bytes=20-29", "range md5"},
+		{"hashed differ without baseline ranges", corrupt, true, false, true, StateAssemblyNotMatch, "", "the differing range is unknown"},
+		{"test without ranges", object, false, true, true, StateAssemblyRangeInvalid, "bytes=0-9", "status 200"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			baseline := serve(object, tt.baselineRanges)
+			defer baseline.Close()
+			setBaseline(t, baseline)
+			test := serve(tt.test, tt.testRanges)
+			defer test.Close()
+			f := client.NewHttpFetcher(strings.TrimPrefix(test.URL, "http://"))
+
+			v := &Validator{assembly: AssemblyOptions{Order: AssemblySequential, ChunkSize: 10}}
+			b := &client.Content{Status: http.StatusOK, Header: http.Header{}, Size: int64(len(object)), MD5: md5Hex([]byte(object))}
+			if tt.truncated {
+				b.Truncated = true
+			} else {
+				b.Content = []byte(object)
+			}
+			r := &client.Request{Method: http.MethodGet, Host: "a.com", URL: "/a", Header: http.Header{}}
+			got := v.assemble(r, f, b)
+			if got.State != tt.wantState || got.FailedRange != tt.wantFailedRange || !strings.Contains(got.Error, tt.wantErr) {
+				t.Fatalf("assemble: got %s %q %q, want %s %q %q", got.State, got.FailedRange, got.Error, tt.wantState, tt.wantFailedRange, tt.wantErr)
+			}
+			if got.Ranges != 5 {
+				t.Fatalf("assemble: got %d ranges, want 5", got.Ranges)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package validator
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+	"github.com/bocchi-the-cache/inspector/pkg/common/logger"
+	"github.com/bocchi-the-cache/inspector/pkg/index"
+	"github.com/bocchi-the-cache/inspector/pkg/storage"
+)
+
+// keys of a case bundle under <date>/<case id>/ of storage
+const (
+	caseKeyMeta     = "case"
+	caseKeyBaseline = "baseline"
+	caseKeyTest     = "test"
+)
+
+// time layout of case id prefix
+const caseTimeLayout = "20060102T150405.000"
+
+// ErrCaseNotFound means no case is stored with the case id.
+var ErrCaseNotFound = errors.New("case not found")
+
+// Case is a bad case bundle, everything needed to look into or replay a mismatch.
+// Baseline and Test contents are stored separately by MarshalContent, and loaded by UnmarshalContent.
+type Case struct {
+	// ID is unique, eg: 20231018T092106.123-3f2a9c1d7e4b-5e6f7a8b
+	ID     string
+	Time   time.Time
+	Target string
+	Host   string
+	// URL is the request uri with query
+	URL     string
+	Request *client.Request
+	Verdict Verdict
+	// State is the final state of checking, which may differ from Verdict.State after confirming
+	State      string
+	HeaderDiff []string `json:",omitempty"`
+	// DiffKey is the storage key of the diff artifact, loaded by ReadDiff.
+	DiffKey string `json:",omitempty"`
+
+	Baseline *client.Content `json:"-"`
+	Test     *client.Content `json:"-"`
+	Diff     *Diff           `json:"-"`
+}
+
+// isBadCase returns whether a case should be stored for the state.
+func isBadCase(state string) bool {
+	switch state {
+	case StateStatusNotMatch, StateContentNotMatch, StateTestUnstable, StateDecodeError, StateRangeInvalid, StateHeaderNotMatch:
+		return true
+	}
+	return false
+}
+
+// newCaseID returns a unique case id, sorted by time, with hash of host, url and target.
+func newCaseID(t time.Time, r *client.Request, target string) string {
+	h := sha1.Sum([]byte(r.Host + " " + r.URL + " " + target))
+	nonce := make([]byte, 4)
+	if _, err := rand.Read(nonce); err != nil {
+		logger.Errorf("generate case id nonce error, err: %s", err)
+	}
+	return fmt.Sprintf("%s-%s-%s", t.UTC().Format(caseTimeLayout), hex.EncodeToString(h[:6]), hex.EncodeToString(nonce))
+}
+
+// validCaseID returns whether id looks like a case id made by newCaseID, ids from api must not escape storage.
+func validCaseID(id string) bool {
+	if id == "" || strings.Contains(id, "..") {
+		return false
+	}
+	for _, c := range id {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '.' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// caseKey returns storage key of a case part, cases of a day are in the same directory.
+func caseKey(id string, name string) string {
+	if len(id) < 8 {
+		return id + "/" + name
+	}
+	return id[:8] + "/" + id + "/" + name
+}
+
+// writeBadCase stores the case bundle in background, and returns the case id, and the diff key if a diff is stored.
+// Bodies of truncated contents are not stored, but status, headers, size and md5 are.
+func (v *Validator) writeBadCase(r *client.Request, target string, state string, vd Verdict, headerDiff []string, diffOffset int64,
+	BaselineContent *client.Content, TestContent *client.Content) (string, string) {
+	now := time.Now()
+	c := &Case{
+		ID:         newCaseID(now, r, target),
+		Time:       now,
+		Target:     target,
+		Host:       r.Host,
+		URL:        r.URL,
+		Request:    r,
+		Verdict:    vd,
+		State:      state,
+		HeaderDiff: headerDiff,
+		Baseline:   BaselineContent,
+		Test:       TestContent,
+	}
+	if BaselineContent.Truncated || TestContent.Truncated {
+		logger.Infof("skip storing large content, path: %s, baseline size: %d, test size: %d",
+			r.Path(), BaselineContent.Size, TestContent.Size)
+	}
+	withDiff := v.diff.Enable && state == StateContentNotMatch
+	if withDiff {
+		c.DiffKey = caseKey(c.ID, caseKeyDiff)
+	}
+	go func() {
+		if withDiff {
+			c.Diff = v.newDiff(BaselineContent, TestContent, diffOffset)
+		}
+		if err := WriteCase(c); err != nil {
+			logger.Errorf("write bad case error, id: %s, path: %s, err: %s", c.ID, r.Path(), err)
+		}
+	}()
+	return c.ID, c.DiffKey
+}
+
+// WriteCase stores the case bundle.
+func WriteCase(c *Case) error {
+	parts := []struct {
+		name string
+		v    interface{}
+	}{
+		{caseKeyBaseline, c.Baseline},
+		{caseKeyTest, c.Test},
+		{caseKeyDiff, c.Diff},
+		// meta is written last, a case without meta is incomplete
+		{caseKeyMeta, c},
+	}
+	for _, p := range parts {
+		if d, ok := p.v.(*Diff); ok && d == nil {
+			continue
+		}
+		if content, ok := p.v.(*client.Content); ok && content.Truncated && content.Content != nil {
+			stripped := *content
+			stripped.Content = nil
+			p.v = &stripped
+		}
+		data, err := MarshalContent(p.v)
+		if err != nil {
+			return err
+		}
+		if err = storage.Write(caseKey(c.ID, p.name), data); err != nil {
+			return err
+		}
+	}
+	if err := index.Add(caseEntry(c)); err != nil {
+		logger.Errorf("index bad case error, id: %s, err: %s", c.ID, err)
+	}
+	return nil
+}
+
+// caseEntry returns the index entry of the case.
+func caseEntry(c *Case) *index.Entry {
+	e := &index.Entry{
+		ID:           c.ID,
+		Time:         c.Time,
+		Host:         c.Host,
+		Path:         c.Request.Path(),
+		URL:          c.URL,
+		State:        c.State,
+		Target:       c.Target,
+		BaselineHash: contentHash(c.Baseline),
+		TestHash:     contentHash(c.Test),
+		Reason:       c.Verdict.Reason,
+	}
+	if c.Baseline != nil {
+		e.BaselineStatus = c.Baseline.Status
+	}
+	if c.Test != nil {
+		e.TestStatus = c.Test.Status
+	}
+	return e
+}
+
+// RebuildIndex indexes all cases in storage, eg: after the pod is replaced but cases are kept in object storage.
+func RebuildIndex() error {
+	if !index.Enabled() {
+		return index.ErrDisabled
+	}
+	var ids []string
+	err := storage.Walk(func(key string, size int64, modTime time.Time) error {
+		if parts := strings.Split(key, "/"); len(parts) == 3 && parts[2] == caseKeyMeta {
+			ids = append(ids, parts[1])
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		c, err := ReadCase(id)
+		if err != nil {
+			logger.Errorf("read bad case error, id: %s, err: %s", id, err)
+			continue
+		}
+		if err = index.Add(caseEntry(c)); err != nil {
+			return err
+		}
+	}
+	logger.Infof("case index rebuilt, %d cases", len(ids))
+	return nil
+}
+
+// ReadCase loads the case bundle by case id.
+func ReadCase(id string) (*Case, error) {
+	if !validCaseID(id) {
+		return nil, ErrCaseNotFound
+	}
+	data, ok := storage.Read(caseKey(id, caseKeyMeta))
+	if !ok {
+		return nil, ErrCaseNotFound
+	}
+	c := &Case{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	var err error
+	if c.Baseline, err = readCaseContent(id, caseKeyBaseline); err != nil {
+		return nil, err
+	}
+	if c.Test, err = readCaseContent(id, caseKeyTest); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func readCaseContent(id string, name string) (*client.Content, error) {
+	data, ok := storage.Read(caseKey(id, name))
+	if !ok {
+		return nil, fmt.Errorf("%w: missing %s of case %s", ErrCaseNotFound, name, id)
+	}
+	return UnmarshalContent(data)
+}
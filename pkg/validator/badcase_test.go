@@ -0,0 +1,103 @@
+package validator
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+	"github.com/bocchi-the-cache/inspector/pkg/index"
+)
+
+// newCase returns a bad case of url at t.
+func newCase(t time.Time, url string, state string) *Case {
+	r := &client.Request{Method: http.MethodGet, Host: "a.com", URL: url, Header: http.Header{}}
+	return &Case{
+		ID:       newCaseID(t, r, "test"),
+		Time:     t,
+		Target:   "test",
+		Host:     r.Host,
+		URL:      r.URL,
+		Request:  r,
+		Verdict:  Verdict{State: state, Offset: -1, Reason: "bytes"},
+		State:    state,
+		Baseline: &client.Content{Status: http.StatusOK, Header: http.Header{}, Content: []byte("a"), Size: 1},
+		Test:     &client.Content{Status: http.StatusOK, Header: http.Header{}, Content: []byte("b"), Size: 1},
+	}
+}
+
+func TestRebuildIndex(t *testing.T) {
+	m := setStorage(t)
+	old := index.DefaultIndex
+	index.DefaultIndex = nil
+	t.Cleanup(func() { index.DefaultIndex = old })
+	if err := RebuildIndex(); err != index.ErrDisabled {
+		t.Fatalf("RebuildIndex without index: got %v, want %v", err, index.ErrDisabled)
+	}
+
+	now := time.Now().UTC()
+	cases := []*Case{
+		newCase(now.Add(-3*time.Minute), "/a?x=1", StateContentNotMatch),
+		newCase(now.Add(-2*time.Minute), "/b", StateStatusNotMatch),
+		newCase(now.Add(-time.Minute), "/c", StateHeaderNotMatch),
+	}
+	for _, c := range cases {
+		if err := WriteCase(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a case without meta is incomplete, one without test content can't be read
+	incomplete := newCase(now, "/d", StateContentNotMatch)
+	_ = m.Write(caseKey(incomplete.ID, caseKeyBaseline), []byte("{}"))
+	broken := newCase(now, "/e", StateContentNotMatch)
+	if err := WriteCase(broken); err != nil {
+		t.Fatal(err)
+	}
+	_ = m.Delete(caseKey(broken.ID, caseKeyTest))
+	// archived cases are not indexed
+	archived := newCase(now, "/f", StateContentNotMatch)
+	if err := WriteCase(archived); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{caseKeyMeta, caseKeyBaseline, caseKeyTest} {
+		data, _ := m.Read(caseKey(archived.ID, name))
+		_ = m.Write(archivePrefix+caseKey(archived.ID, name), data)
+		_ = m.Delete(caseKey(archived.ID, name))
+	}
+
+	idx := setIndex(t)
+	if err := RebuildIndex(); err != nil {
+		t.Fatal(err)
+	}
+	res, err := idx.Search(index.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Cases) != len(cases) {
+		t.Fatalf("Search after rebuilding: got %d cases, want %d", len(res.Cases), len(cases))
+	}
+	for i, e := range res.Cases {
+		// newest first
+		c := cases[len(cases)-1-i]
+		want := caseEntry(c)
+		if !e.Time.Equal(want.Time) {
+			t.Fatalf("entry %s: got time %s, want %s", e.ID, e.Time, want.Time)
+		}
+		e.Time = want.Time
+		if !reflect.DeepEqual(e, want) {
+			t.Fatalf("entry: got %+v, want %+v", e, want)
+		}
+	}
+	if res, _ = idx.Search(index.Query{Path: "/a"}); len(res.Cases) != 1 || res.Cases[0].URL != "/a?x=1" {
+		t.Fatalf("Search by path: got %v", res.Cases)
+	}
+
+	// rebuilding again doesn't duplicate cases
+	if err = RebuildIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if res, _ = idx.Search(index.Query{}); len(res.Cases) != len(cases) {
+		t.Fatalf("Search after rebuilding twice: got %d cases, want %d", len(res.Cases), len(cases))
+	}
+}
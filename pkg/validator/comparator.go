@@ -0,0 +1,252 @@
+package validator
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+	"github.com/bocchi-the-cache/inspector/pkg/common/logger"
+)
+
+// built-in comparator names
+const (
+	// ComparatorDefault compares range responses part by part, json bodies structurally if enabled, others byte by byte.
+	ComparatorDefault = "default"
+	// ComparatorRange compares range responses part by part, only applies to 206 responses of range requests.
+	ComparatorRange = "range"
+	// ComparatorJSON compares json bodies structurally, only applies to json responses.
+	ComparatorJSON = "json"
+	// ComparatorBytes compares bodies byte by byte, decoded by Content-Encoding unless in strict mode.
+	ComparatorBytes = "bytes"
+)
+
+// Verdict is the result of comparing bodies of two contents.
+type Verdict struct {
+	State string
+	// Offset is the first differing offset, -1 if unknown
+	Offset int64
+	// Reason tells why the state is not PASS, prefixed by comparator name
+	Reason string
+	// Paths are differing json paths
+	Paths []string
+}
+
+// Comparator compares test content against baseline content with the same status.
+// ok is false if the comparator does not apply to the contents, eg: json comparator on html bodies.
+type Comparator interface {
+	Compare(r *client.Request, b *client.Content, t *client.Content) (vd Verdict, ok bool)
+}
+
+// ComparatorFunc adapts a function to Comparator.
+type ComparatorFunc func(r *client.Request, b *client.Content, t *client.Content) (Verdict, bool)
+
+func (f ComparatorFunc) Compare(r *client.Request, b *client.Content, t *client.Content) (Verdict, bool) {
+	return f(r, b, t)
+}
+
+var (
+	comparatorsMu sync.RWMutex
+	comparators   = map[string]Comparator{}
+)
+
+// RegisterComparator registers a custom comparator, which can be selected by name in comparator chains.
+// It should be called before Init, built-in names can not be overridden.
+func RegisterComparator(name string, c Comparator) {
+	switch name {
+	case ComparatorDefault, ComparatorRange, ComparatorJSON, ComparatorBytes:
+		logger.Panicf("comparator %s is built-in", name)
+	}
+	if c == nil {
+		logger.Panicf("comparator %s is nil", name)
+	}
+	comparatorsMu.Lock()
+	defer comparatorsMu.Unlock()
+	if _, ok := comparators[name]; ok {
+		logger.Panicf("duplicated comparator: %s", name)
+	}
+	comparators[name] = c
+}
+
+// ComparatorRule selects comparator chain for responses matching ContentType and requests matching URL regex.
+// ContentType is a media type (application/json) or a prefix ending with "/" (image/), empty matches all.
+type ComparatorRule struct {
+	ContentType string   `mapstructure:"content_type"`
+	URL         string   `mapstructure:"url"`
+	Chain       []string `mapstructure:"chain"`
+}
+
+type comparatorRule struct {
+	contentType string
+	url         *regexp.Regexp
+	chain       []namedComparator
+}
+
+type namedComparator struct {
+	name string
+	Comparator
+}
+
+func (v *Validator) initComparators(rules []ComparatorRule) error {
+	builtins := map[string]Comparator{
+		ComparatorDefault: ComparatorFunc(v.compareDefault),
+		ComparatorRange:   ComparatorFunc(v.compareRange),
+		ComparatorJSON:    ComparatorFunc(v.compareJSON),
+		ComparatorBytes:   ComparatorFunc(v.compareBytes),
+	}
+	lookup := func(name string) (Comparator, error) {
+		if c, ok := builtins[name]; ok {
+			return c, nil
+		}
+		comparatorsMu.RLock()
+		defer comparatorsMu.RUnlock()
+		if c, ok := comparators[name]; ok {
+			return c, nil
+		}
+		return nil, fmt.Errorf("unknown comparator: %s", name)
+	}
+
+	for _, rule := range append(rules, ComparatorRule{Chain: []string{ComparatorDefault}}) {
+		if len(rule.Chain) == 0 {
+			return fmt.Errorf("empty comparator chain, content_type: %q, url: %q", rule.ContentType, rule.URL)
+		}
+		cr := comparatorRule{contentType: strings.ToLower(rule.ContentType)}
+		if rule.URL != "" {
+			re, err := regexp.Compile(rule.URL)
+			if err != nil {
+				return err
+			}
+			cr.url = re
+		}
+		for _, name := range rule.Chain {
+			c, err := lookup(name)
+			if err != nil {
+				return err
+			}
+			cr.chain = append(cr.chain, namedComparator{name: name, Comparator: c})
+		}
+		v.comparatorRules = append(v.comparatorRules, cr)
+	}
+	return nil
+}
+
+func (cr *comparatorRule) match(r *client.Request, b *client.Content) bool {
+	if cr.url != nil && !cr.url.MatchString(r.Path()) {
+		return false
+	}
+	if cr.contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(b.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	if strings.HasSuffix(cr.contentType, "/") {
+		return strings.HasPrefix(mediaType, cr.contentType)
+	}
+	return mediaType == cr.contentType
+}
+
+// compare compares bodies of contents with the same status by the first matching comparator chain.
+// Comparators not applying are skipped, the first non PASS verdict wins,
+// and bodies are compared byte by byte if no comparator applies.
+func (v *Validator) compare(r *client.Request, b *client.Content, t *client.Content) Verdict {
+	var chain []namedComparator
+	for i := range v.comparatorRules {
+		if v.comparatorRules[i].match(r, b) {
+			chain = v.comparatorRules[i].chain
+			break
+		}
+	}
+	applied := false
+	for _, c := range chain {
+		vd, ok := c.Compare(r, b, t)
+		if !ok {
+			continue
+		}
+		applied = true
+		if vd.State != StatePass {
+			return vd.withComparator(c.name)
+		}
+	}
+	if !applied {
+		vd, _ := v.compareBytes(r, b, t)
+		if vd.State != StatePass {
+			return vd.withComparator(ComparatorBytes)
+		}
+		return vd
+	}
+	return Verdict{State: StatePass, Offset: -1}
+}
+
+func (vd Verdict) withComparator(name string) Verdict {
+	if vd.Reason == "" {
+		vd.Reason = name
+	} else {
+		vd.Reason = name + ": " + vd.Reason
+	}
+	return vd
+}
+
+// sameContent checks whether contents have the same status and body.
+func (v *Validator) sameContent(r *client.Request, b *client.Content, t *client.Content) bool {
+	if b.Status != t.Status {
+		return false
+	}
+	return v.compare(r, b, t).State == StatePass
+}
+
+// compareDefault compares range responses part by part, others are decoded by Content-Encoding unless in strict mode.
+func (v *Validator) compareDefault(r *client.Request, b *client.Content, t *client.Content) (Verdict, bool) {
+	if vd, ok := v.compareRange(r, b, t); ok {
+		return vd, true
+	}
+	db, dt, err := v.decodeContents(b, t)
+	if err != nil {
+		logger.Infof("decode content error, path: %s, err: %s", r.Path(), err)
+		return Verdict{State: StateDecodeError, Offset: -1, Reason: err.Error()}, true
+	}
+	if v.jsonEnable {
+		if vd, ok := v.json.Compare(r, db, dt); ok {
+			return vd, true
+		}
+	}
+	return bytesVerdict(db, dt), true
+}
+
+func (v *Validator) compareRange(r *client.Request, b *client.Content, t *client.Content) (Verdict, bool) {
+	if !isRangeRequest(r) || b.Status != http.StatusPartialContent || b.Truncated || t.Truncated {
+		return Verdict{}, false
+	}
+	return compareRange(r, b, t), true
+}
+
+func (v *Validator) compareJSON(r *client.Request, b *client.Content, t *client.Content) (Verdict, bool) {
+	db, dt, err := v.decodeContents(b, t)
+	if err != nil {
+		return Verdict{}, false
+	}
+	return v.json.Compare(r, db, dt)
+}
+
+func (v *Validator) compareBytes(r *client.Request, b *client.Content, t *client.Content) (Verdict, bool) {
+	db, dt, err := v.decodeContents(b, t)
+	if err != nil {
+		logger.Infof("decode content error, path: %s, err: %s", r.Path(), err)
+		return Verdict{State: StateDecodeError, Offset: -1, Reason: err.Error()}, true
+	}
+	return bytesVerdict(db, dt), true
+}
+
+func bytesVerdict(b *client.Content, t *client.Content) Verdict {
+	if ok, offset := compareContent(b, t); !ok {
+		if b.Size != t.Size {
+			return Verdict{State: StateContentNotMatch, Offset: offset, Reason: fmt.Sprintf("size %d != %d", b.Size, t.Size)}
+		}
+		return Verdict{State: StateContentNotMatch, Offset: offset}
+	}
+	return Verdict{State: StatePass, Offset: -1}
+}
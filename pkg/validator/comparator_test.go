@@ -0,0 +1,134 @@
+package validator
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+)
+
+// registerComparator registers c during the test.
+func registerComparator(t *testing.T, name string, c Comparator) {
+	RegisterComparator(name, c)
+	t.Cleanup(func() {
+		comparatorsMu.Lock()
+		delete(comparators, name)
+		comparatorsMu.Unlock()
+	})
+}
+
+// verdictComparator returns state, or doesn't apply if state is empty.
+func verdictComparator(state string) Comparator {
+	return ComparatorFunc(func(r *client.Request, b *client.Content, t *client.Content) (Verdict, bool) {
+		if state == "" {
+			return Verdict{}, false
+		}
+		return Verdict{State: state, Offset: -1}, true
+	})
+}
+
+func TestRegisterComparator(t *testing.T) {
+	registerComparator(t, "test-registered", verdictComparator(StatePass))
+	tests := []struct {
+		name string
+		c    Comparator
+	}{
+		{ComparatorDefault, verdictComparator(StatePass)},
+		{ComparatorBytes, verdictComparator(StatePass)},
+		{"test-nil", nil},
+		{"test-registered", verdictComparator(StatePass)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("RegisterComparator(%s): got no panic", tt.name)
+				}
+			}()
+			RegisterComparator(tt.name, tt.c)
+		})
+	}
+}
+
+func TestInitComparatorsError(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []ComparatorRule
+	}{
+		{"unknown comparator", []ComparatorRule{{Chain: []string{"test-unknown"}}}},
+		{"empty chain", []ComparatorRule{{ContentType: "text/html"}}},
+		{"invalid url", []ComparatorRule{{URL: "(", Chain: []string{ComparatorBytes}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &Validator{}
+			if err := v.initComparators(tt.rules); err == nil {
+				t.Fatal("initComparators: got no error")
+			}
+		})
+	}
+}
+
+func TestCompareSelection(t *testing.T) {
+	registerComparator(t, "test-pass", verdictComparator(StatePass))
+	registerComparator(t, "test-fail", verdictComparator(StateContentNotMatch))
+	registerComparator(t, "test-skip", verdictComparator(""))
+
+	tests := []struct {
+		name        string
+		rules       []ComparatorRule
+		path        string
+		contentType string
+		// test body differs from baseline
+		differ     bool
+		wantState  string
+		wantReason string
+	}{
+		{"default chain", nil, "/a", "text/html", true, StateContentNotMatch, ComparatorDefault},
+		{"media type", []ComparatorRule{{ContentType: "text/html", Chain: []string{"test-pass"}}},
+			"/a", "text/html; charset=utf-8", true, StatePass, ""},
+		{"media type case insensitive", []ComparatorRule{{ContentType: "Text/HTML", Chain: []string{"test-pass"}}},
+			"/a", "text/html", true, StatePass, ""},
+		{"media type not matched", []ComparatorRule{{ContentType: "text/html", Chain: []string{"test-pass"}}},
+			"/a", "text/plain", true, StateContentNotMatch, ComparatorDefault},
+		{"media type prefix", []ComparatorRule{{ContentType: "image/", Chain: []string{"test-pass"}}},
+			"/a", "image/png", true, StatePass, ""},
+		{"invalid content type not matched", []ComparatorRule{{ContentType: "image/", Chain: []string{"test-pass"}}},
+			"/a", "image/", true, StateContentNotMatch, ComparatorDefault},
+		{"url", []ComparatorRule{{URL: `^/api/`, Chain: []string{"test-pass"}}},
+			"/api/a?x=1", "text/html", true, StatePass, ""},
+		{"url not matched", []ComparatorRule{{URL: `^/api/`, Chain: []string{"test-pass"}}},
+			"/static/api/a", "text/html", true, StateContentNotMatch, ComparatorDefault},
+		{"both content type and url", []ComparatorRule{{ContentType: "text/html", URL: `^/api/`, Chain: []string{"test-pass"}}},
+			"/api/a", "text/plain", true, StateContentNotMatch, ComparatorDefault},
+		{"first rule wins", []ComparatorRule{
+			{ContentType: "text/html", Chain: []string{"test-fail"}},
+			{ContentType: "text/", Chain: []string{"test-pass"}},
+		}, "/a", "text/html", false, StateContentNotMatch, "test-fail"},
+		{"first non pass wins", []ComparatorRule{{Chain: []string{"test-pass", "test-fail", ComparatorBytes}}},
+			"/a", "text/html", true, StateContentNotMatch, "test-fail"},
+		{"all pass", []ComparatorRule{{Chain: []string{"test-pass", "test-skip"}}},
+			"/a", "text/html", true, StatePass, ""},
+		{"none applies falls back to bytes", []ComparatorRule{{Chain: []string{"test-skip"}}},
+			"/a", "text/html", true, StateContentNotMatch, ComparatorBytes},
+		{"json not applied to html", []ComparatorRule{{Chain: []string{ComparatorJSON}}},
+			"/a", "text/html", false, StatePass, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewValidator(Options{Comparators: tt.rules})
+			r := &client.Request{Method: http.MethodGet, Host: "a.com", URL: tt.path, Header: http.Header{}}
+			header := http.Header{"Content-Type": {tt.contentType}}
+			b := &client.Content{Status: http.StatusOK, Header: header, Content: []byte("abc"), Size: 3}
+			body := "abc"
+			if tt.differ {
+				body = "abd"
+			}
+			c := &client.Content{Status: http.StatusOK, Header: header, Content: []byte(body), Size: 3}
+			vd := v.compare(r, b, c)
+			if vd.State != tt.wantState || vd.Reason != tt.wantReason {
+				t.Fatalf("compare: got %s %q, want %s %q", vd.State, vd.Reason, tt.wantState, tt.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,384 @@
+package validator
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+	"github.com/bocchi-the-cache/inspector/pkg/storage"
+)
+
+// key of the diff artifact in a case bundle
+const caseKeyDiff = "diff"
+
+const (
+	defaultDiffContext  = 3
+	defaultDiffWindow   = 64
+	defaultDiffMaxLines = 1000
+	// lines of minified bodies can be the whole body
+	defaultDiffMaxLineBytes = 512
+	// max cells of the line matching table, larger changes are diffed as a whole block
+	maxDiffCells = 1 << 20
+	// bytes sniffed for text bodies without Content-Type
+	diffSniffSize = 8192
+)
+
+// DiffOptions computes a diff artifact for CONTENT_NOT_MATCH cases.
+type DiffOptions struct {
+	Enable bool
+	// Context is the number of unchanged lines around changes in unified diffs.
+	Context int
+	// Window is the number of bytes dumped before and after the first differing offset of binary bodies.
+	Window int
+	// MaxLines is the max number of lines of a unified diff, the rest is cut.
+	MaxLines int
+	// MaxLineBytes is the max bytes of each line of a unified diff, the rest of the line is cut.
+	MaxLineBytes int
+}
+
+// Diff is the diff artifact of a case, stored as diff of the case bundle.
+type Diff struct {
+	// Offset is the first differing offset, -1 if unknown.
+	Offset       int64
+	BaselineSize int64
+	TestSize     int64
+	// SizeDelta is TestSize - BaselineSize.
+	SizeDelta int64
+	// Decoded is true if bodies are decoded by Content-Encoding before diffing.
+	Decoded bool
+	Text    bool
+	// Unified is the unified line diff of text bodies.
+	Unified string `json:",omitempty"`
+	// BaselineHex and TestHex are hexdumps around Offset, of kept prefixes if bodies are truncated.
+	BaselineHex string `json:",omitempty"`
+	TestHex     string `json:",omitempty"`
+	// Note tells why parts are missing, eg: bodies are not kept.
+	Note string `json:",omitempty"`
+}
+
+// String returns the diff for reading.
+func (d *Diff) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "first differing offset: %d\n", d.Offset)
+	fmt.Fprintf(&b, "size: baseline %d, test %d, delta %+d\n", d.BaselineSize, d.TestSize, d.SizeDelta)
+	if d.Decoded {
+		b.WriteString("bodies are decoded by Content-Encoding\n")
+	}
+	if d.Note != "" {
+		fmt.Fprintf(&b, "note: %s\n", d.Note)
+	}
+	if d.Unified != "" {
+		b.WriteString("\n" + d.Unified)
+	}
+	if d.BaselineHex != "" || d.TestHex != "" {
+		fmt.Fprintf(&b, "\nbaseline:\n%s\ntest:\n%s", d.BaselineHex, d.TestHex)
+	}
+	return b.String()
+}
+
+// newDiff computes the diff of contents, offset is the first differing offset known by comparing, -1 if unknown.
+func (v *Validator) newDiff(b *client.Content, t *client.Content, offset int64) *Diff {
+	d := &Diff{Offset: offset, BaselineSize: b.Size, TestSize: t.Size, SizeDelta: t.Size - b.Size}
+	if b.Truncated || t.Truncated {
+		d.Note = "bodies larger than keep_body_size are not kept, only their prefixes are dumped"
+		d.Decoded = b.Decoded || t.Decoded
+		// offset is found by stream comparing, prefixes may end before it
+		if offset >= 0 && (offset < int64(len(b.Content)) || offset < int64(len(t.Content))) {
+			d.BaselineHex, d.TestHex = v.hexWindow(b.Content, t.Content, offset)
+		}
+		return d
+	}
+	if db, dt, err := v.decodeContents(b, t); err == nil {
+		d.Decoded = db != b || dt != t
+		b, t = db, dt
+		d.BaselineSize, d.TestSize, d.SizeDelta = int64(len(b.Content)), int64(len(t.Content)), int64(len(t.Content)-len(b.Content))
+	}
+	d.Offset = int64(firstDiff(b.Content, t.Content))
+
+	if isTextContent(b) && isTextContent(t) {
+		d.Text = true
+		d.Unified = unifiedDiff(splitLines(string(b.Content)), splitLines(string(t.Content)),
+			v.diff.Context, v.diff.MaxLines, v.diff.MaxLineBytes)
+	}
+	// text too, lines may be cut before the offset
+	if d.Offset >= 0 {
+		d.BaselineHex, d.TestHex = v.hexWindow(b.Content, t.Content, d.Offset)
+	}
+	return d
+}
+
+// hexWindow dumps both bodies around offset, by validator.diff.window.
+func (v *Validator) hexWindow(b []byte, t []byte, offset int64) (string, string) {
+	start := (offset - int64(v.diff.Window)) &^ 15
+	if start < 0 {
+		start = 0
+	}
+	end := offset + int64(v.diff.Window)
+	return hexdump(b, start, end), hexdump(t, start, end)
+}
+
+// isTextContent returns whether the body is text by Content-Type, or sniffed if there is no Content-Type.
+func isTextContent(c *client.Content) bool {
+	ct := c.Header.Get("Content-Type")
+	if ct == "" {
+		sniff := c.Content
+		if len(sniff) > diffSniffSize {
+			sniff = sniff[:diffSniffSize]
+		}
+		// a rune may be cut at the end
+		for i := 0; i < utf8.UTFMax && len(sniff) > 0 && !utf8.Valid(sniff); i++ {
+			sniff = sniff[:len(sniff)-1]
+		}
+		return utf8.Valid(sniff) && !strings.ContainsRune(string(sniff), 0)
+	}
+	mediaType, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript", "application/x-javascript",
+		"application/x-www-form-urlencoded", "application/yaml", "application/x-yaml", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// hexdump dumps data[start:end] like hexdump -C, with offsets from the start of data.
+func hexdump(data []byte, start int64, end int64) string {
+	if end > int64(len(data)) {
+		end = int64(len(data))
+	}
+	var b strings.Builder
+	for line := start; line < end; line += 16 {
+		fmt.Fprintf(&b, "%08x ", line)
+		ascii := make([]byte, 0, 16)
+		for i := line; i < line+16; i++ {
+			if i%8 == 0 {
+				b.WriteByte(' ')
+			}
+			if i >= end {
+				b.WriteString("   ")
+				continue
+			}
+			c := data[i]
+			fmt.Fprintf(&b, "%02x ", c)
+			if c < 0x20 || c > 0x7e {
+				c = '.'
+			}
+			ascii = append(ascii, c)
+		}
+		fmt.Fprintf(&b, " |%s|\n", ascii)
+	}
+	return b.String()
+}
+
+// splitLines splits s after each newline.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffOp is a line of unified diff: ' ' unchanged, '-' removed from baseline, '+' added in test.
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// diffLines returns edit operations turning a into b.
+// Common prefix and suffix are trimmed, the rest is matched by longest common subsequence if it's small enough.
+func diffLines(a []string, b []string) []diffOp {
+	p := 0
+	for p < len(a) && p < len(b) && a[p] == b[p] {
+		p++
+	}
+	s := 0
+	for s < len(a)-p && s < len(b)-p && a[len(a)-1-s] == b[len(b)-1-s] {
+		s++
+	}
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, l := range a[:p] {
+		ops = append(ops, diffOp{' ', l})
+	}
+	ma, mb := a[p:len(a)-s], b[p:len(b)-s]
+	n, m := len(ma), len(mb)
+	if n*m > maxDiffCells {
+		for _, l := range ma {
+			ops = append(ops, diffOp{'-', l})
+		}
+		for _, l := range mb {
+			ops = append(ops, diffOp{'+', l})
+		}
+	} else {
+		// lcs[i*(m+1)+j] is the lcs length of ma[i:] and mb[j:]
+		lcs := make([]int32, (n+1)*(m+1))
+		for i := n - 1; i >= 0; i-- {
+			for j := m - 1; j >= 0; j-- {
+				if ma[i] == mb[j] {
+					lcs[i*(m+1)+j] = lcs[(i+1)*(m+1)+j+1] + 1
+				} else if lcs[(i+1)*(m+1)+j] >= lcs[i*(m+1)+j+1] {
+					lcs[i*(m+1)+j] = lcs[(i+1)*(m+1)+j]
+				} else {
+					lcs[i*(m+1)+j] = lcs[i*(m+1)+j+1]
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < n || j < m {
+			switch {
+			case i < n && j < m && ma[i] == mb[j]:
+				ops = append(ops, diffOp{' ', ma[i]})
+				i++
+				j++
+			case j >= m || i < n && lcs[(i+1)*(m+1)+j] >= lcs[i*(m+1)+j+1]:
+				ops = append(ops, diffOp{'-', ma[i]})
+				i++
+			default:
+				ops = append(ops, diffOp{'+', mb[j]})
+				j++
+			}
+		}
+	}
+	for _, l := range a[len(a)-s:] {
+		ops = append(ops, diffOp{' ', l})
+	}
+	return ops
+}
+
+// cutLine cuts line to maxBytes bytes at a rune boundary, keeping its newline, no limit if maxBytes <= 0.
+func cutLine(line string, maxBytes int) string {
+	body := strings.TrimSuffix(line, "\n")
+	if maxBytes <= 0 || len(body) <= maxBytes {
+		return line
+	}
+	i := maxBytes
+	for i > 0 && !utf8.RuneStart(body[i]) {
+		i--
+	}
+	cut := body[:i] + fmt.Sprintf(" ... [%d bytes cut]", len(body)-i)
+	if len(body) < len(line) {
+		cut += "\n"
+	}
+	return cut
+}
+
+// unifiedDiff returns the unified diff of baseline lines a and test lines b, cut after maxLines lines,
+// and each line is cut after maxLineBytes bytes.
+func unifiedDiff(a []string, b []string, context int, maxLines int, maxLineBytes int) string {
+	ops := diffLines(a, b)
+	var out strings.Builder
+	lines := 0
+	write := func(s string) bool {
+		if lines >= maxLines {
+			return false
+		}
+		out.WriteString(s)
+		lines++
+		return true
+	}
+	write("--- baseline\n")
+	write("+++ test\n")
+
+	for start := 0; start < len(ops); {
+		// find the next change
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+		// extend the hunk while changes are within 2*context lines
+		end := start
+		for k := start; k < len(ops); k++ {
+			if ops[k].kind != ' ' {
+				end = k + 1
+			} else if k-end >= 2*context {
+				break
+			}
+		}
+		from := start - context
+		if from < 0 {
+			from = 0
+		}
+		to := end + context
+		if to > len(ops) {
+			to = len(ops)
+		}
+		aLine, bLine := 1, 1
+		for _, op := range ops[:from] {
+			if op.kind != '+' {
+				aLine++
+			}
+			if op.kind != '-' {
+				bLine++
+			}
+		}
+		aCount, bCount := 0, 0
+		for _, op := range ops[from:to] {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+		if !write(fmt.Sprintf("@@ -%s +%s @@\n", hunkRange(aLine, aCount), hunkRange(bLine, bCount))) {
+			break
+		}
+		for _, op := range ops[from:to] {
+			line := string(op.kind) + cutLine(op.line, maxLineBytes)
+			if !strings.HasSuffix(line, "\n") {
+				line += "\n\\ No newline at end of file\n"
+			}
+			if !write(line) {
+				break
+			}
+		}
+		start = to
+	}
+	if lines >= maxLines {
+		out.WriteString(fmt.Sprintf("... cut after %d lines\n", maxLines))
+	}
+	return out.String()
+}
+
+// hunkRange formats a hunk range, the line before an empty range is given as in diff -u.
+func hunkRange(line int, count int) string {
+	if count == 0 {
+		line--
+	}
+	if count == 1 {
+		return fmt.Sprint(line)
+	}
+	return fmt.Sprintf("%d,%d", line, count)
+}
+
+// ReadDiff loads the diff artifact of the case.
+func ReadDiff(id string) (*Diff, error) {
+	if !validCaseID(id) {
+		return nil, ErrCaseNotFound
+	}
+	data, ok := storage.Read(caseKey(id, caseKeyDiff))
+	if !ok {
+		if _, ok = storage.Read(caseKey(id, caseKeyMeta)); ok {
+			return nil, fmt.Errorf("%w: no diff of case %s", ErrCaseNotFound, id)
+		}
+		return nil, ErrCaseNotFound
+	}
+	d := &Diff{}
+	if err := json.Unmarshal(data, d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
@@ -0,0 +1,250 @@
+package validator
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+)
+
+func TestSplitLines(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{"", []string{}},
+		{"a", []string{"a"}},
+		{"a\n", []string{"a\n"}},
+		{"a\nb", []string{"a\n", "b"}},
+		{"a\n\nb\n", []string{"a\n", "\n", "b\n"}},
+	}
+	for _, tt := range tests {
+		if got := splitLines(tt.s); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitLines(%q): got %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+// formatOps renders ops as kind and line, eg: " a|-b|+c"
+func formatOps(ops []diffOp) string {
+	s := make([]string, len(ops))
+	for i, op := range ops {
+		s[i] = string(op.kind) + strings.TrimSuffix(op.line, "\n")
+	}
+	return strings.Join(s, "|")
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"same", "a\nb\n", "a\nb\n", " a| b"},
+		{"both empty", "", "", ""},
+		{"from empty", "", "a\nb\n", "+a|+b"},
+		{"to empty", "a\nb\n", "", "-a|-b"},
+		{"replace", "a\nb\nc\n", "a\nx\nc\n", " a|-b|+x| c"},
+		{"insert", "a\nc\n", "a\nb\nc\n", " a|+b| c"},
+		{"delete", "a\nb\nc\n", "a\nc\n", " a|-b| c"},
+		{"move", "a\nb\nc\nd\n", "b\nc\nd\na\n", "-a| b| c| d|+a"},
+		{"interleaved", "a\nb\nc\nd\ne\n", "a\nx\nc\ny\ne\n", " a|-b|+x| c|-d|+y| e"},
+		{"newline at end", "a\nb\n", "a\nb", " a|-b|+b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatOps(diffLines(splitLines(tt.a), splitLines(tt.b))); got != tt.want {
+				t.Fatalf("diffLines: got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLinesLargeBlock(t *testing.T) {
+	// too many cells to match, the changed block is removed and added as a whole
+	var a, b []string
+	a = append(a, "head\n")
+	b = append(b, "head\n")
+	for i := 0; i < 1100; i++ {
+		a = append(a, "a"+strings.Repeat("x", i%7)+"\n")
+		b = append(b, "b"+strings.Repeat("x", i%7)+"\n")
+	}
+	a = append(a, "tail\n")
+	b = append(b, "tail\n")
+
+	ops := diffLines(a, b)
+	if len(ops) != 2+2*1100 {
+		t.Fatalf("diffLines: got %d ops, want %d", len(ops), 2+2*1100)
+	}
+	if ops[0] != (diffOp{' ', "head\n"}) || ops[len(ops)-1] != (diffOp{' ', "tail\n"}) {
+		t.Fatalf("diffLines: common prefix and suffix are not kept")
+	}
+	for i, op := range ops[1 : len(ops)-1] {
+		want := byte('-')
+		if i >= 1100 {
+			want = '+'
+		}
+		if op.kind != want {
+			t.Fatalf("op %d: got %c, want %c", i+1, op.kind, want)
+		}
+	}
+}
+
+func TestCutLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		maxBytes int
+		want     string
+	}{
+		{"no limit", "abcdefgh\n", 0, "abcdefgh\n"},
+		{"short", "abc\n", 8, "abc\n"},
+		{"exact", "abcdefgh\n", 8, "abcdefgh\n"},
+		{"cut", "abcdefghij\n", 4, "abcd ... [6 bytes cut]\n"},
+		{"cut without newline", "abcdefghij", 4, "abcd ... [6 bytes cut]"},
+		{"cut at rune boundary", "ééé\n", 3, "é ... [4 bytes cut]\n"},
+		{"rune fits", "ééé\n", 4, "éé ... [2 bytes cut]\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cutLine(tt.line, tt.maxBytes); got != tt.want {
+				t.Fatalf("cutLine(%q, %d): got %q, want %q", tt.line, tt.maxBytes, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name         string
+		a, b         string
+		context      int
+		maxLines     int
+		maxLineBytes int
+		want         string
+	}{
+		{"same", "a\nb\n", "a\nb\n", 3, 100, 0,
+			"--- baseline\n+++ test\n"},
+		{"replace", "a\nb\nc\n", "a\nx\nc\n", 3, 100, 0,
+			"--- baseline\n+++ test\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n"},
+		{"from empty", "", "x\n", 3, 100, 0,
+			"--- baseline\n+++ test\n@@ -0,0 +1 @@\n+x\n"},
+		{"no newline at end", "a", "b", 3, 100, 0,
+			"--- baseline\n+++ test\n@@ -1 +1 @@\n-a\n\\ No newline at end of file\n+b\n\\ No newline at end of file\n"},
+		{"separate hunks", "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", "X\n2\n3\n4\n5\n6\n7\n8\n9\nY\n", 1, 100, 0,
+			"--- baseline\n+++ test\n@@ -1,2 +1,2 @@\n-1\n+X\n 2\n@@ -9,2 +9,2 @@\n 9\n-10\n+Y\n"},
+		{"joined hunk", "1\n2\n3\n4\n", "X\n2\n3\nY\n", 1, 100, 0,
+			"--- baseline\n+++ test\n@@ -1,4 +1,4 @@\n-1\n+X\n 2\n 3\n-4\n+Y\n"},
+		{"cut lines", "a\nb\nc\n", "a\nx\nc\n", 3, 4, 0,
+			"--- baseline\n+++ test\n@@ -1,3 +1,3 @@\n a\n... cut after 4 lines\n"},
+		{"cut line bytes", "0123456789\n", "0123456789abc\n", 3, 100, 4,
+			"--- baseline\n+++ test\n@@ -1 +1 @@\n-0123 ... [6 bytes cut]\n+0123 ... [9 bytes cut]\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := unifiedDiff(splitLines(tt.a), splitLines(tt.b), tt.context, tt.maxLines, tt.maxLineBytes)
+			if got != tt.want {
+				t.Fatalf("unifiedDiff: got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestNewDiff(t *testing.T) {
+	v := &Validator{diff: DiffOptions{Context: 3, Window: 16, MaxLines: 100, MaxLineBytes: 8}}
+	text := http.Header{"Content-Type": []string{"text/plain"}}
+	binary := http.Header{"Content-Type": []string{"application/octet-stream"}}
+	gzipText := http.Header{"Content-Type": []string{"text/plain"}, "Content-Encoding": []string{"gzip"}}
+	long := strings.Repeat("x", 100)
+
+	tests := []struct {
+		name        string
+		b, t        *client.Content
+		offset      int64
+		wantOffset  int64
+		wantText    bool
+		wantDecoded bool
+		wantUnified string // substring
+		wantHex     bool
+		wantNote    bool
+	}{
+		{"text", &client.Content{Header: text, Content: []byte("a\nb\n"), Size: 4},
+			&client.Content{Header: text, Content: []byte("a\nc\n"), Size: 4},
+			2, 2, true, false, "-b\n+c\n", true, false},
+		{"minified text", &client.Content{Header: text, Content: []byte(long + "A" + long), Size: 201},
+			&client.Content{Header: text, Content: []byte(long + "B" + long), Size: 201},
+			100, 100, true, false, "-xxxxxxxx ... [193 bytes cut]", true, false},
+		{"binary", &client.Content{Header: binary, Content: []byte{0, 1, 2, 3}, Size: 4},
+			&client.Content{Header: binary, Content: []byte{0, 1, 9, 3}, Size: 4},
+			2, 2, false, false, "", true, false},
+		{"decoded", &client.Content{Header: gzipText, Content: gzipBytes(t, []byte("a\nb\n"))},
+			&client.Content{Header: text, Content: []byte("a\nc\n")},
+			-1, 2, true, true, "-b\n+c\n", true, false},
+		{"truncated within prefixes", &client.Content{Header: binary, Content: []byte{0, 1, 2, 3}, Size: 1000, Truncated: true},
+			&client.Content{Header: binary, Content: []byte{0, 1, 9, 3}, Size: 1000, Truncated: true},
+			2, 2, false, false, "", true, true},
+		{"truncated beyond prefixes", &client.Content{Header: binary, Content: []byte{0, 1, 2, 3}, Size: 1000, Truncated: true},
+			&client.Content{Header: binary, Content: []byte{0, 1, 2, 3}, Size: 1000, Truncated: true},
+			500, 500, false, false, "", false, true},
+		{"truncated offset unknown", &client.Content{Header: binary, Content: []byte{0, 1, 2, 3}, Size: 1000, Truncated: true},
+			&client.Content{Header: binary, Size: 1001, Truncated: true},
+			-1, -1, false, false, "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := v.newDiff(tt.b, tt.t, tt.offset)
+			if d.Offset != tt.wantOffset || d.Text != tt.wantText || d.Decoded != tt.wantDecoded {
+				t.Fatalf("newDiff: got offset %d, text %v, decoded %v, want %d, %v, %v",
+					d.Offset, d.Text, d.Decoded, tt.wantOffset, tt.wantText, tt.wantDecoded)
+			}
+			if !strings.Contains(d.Unified, tt.wantUnified) || (tt.wantUnified == "") != (d.Unified == "") {
+				t.Fatalf("newDiff: unified diff %q doesn't contain %q", d.Unified, tt.wantUnified)
+			}
+			if (d.BaselineHex != "" && d.TestHex != "") != tt.wantHex {
+				t.Fatalf("newDiff: got hexdumps %q and %q, want hexdumps %v", d.BaselineHex, d.TestHex, tt.wantHex)
+			}
+			if (d.Note != "") != tt.wantNote {
+				t.Fatalf("newDiff: got note %q, want note %v", d.Note, tt.wantNote)
+			}
+		})
+	}
+}
+
+func TestHexdump(t *testing.T) {
+	data := []byte("0123456789abcdef\x00\x01ghij")
+	tests := []struct {
+		name       string
+		start, end int64
+		want       string
+	}{
+		{"one line", 0, 16,
+			"00000000  30 31 32 33 34 35 36 37  38 39 61 62 63 64 65 66  |0123456789abcdef|\n"},
+		{"partial line", 16, 100,
+			"00000010  00 01 67 68 69 6a                                 |..ghij|\n"},
+		{"empty", 30, 40, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hexdump(data, tt.start, tt.end); got != tt.want {
+				t.Fatalf("hexdump(%d, %d): got\n%q\nwant\n%q", tt.start, tt.end, got, tt.want)
+			}
+		})
+	}
+}
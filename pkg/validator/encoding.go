@@ -0,0 +1,128 @@
+package validator
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+	"github.com/klauspost/compress/zstd"
+)
+
+// contentEncodings returns Content-Encoding values in applied order, identity is omitted.
+func contentEncodings(c *client.Content) []string {
+	var encodings []string
+	for _, v := range c.Header.Values("Content-Encoding") {
+		for _, e := range strings.Split(v, ",") {
+			e = strings.ToLower(strings.TrimSpace(e))
+			if e != "" && e != "identity" {
+				encodings = append(encodings, e)
+			}
+		}
+	}
+	return encodings
+}
+
+// contentEncoding returns Content-Encoding for result record, "identity" if not encoded.
+func contentEncoding(c *client.Content) string {
+	if c == nil {
+		return ""
+	}
+	encodings := contentEncodings(c)
+	if len(encodings) == 0 {
+		return "identity"
+	}
+	return strings.Join(encodings, ",")
+}
+
+// newDecodeReader decodes r by encodings, the last applied encoding is decoded first.
+func newDecodeReader(r io.Reader, encodings []string) (io.Reader, error) {
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var err error
+		switch encodings[i] {
+		case "gzip", "x-gzip":
+			r, err = gzip.NewReader(r)
+		case "deflate":
+			r, err = newDeflateReader(r)
+		case "br":
+			r = brotli.NewReader(r)
+		case "zstd":
+			var d *zstd.Decoder
+			// synchronous decoding, no background goroutines to close
+			d, err = zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err == nil {
+				r = d.IOReadCloser()
+			}
+		default:
+			err = fmt.Errorf("unsupported content encoding: %s", encodings[i])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// newDeflateReader reads "deflate" which should be zlib format, but some servers send raw deflate.
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	// zlib header: CMF*256 + FLG is multiple of 31, and CM is 8
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// decodeContent returns a copy of c with decoded body.
+// c itself is returned if it's not encoded, already decoded, or truncated.
+func decodeContent(c *client.Content) (*client.Content, error) {
+	if c.Decoded || c.Truncated {
+		return c, nil
+	}
+	encodings := contentEncodings(c)
+	if len(encodings) == 0 {
+		return c, nil
+	}
+	r, err := newDecodeReader(bytes.NewReader(c.Content), encodings)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return &client.Content{
+		Status:  c.Status,
+		Header:  c.Header,
+		Content: body,
+		Size:    int64(len(body)),
+		Decoded: true,
+	}, nil
+}
+
+// decodeContents decodes both contents before comparing, unless in strict mode.
+func (v *Validator) decodeContents(b *client.Content, t *client.Content) (*client.Content, *client.Content, error) {
+	if v.strictEncoding {
+		return b, t, nil
+	}
+	db, err := decodeContent(b)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode baseline content error: %w", err)
+	}
+	dt, err := decodeContent(t)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode test content error: %w", err)
+	}
+	return db, dt, nil
+}
@@ -0,0 +1,269 @@
+package validator
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"go/format"
+	"net/http"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+)
+
+// export formats of a stored case
+const (
+	// ExportCurl is a shell script with one curl command per target, sending exactly what Fetcher.Do sends.
+	ExportCurl = "curl"
+	// ExportGo is a Go test file replaying the request against httptest servers of both recorded responses.
+	ExportGo = "go"
+)
+
+// ErrExportFormat means the export format is unknown.
+var ErrExportFormat = errors.New("unknown export format")
+
+// Export loads the case and exports it in format.
+func Export(id string, format string) ([]byte, error) {
+	c, err := ReadCase(id)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case ExportCurl, "":
+		return ExportCurlScript(c)
+	case ExportGo:
+		return ExportGoFixture(c)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrExportFormat, format)
+}
+
+// shellQuote quotes s in single quotes for sh.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// curlCommand returns the curl command sending req, response headers and body are saved to <name>.headers and <name>.body.
+// HEAD is sent by --head, curl waits for a body of -X HEAD which never comes, and headers are saved only.
+func curlCommand(name string, req *http.Request, body []byte) string {
+	var b strings.Builder
+	if len(body) > 0 {
+		fmt.Fprintf(&b, "printf '%%s' %s | base64 -d | ", shellQuote(base64.StdEncoding.EncodeToString(body)))
+	}
+	method := "-X " + shellQuote(req.Method)
+	if req.Method == http.MethodHead {
+		method = "--head"
+	}
+	fmt.Fprintf(&b, "curl -sS --globoff --path-as-is %s %s \\\n", method, shellQuote(req.URL.String()))
+	fmt.Fprintf(&b, "  -H %s \\\n", shellQuote("Host: "+req.Host))
+
+	keys := make([]string, 0, len(req.Header))
+	for k := range req.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range req.Header[k] {
+			fmt.Fprintf(&b, "  -H %s \\\n", shellQuote(k+": "+v))
+		}
+	}
+	// headers net/http adds, or curl adds but net/http doesn't
+	if req.Header.Get("User-Agent") == "" {
+		fmt.Fprintf(&b, "  -H %s \\\n", shellQuote("User-Agent: Go-http-client/1.1"))
+	}
+	if _, ok := req.Header["Accept"]; !ok {
+		fmt.Fprintf(&b, "  -H %s \\\n", shellQuote("Accept:"))
+	}
+	if len(body) > 0 {
+		b.WriteString("  --data-binary @- \\\n")
+	}
+	if req.Method == http.MethodHead {
+		// --head writes headers to the output too
+		fmt.Fprintf(&b, "  -D %s -o /dev/null\n", shellQuote(name+".headers"))
+		return b.String()
+	}
+	fmt.Fprintf(&b, "  -D %s -o %s\n", shellQuote(name+".headers"), shellQuote(name+".body"))
+	return b.String()
+}
+
+// ExportCurlScript exports the case as a curl command pair, for baseline and the test target.
+// Requests are built by Fetcher.NewRequest, with rewrite rules and addresses of current config.
+func ExportCurlScript(c *Case) ([]byte, error) {
+	fetchers := []*client.Fetcher{client.BaselineFetcher}
+	for _, f := range client.TestFetchers {
+		if f.Name == c.Target {
+			fetchers = append(fetchers, f)
+		}
+	}
+	if len(fetchers) < 2 {
+		return nil, fmt.Errorf("unknown test target: %s", c.Target)
+	}
+
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n")
+	fmt.Fprintf(&b, "# case %s: %s, target %s, %s%s\n", c.ID, c.State, c.Target, c.Host, c.URL)
+	if c.Verdict.Reason != "" {
+		fmt.Fprintf(&b, "# reason: %s\n", c.Verdict.Reason)
+	}
+	b.WriteString("# responses are saved to <target>.headers and <target>.body\n")
+	for _, f := range fetchers {
+		req, err := f.NewRequest(c.Request)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&b, "\n# %s\n", f.Name)
+		b.WriteString(curlCommand(f.Name, req, f.Rewriter.Rewrite(c.Request).Body))
+	}
+	if c.Request.Method == http.MethodHead {
+		b.WriteString("\n# HEAD responses have no body, compare <target>.headers\n")
+		return []byte(b.String()), nil
+	}
+	b.WriteString("\ncmp " + shellQuote(client.BaselineName+".body") + " " + shellQuote(c.Target+".body") + "\n")
+	return []byte(b.String()), nil
+}
+
+// hop-by-hop and length headers are not replayed by fixtures, net/http sets them
+var fixtureSkipHeaders = map[string]struct{}{
+	"Connection":        {},
+	"Keep-Alive":        {},
+	"Transfer-Encoding": {},
+	"Content-Length":    {},
+}
+
+var fixtureTemplate = template.Must(template.New("fixture").Parse(`package fixture
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestCase{{.Name}} replays case {{.Case.ID}} against recorded responses.
+// {{.Case.State}} of target {{.Case.Target}}: {{.Case.Host}}{{.Case.URL}}
+{{- if .Case.Verdict.Reason}}
+// reason: {{.Case.Verdict.Reason}}
+{{- end}}
+{{- range .Notes}}
+// note: {{.}}
+{{- end}}
+// Replace the test server by the fixed build to turn it into a regression test.
+func TestCase{{.Name}}(t *testing.T) {
+	serve := func(status int, header http.Header, body []byte) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for k, vv := range header {
+				w.Header()[k] = vv
+			}
+			w.WriteHeader(status)
+			_, _ = w.Write(body)
+		}))
+	}
+	baseline := serve({{.Baseline.Status}}, {{.Baseline.Header}}, []byte({{.Baseline.Body}}))
+	defer baseline.Close()
+	test := serve({{.Test.Status}}, {{.Test.Header}}, []byte({{.Test.Body}}))
+	defer test.Close()
+
+	fetch := func(server string) (int, []byte) {
+		req, err := http.NewRequest({{.Method}}, server+{{.URL}}, bytes.NewReader([]byte({{.Body}})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = {{.Host}}
+		req.Header = {{.Header}}
+		// keep bodies encoded as servers send
+		c := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, body
+	}
+	baselineStatus, baselineBody := fetch(baseline.URL)
+	testStatus, testBody := fetch(test.URL)
+	if baselineStatus != testStatus {
+		t.Errorf("status: baseline %d, test %d", baselineStatus, testStatus)
+	}
+	if !bytes.Equal(baselineBody, testBody) {
+		t.Errorf("body: baseline %d bytes, test %d bytes", len(baselineBody), len(testBody))
+	}
+}
+`))
+
+type fixtureResponse struct {
+	Status int
+	Header string
+	Body   string
+}
+
+// goHeader returns Go literal of header, sorted by key.
+func goHeader(h http.Header, skip map[string]struct{}) string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		if _, ok := skip[http.CanonicalHeaderKey(k)]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return "http.Header{}"
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString("http.Header{\n")
+	for _, k := range keys {
+		fmt.Fprintf(&b, "\t\t%q: {", k)
+		for i, v := range h[k] {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			fmt.Fprintf(&b, "%q", v)
+		}
+		b.WriteString("},\n")
+	}
+	b.WriteString("\t}")
+	return b.String()
+}
+
+// ExportGoFixture exports the case as a Go test with httptest servers of both recorded responses.
+func ExportGoFixture(c *Case) ([]byte, error) {
+	var notes []string
+	response := func(name string, content *client.Content) fixtureResponse {
+		if content == nil {
+			notes = append(notes, name+" response was not recorded")
+			return fixtureResponse{Status: http.StatusBadGateway, Header: "http.Header{}", Body: `""`}
+		}
+		if content.Truncated {
+			notes = append(notes, fmt.Sprintf("%s body of %d bytes, md5 %s, was too large to record", name, content.Size, content.MD5))
+		}
+		return fixtureResponse{
+			Status: content.Status,
+			Header: goHeader(content.Header, fixtureSkipHeaders),
+			Body:   fmt.Sprintf("%q", content.Content),
+		}
+	}
+	data := map[string]interface{}{
+		"Name":     strings.NewReplacer(".", "", "-", "_").Replace(c.ID),
+		"Case":     c,
+		"Baseline": response(client.BaselineName, c.Baseline),
+		"Test":     response(c.Target, c.Test),
+		"Method":   fmt.Sprintf("%q", c.Request.Method),
+		"URL":      fmt.Sprintf("%q", c.Request.URL),
+		"Host":     fmt.Sprintf("%q", c.Request.Host),
+		"Header":   goHeader(c.Request.Header, nil),
+		"Body":     fmt.Sprintf("%q", c.Request.Body),
+	}
+	data["Notes"] = notes
+
+	var b bytes.Buffer
+	if err := fixtureTemplate.Execute(&b, data); err != nil {
+		return nil, err
+	}
+	return format.Source(b.Bytes())
+}
@@ -0,0 +1,171 @@
+package validator
+
+import (
+	"errors"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+)
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"", `''`},
+		{"/a b", `'/a b'`},
+		{"it's", `'it'\''s'`},
+		{"$HOME `id` \"x\"", "'$HOME `id` \"x\"'"},
+	}
+	for _, tt := range tests {
+		if got := shellQuote(tt.s); got != tt.want {
+			t.Errorf("shellQuote(%q): got %s, want %s", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestCurlCommand(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		header http.Header
+		body   []byte
+		want   string
+	}{
+		{"get", http.MethodGet, "http://127.0.0.1:8080/a?x=1&y='2'",
+			http.Header{"User-Agent": {"curl"}, "Accept": {"*/*"}, "X-B": {"1", "it's"}}, nil,
+			`curl -sS --globoff --path-as-is -X 'GET' 'http://127.0.0.1:8080/a?x=1&y='\''2'\''' \
+  -H 'Host: a.com' \
+  -H 'Accept: */*' \
+  -H 'User-Agent: curl' \
+  -H 'X-B: 1' \
+  -H 'X-B: it'\''s' \
+  -D 'test.headers' -o 'test.body'
+`},
+		{"head", http.MethodHead, "http://127.0.0.1:8080/a", http.Header{}, nil,
+			`curl -sS --globoff --path-as-is --head 'http://127.0.0.1:8080/a' \
+  -H 'Host: a.com' \
+  -H 'User-Agent: Go-http-client/1.1' \
+  -H 'Accept:' \
+  -D 'test.headers' -o /dev/null
+`},
+		{"post with body", http.MethodPost, "http://127.0.0.1:8080/a", http.Header{"Accept": {"*/*"}}, []byte("q='1'\n"),
+			`printf '%s' 'cT0nMScK' | base64 -d | curl -sS --globoff --path-as-is -X 'POST' 'http://127.0.0.1:8080/a' \
+  -H 'Host: a.com' \
+  -H 'Accept: */*' \
+  -H 'User-Agent: Go-http-client/1.1' \
+  --data-binary @- \
+  -D 'test.headers' -o 'test.body'
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			req.Host = "a.com"
+			req.Header = tt.header
+			if got := curlCommand("test", req, tt.body); got != tt.want {
+				t.Fatalf("curlCommand:\ngot:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportCurlScript(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	setBaseline(t, srv)
+	setTest(t, srv)
+
+	c := newCase(time.Now(), "/a", StateContentNotMatch)
+	script, err := ExportCurlScript(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"#!/bin/sh\n", "\n# baseline\ncurl ", "\n# test\ncurl ", "-D 'baseline.headers' -o 'baseline.body'",
+		"-D 'test.headers' -o 'test.body'", "\ncmp 'baseline.body' 'test.body'\n"} {
+		if !strings.Contains(string(script), want) {
+			t.Errorf("script has no %q:\n%s", want, script)
+		}
+	}
+
+	c.Request.Method = http.MethodHead
+	script, err = ExportCurlScript(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(script), "-X 'HEAD'") || strings.Count(string(script), "--head") != 2 || strings.Contains(string(script), "cmp ") {
+		t.Errorf("HEAD script: got\n%s", script)
+	}
+
+	c.Target = "other"
+	if _, err = ExportCurlScript(c); err == nil {
+		t.Fatal("export unknown target: got no error")
+	}
+}
+
+func TestExportGoFixture(t *testing.T) {
+	c := newCase(time.Now(), "/a?x=\"1\"", StateContentNotMatch)
+	c.Request.Method = http.MethodPost
+	c.Request.Header.Set("X-A", "1")
+	c.Request.Body = []byte("q=1")
+	c.Baseline.Header = http.Header{"Content-Type": {"text/plain"}, "Content-Length": {"1"}, "Connection": {"close"}}
+	c.Test = &client.Content{Status: http.StatusOK, Truncated: true, Size: 1 << 20, MD5: "0cc175b9c0f1b6a831c399e269772661"}
+
+	src, err := ExportGoFixture(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = parser.ParseFile(token.NewFileSet(), "case_test.go", src, 0); err != nil {
+		t.Fatalf("fixture doesn't parse: %v\n%s", err, src)
+	}
+	name := strings.NewReplacer(".", "", "-", "_").Replace(c.ID)
+	for _, want := range []string{
+		"func TestCase" + name + "(t *testing.T) {",
+		`http.NewRequest("POST", server+"/a?x=\"1\"", bytes.NewReader([]byte("q=1")))`,
+		`req.Host = "a.com"`,
+		`"X-A": {"1"},`,
+		`"Content-Type": {"text/plain"},`,
+		`serve(200, http.Header{`,
+		`[]byte("a"))`,
+		"// note: test body of 1048576 bytes, md5 0cc175b9c0f1b6a831c399e269772661, was too large to record",
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("fixture has no %q:\n%s", want, src)
+		}
+	}
+	// hop-by-hop and length headers are not replayed
+	for _, h := range []string{"Content-Length", "Connection"} {
+		if strings.Contains(string(src), h) {
+			t.Errorf("fixture replays %s:\n%s", h, src)
+		}
+	}
+
+	c.Baseline = nil
+	if src, err = ExportGoFixture(c); err != nil || !strings.Contains(string(src), "// note: baseline response was not recorded") {
+		t.Fatalf("fixture without baseline: got %v\n%s", err, src)
+	}
+}
+
+func TestExportFormat(t *testing.T) {
+	setStorage(t)
+	c := newCase(time.Now(), "/a", StateContentNotMatch)
+	if err := WriteCase(c); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Export(c.ID, "har"); !errors.Is(err, ErrExportFormat) {
+		t.Fatalf("Export har: got %v, want %v", err, ErrExportFormat)
+	}
+	if _, err := Export(c.ID, ExportGo); err != nil {
+		t.Fatalf("Export go: %v", err)
+	}
+	if _, err := Export("20231018T092106.123-000000000000-00000000", ExportGo); !errors.Is(err, ErrCaseNotFound) {
+		t.Fatalf("Export missing case: got %v, want %v", err, ErrCaseNotFound)
+	}
+}
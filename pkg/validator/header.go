@@ -0,0 +1,137 @@
+package validator
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+)
+
+// StateHeaderNotMatch means body is the same, but headers differ by header policy.
+const StateHeaderNotMatch = "HEADER_NOT_MATCH"
+
+type HeaderOptions struct {
+	Enable bool
+	// Match is headers that must match, empty means all headers except ignored ones.
+	Match []string
+	// Ignore is headers never compared, eg: Date, Age, Via, X-Cache
+	Ignore []string
+	// Normalize is value normalizers applied before comparing, keyed by header.
+	Normalize map[string][]string
+}
+
+type normalizer func(string) string
+
+var normalizers = map[string]normalizer{
+	// lowercase: "No-Cache" -> "no-cache"
+	"lowercase": strings.ToLower,
+	// trim: " a " -> "a"
+	"trim": strings.TrimSpace,
+	// sort_list: "public, max-age=60" -> "max-age=60,public"
+	"sort_list": func(s string) string {
+		items := strings.Split(s, ",")
+		for i := range items {
+			items[i] = strings.TrimSpace(items[i])
+		}
+		sort.Strings(items)
+		return strings.Join(items, ",")
+	},
+	// strip_weak: `W/"abc"` -> `"abc"`
+	"strip_weak": func(s string) string {
+		return strings.TrimPrefix(strings.TrimSpace(s), "W/")
+	},
+	// strip_params: "text/html; charset=utf-8" -> "text/html"
+	"strip_params": func(s string) string {
+		if i := strings.Index(s, ";"); i >= 0 {
+			return strings.TrimSpace(s[:i])
+		}
+		return s
+	},
+}
+
+type headerPolicy struct {
+	match     map[string]struct{}
+	ignore    map[string]struct{}
+	normalize map[string][]normalizer
+}
+
+func newHeaderPolicy(opt HeaderOptions, strictEncoding bool) (*headerPolicy, error) {
+	if !opt.Enable {
+		return nil, nil
+	}
+	p := &headerPolicy{
+		match:     map[string]struct{}{},
+		ignore:    map[string]struct{}{},
+		normalize: map[string][]normalizer{},
+	}
+	for _, h := range opt.Match {
+		p.match[http.CanonicalHeaderKey(h)] = struct{}{}
+	}
+	for _, h := range append(opt.Ignore, client.HopHeaders...) {
+		p.ignore[http.CanonicalHeaderKey(h)] = struct{}{}
+	}
+	if !strictEncoding {
+		// bodies are compared decoded, encoded length may differ
+		p.ignore["Content-Encoding"] = struct{}{}
+		p.ignore["Content-Length"] = struct{}{}
+	}
+	for h, names := range opt.Normalize {
+		for _, name := range names {
+			n, ok := normalizers[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown header normalizer: %s", name)
+			}
+			key := http.CanonicalHeaderKey(h)
+			p.normalize[key] = append(p.normalize[key], n)
+		}
+	}
+	return p, nil
+}
+
+// diff returns names of headers which differ, sorted.
+func (p *headerPolicy) diff(b http.Header, t http.Header) []string {
+	if p == nil {
+		return nil
+	}
+	keys := map[string]struct{}{}
+	if len(p.match) > 0 {
+		keys = p.match
+	} else {
+		for k := range b {
+			keys[http.CanonicalHeaderKey(k)] = struct{}{}
+		}
+		for k := range t {
+			keys[http.CanonicalHeaderKey(k)] = struct{}{}
+		}
+	}
+
+	var diff []string
+	for k := range keys {
+		if _, ok := p.ignore[k]; ok {
+			continue
+		}
+		if p.value(k, b) != p.value(k, t) {
+			diff = append(diff, k)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+func (p *headerPolicy) value(key string, h http.Header) string {
+	values := h.Values(key)
+	if len(values) == 0 {
+		// missing header is different from empty one
+		return "\x00"
+	}
+	normalized := make([]string, len(values))
+	for i, v := range values {
+		for _, n := range p.normalize[key] {
+			v = n(v)
+		}
+		normalized[i] = v
+	}
+	return strings.Join(normalized, ",")
+}
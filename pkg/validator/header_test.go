@@ -0,0 +1,116 @@
+package validator
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+)
+
+func TestHeaderPolicyDiff(t *testing.T) {
+	tests := []struct {
+		name   string
+		opt    HeaderOptions
+		strict bool
+		b, t   http.Header
+		want   []string
+	}{
+		{"same", HeaderOptions{}, false,
+			http.Header{"Etag": {`"a"`}, "X-A": {"1"}}, http.Header{"Etag": {`"a"`}, "X-A": {"1"}}, nil},
+		{"differ sorted", HeaderOptions{}, false,
+			http.Header{"X-B": {"1"}, "X-A": {"1"}}, http.Header{"X-B": {"2"}, "X-A": {"2"}}, []string{"X-A", "X-B"}},
+		{"missing differs from empty", HeaderOptions{}, false,
+			http.Header{"X-A": {""}}, http.Header{}, []string{"X-A"}},
+		{"multiple values in order", HeaderOptions{}, false,
+			http.Header{"Vary": {"A", "B"}}, http.Header{"Vary": {"B", "A"}}, []string{"Vary"}},
+		{"ignored", HeaderOptions{Ignore: []string{"date", "X-Cache"}}, false,
+			http.Header{"Date": {"1"}, "X-Cache": {"HIT"}}, http.Header{"Date": {"2"}, "X-Cache": {"MISS"}}, nil},
+		{"hop-by-hop ignored", HeaderOptions{}, false,
+			http.Header{"Connection": {"close"}}, http.Header{"Connection": {"keep-alive"}}, nil},
+		{"encoding ignored when decoded", HeaderOptions{}, false,
+			http.Header{"Content-Encoding": {"gzip"}, "Content-Length": {"10"}}, http.Header{"Content-Length": {"20"}}, nil},
+		{"encoding compared in strict mode", HeaderOptions{}, true,
+			http.Header{"Content-Encoding": {"gzip"}, "Content-Length": {"10"}}, http.Header{"Content-Length": {"20"}},
+			[]string{"Content-Encoding", "Content-Length"}},
+		{"match only", HeaderOptions{Match: []string{"etag"}}, false,
+			http.Header{"Etag": {`"a"`}, "X-A": {"1"}}, http.Header{"Etag": {`"b"`}, "X-A": {"2"}}, []string{"Etag"}},
+		{"match missing", HeaderOptions{Match: []string{"Location"}}, false,
+			http.Header{"Location": {"/a"}}, http.Header{}, []string{"Location"}},
+		{"ignore wins over match", HeaderOptions{Match: []string{"Date"}, Ignore: []string{"Date"}}, false,
+			http.Header{"Date": {"1"}}, http.Header{"Date": {"2"}}, nil},
+		{"lowercase and trim", HeaderOptions{Normalize: map[string][]string{"cache-control": {"lowercase", "trim"}}}, false,
+			http.Header{"Cache-Control": {"No-Cache "}}, http.Header{"Cache-Control": {"no-cache"}}, nil},
+		{"sort_list", HeaderOptions{Normalize: map[string][]string{"Cache-Control": {"sort_list"}}}, false,
+			http.Header{"Cache-Control": {"public, max-age=60"}}, http.Header{"Cache-Control": {"max-age=60,public"}}, nil},
+		{"strip_weak", HeaderOptions{Normalize: map[string][]string{"Etag": {"strip_weak"}}}, false,
+			http.Header{"Etag": {`W/"a"`}}, http.Header{"Etag": {`"a"`}}, nil},
+		{"strip_params", HeaderOptions{Normalize: map[string][]string{"Content-Type": {"strip_params"}}}, false,
+			http.Header{"Content-Type": {"text/html; charset=utf-8"}}, http.Header{"Content-Type": {"text/html"}}, nil},
+		{"normalized still differ", HeaderOptions{Normalize: map[string][]string{"Etag": {"strip_weak"}}}, false,
+			http.Header{"Etag": {`W/"a"`}}, http.Header{"Etag": {`"b"`}}, []string{"Etag"}},
+		{"normalizer of another header", HeaderOptions{Normalize: map[string][]string{"Etag": {"lowercase"}}}, false,
+			http.Header{"X-A": {"A"}}, http.Header{"X-A": {"a"}}, []string{"X-A"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opt.Enable = true
+			p, err := newHeaderPolicy(tt.opt, tt.strict)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := p.diff(tt.b, tt.t); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("diff: got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewHeaderPolicy(t *testing.T) {
+	if p, err := newHeaderPolicy(HeaderOptions{Match: []string{"Etag"}}, false); p != nil || err != nil {
+		t.Fatalf("newHeaderPolicy disabled: got %v, %v, want nil", p, err)
+	}
+	var p *headerPolicy
+	if got := p.diff(http.Header{"Etag": {"a"}}, http.Header{}); got != nil {
+		t.Fatalf("diff of disabled policy: got %v, want nil", got)
+	}
+	if _, err := newHeaderPolicy(HeaderOptions{Enable: true, Normalize: map[string][]string{"Etag": {"upper"}}}, false); err == nil {
+		t.Fatal("newHeaderPolicy with unknown normalizer: got no error")
+	}
+}
+
+func TestCheckHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		enable   bool
+		status   int
+		b, t     http.Header
+		want     string
+		wantDiff []string
+	}{
+		{"redirect same", true, http.StatusFound,
+			http.Header{"Location": {"/a"}}, http.Header{"Location": {"/a"}}, StateStatusSkip, nil},
+		{"redirect location differs", true, http.StatusMovedPermanently,
+			http.Header{"Location": {"/a"}}, http.Header{"Location": {"/b"}}, StateHeaderNotMatch, []string{"Location"}},
+		{"not modified etag differs", true, http.StatusNotModified,
+			http.Header{"Etag": {`"a"`}}, http.Header{"Etag": {`"b"`}}, StateHeaderNotMatch, []string{"Etag"}},
+		{"redirect without header policy", false, http.StatusFound,
+			http.Header{"Location": {"/a"}}, http.Header{"Location": {"/b"}}, StateStatusSkip, nil},
+		{"ok cache control differs", true, http.StatusOK,
+			http.Header{"Cache-Control": {"max-age=60"}}, http.Header{"Cache-Control": {"no-cache"}}, StateHeaderNotMatch, []string{"Cache-Control"}},
+		{"ok same", true, http.StatusOK,
+			http.Header{"Cache-Control": {"max-age=60"}}, http.Header{"Cache-Control": {"max-age=60"}}, StatePass, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewValidator(Options{Header: HeaderOptions{Enable: tt.enable}})
+			r := &client.Request{Method: http.MethodGet, Host: "a.com", URL: "/a", Header: http.Header{}}
+			b := &client.Content{Status: tt.status, Header: tt.b, Content: []byte("a"), Size: 1}
+			c := &client.Content{Status: tt.status, Header: tt.t, Content: []byte("a"), Size: 1}
+			res := v.check(r, nil, b, nil, c, nil)
+			if res.state != tt.want || !reflect.DeepEqual(res.headerDiff, tt.wantDiff) {
+				t.Fatalf("check: got %s %v, want %s %v", res.state, res.headerDiff, tt.want, tt.wantDiff)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package validator
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bocchi-the-cache/inspector/pkg/common/logger"
+	"github.com/bocchi-the-cache/inspector/pkg/index"
+	"github.com/bocchi-the-cache/inspector/pkg/monitor"
+	"github.com/bocchi-the-cache/inspector/pkg/storage"
+)
+
+// eviction reasons of bad cases
+const (
+	EvictAge        = "MaxAge"
+	EvictCasesOfURL = "MaxCasesPerURL"
+	EvictSize       = "MaxSize"
+)
+
+const defaultJanitorInterval = 10 * time.Minute
+
+// RetentionOptions limits bad cases in storage, zero means no limit.
+type RetentionOptions struct {
+	// MaxSize is max total bytes of bad cases, oldest cases are evicted first.
+	MaxSize int64
+	// MaxAge evicts cases older than it.
+	MaxAge time.Duration
+	// MaxCasesPerURL keeps only the newest cases of the same host and url.
+	MaxCasesPerURL int
+	// Interval is how often the janitor sweeps storage.
+	Interval time.Duration
+}
+
+// Janitor enforces retention of bad cases in background, and exports storage usage.
+type Janitor struct {
+	opt RetentionOptions
+
+	// urls caches host and url of cases, which never change
+	mu   sync.Mutex
+	urls map[string]string
+}
+
+func NewJanitor(opt RetentionOptions) *Janitor {
+	if opt.Interval <= 0 {
+		opt.Interval = defaultJanitorInterval
+	}
+	return &Janitor{
+		opt:  opt,
+		urls: map[string]string{},
+	}
+}
+
+// storedCase is a case found in storage, or a key not in case layout.
+type storedCase struct {
+	// id is the case directory, eg: <date>/<case id>, archive/<date>/<case id>, or the key not in case layout
+	id   string
+	time time.Time
+	size int64
+	keys []string
+}
+
+// caseID returns the case id, archived cases and keys not in case layout have none.
+func (c *storedCase) caseID() string {
+	parts := strings.Split(c.id, "/")
+	if len(parts) != 2 || !strings.HasPrefix(parts[1], parts[0]) {
+		return ""
+	}
+	return parts[1]
+}
+
+// Run sweeps storage every interval, it never returns.
+func (j *Janitor) Run() {
+	for {
+		j.Sweep()
+		time.Sleep(j.opt.Interval)
+	}
+}
+
+// Sweep evicts cases beyond retention limits once.
+func (j *Janitor) Sweep() {
+	defer handlePanic()
+	cases, err := listStoredCases()
+	if err != nil {
+		logger.Errorf("list bad cases error, err: %s", err)
+		return
+	}
+	// oldest first
+	sort.Slice(cases, func(a, b int) bool {
+		return cases[a].time.Before(cases[b].time)
+	})
+
+	evicted := make(map[string]struct{})
+	evict := func(c *storedCase, reason string) {
+		if _, ok := evicted[c.id]; ok {
+			return
+		}
+		for _, key := range c.keys {
+			if err := storage.Delete(key); err != nil {
+				logger.Errorf("evict bad case error, key: %s, err: %s", key, err)
+			}
+		}
+		if id := c.caseID(); id != "" {
+			if err := index.Delete(id); err != nil {
+				logger.Errorf("delete bad case from index error, id: %s, err: %s", id, err)
+			}
+		}
+		evicted[c.id] = struct{}{}
+		monitor.BadCaseEvictTotalCounterIncr(reason)
+	}
+
+	if j.opt.MaxAge > 0 {
+		deadline := time.Now().Add(-j.opt.MaxAge)
+		for _, c := range cases {
+			if c.time.Before(deadline) {
+				evict(c, EvictAge)
+			}
+		}
+	}
+
+	if j.opt.MaxCasesPerURL > 0 {
+		byURL := map[string][]*storedCase{}
+		for _, c := range cases {
+			if _, ok := evicted[c.id]; ok {
+				continue
+			}
+			if url, ok := j.url(c.caseID()); ok {
+				byURL[url] = append(byURL[url], c)
+			}
+		}
+		for _, cs := range byURL {
+			for i := 0; i < len(cs)-j.opt.MaxCasesPerURL; i++ {
+				evict(cs[i], EvictCasesOfURL)
+			}
+		}
+	}
+
+	var total int64
+	for _, c := range cases {
+		if _, ok := evicted[c.id]; !ok {
+			total += c.size
+		}
+	}
+	if j.opt.MaxSize > 0 {
+		for _, c := range cases {
+			if total <= j.opt.MaxSize {
+				break
+			}
+			if _, ok := evicted[c.id]; ok {
+				continue
+			}
+			evict(c, EvictSize)
+			total -= c.size
+		}
+	}
+
+	j.mu.Lock()
+	for _, c := range cases {
+		if _, ok := evicted[c.id]; ok {
+			delete(j.urls, c.caseID())
+		}
+	}
+	j.mu.Unlock()
+
+	monitor.BadCaseStoreSet(total, len(cases)-len(evicted))
+	if len(evicted) > 0 {
+		logger.Infof("bad case janitor evicted %d cases, %d cases left, size: %d", len(evicted), len(cases)-len(evicted), total)
+	}
+}
+
+// url returns host and url of the case, false if its meta is not written yet or not a case.
+func (j *Janitor) url(id string) (string, bool) {
+	if id == "" {
+		return "", false
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if url, ok := j.urls[id]; ok {
+		return url, true
+	}
+	data, ok := storage.Read(caseKey(id, caseKeyMeta))
+	if !ok {
+		return "", false
+	}
+	c := &Case{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return "", false
+	}
+	url := c.Host + c.URL
+	j.urls[id] = url
+	return url, true
+}
+
+// listStoredCases groups stored keys by case, keys not in case layout are cases by themselves.
+func listStoredCases() ([]*storedCase, error) {
+	byID := map[string]*storedCase{}
+	err := storage.Walk(func(key string, size int64, modTime time.Time) error {
+		id := key
+		t := modTime
+		// [archive/]<date>/<case id>/<name>
+		parts := strings.Split(key, "/")
+		if n := len(parts); n >= 3 && strings.HasPrefix(parts[n-2], parts[n-3]) {
+			id = strings.Join(parts[:n-1], "/")
+			if ct, ok := caseTime(parts[n-2]); ok {
+				t = ct
+			}
+		}
+		c, ok := byID[id]
+		if !ok {
+			c = &storedCase{id: id, time: t}
+			byID[id] = c
+		}
+		c.size += size
+		c.keys = append(c.keys, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	cases := make([]*storedCase, 0, len(byID))
+	for _, c := range byID {
+		cases = append(cases, c)
+	}
+	return cases, nil
+}
+
+// caseTime parses time from the case id.
+func caseTime(id string) (time.Time, bool) {
+	i := strings.IndexByte(id, '-')
+	if i < 0 {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(caseTimeLayout, id[:i])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
@@ -0,0 +1,244 @@
+package validator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+	"github.com/bocchi-the-cache/inspector/pkg/index"
+	"github.com/bocchi-the-cache/inspector/pkg/storage"
+)
+
+// memStorage keeps keys in memory, with the time they are written.
+type memStorage struct {
+	mu   sync.Mutex
+	data map[string][]byte
+	mod  map[string]time.Time
+}
+
+func (m *memStorage) Write(key string, content []byte) error {
+	return m.writeAt(key, content, time.Now())
+}
+
+func (m *memStorage) writeAt(key string, content []byte, t time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data[key] = append([]byte{}, content...)
+	m.mod[key] = t
+	return nil
+}
+
+func (m *memStorage) Read(key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.data[key]
+	return data, ok
+}
+
+func (m *memStorage) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.data, key)
+	delete(m.mod, key)
+	return nil
+}
+
+func (m *memStorage) Walk(fn storage.WalkFunc) error {
+	m.mu.Lock()
+	keys := make([]string, 0, len(m.data))
+	for k := range m.data {
+		keys = append(keys, k)
+	}
+	m.mu.Unlock()
+	sort.Strings(keys)
+	for _, k := range keys {
+		data, ok := m.Read(k)
+		if !ok {
+			continue
+		}
+		m.mu.Lock()
+		t := m.mod[k]
+		m.mu.Unlock()
+		if err := fn(k, int64(len(data)), t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setStorage stores bad cases in memory during the test.
+func setStorage(t *testing.T) *memStorage {
+	m := &memStorage{data: map[string][]byte{}, mod: map[string]time.Time{}}
+	old := storage.DefaultStorage
+	storage.DefaultStorage = m
+	t.Cleanup(func() { storage.DefaultStorage = old })
+	return m
+}
+
+// setIndex indexes bad cases in a temporary index during the test.
+func setIndex(t *testing.T) *index.Index {
+	idx, err := index.Open(filepath.Join(t.TempDir(), "index.db"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := index.DefaultIndex
+	index.DefaultIndex = idx
+	t.Cleanup(func() {
+		index.DefaultIndex = old
+		idx.Close()
+	})
+	return idx
+}
+
+func TestJanitorSweep(t *testing.T) {
+	type stored struct {
+		age time.Duration
+		// url is empty for keys not in case layout
+		url      string
+		archived bool
+		noMeta   bool
+	}
+	tests := []struct {
+		name   string
+		opt    RetentionOptions
+		stored []stored
+		// maxSizeCases sets MaxSize to the size of so many cases
+		maxSizeCases int
+		wantKept     []int
+	}{
+		{"no limits", RetentionOptions{},
+			[]stored{{age: time.Minute, url: "/a"}, {age: 48 * time.Hour, url: "/a"}}, 0, []int{0, 1}},
+		{"max age", RetentionOptions{MaxAge: time.Hour},
+			[]stored{{age: 30 * time.Minute, url: "/a"}, {age: 2 * time.Hour, url: "/b"}, {age: 3 * time.Hour, url: "/a", archived: true}, {age: 2 * time.Hour}},
+			0, []int{0}},
+		{"max cases per url keeps newest", RetentionOptions{MaxCasesPerURL: 2},
+			[]stored{{age: 3 * time.Minute, url: "/a"}, {age: time.Minute, url: "/a"}, {age: 2 * time.Minute, url: "/a"}, {age: 5 * time.Minute, url: "/b"}},
+			0, []int{1, 2, 3}},
+		{"max cases per url skips cases without meta and archived", RetentionOptions{MaxCasesPerURL: 1},
+			[]stored{{age: 3 * time.Minute, url: "/a", noMeta: true}, {age: 2 * time.Minute, url: "/a", archived: true}, {age: time.Minute, url: "/a"}},
+			0, []int{0, 1, 2}},
+		{"max size evicts oldest", RetentionOptions{},
+			[]stored{{age: time.Minute, url: "/a"}, {age: 4 * time.Minute, url: "/b"}, {age: 2 * time.Minute, url: "/c"}, {age: 3 * time.Minute, url: "/d"}},
+			2, []int{0, 2}},
+		{"max size counts archived cases and other keys", RetentionOptions{},
+			[]stored{{age: time.Minute, url: "/a"}, {age: 2 * time.Minute}, {age: 3 * time.Minute, url: "/b", archived: true}},
+			1, []int{0}},
+		{"limits in order", RetentionOptions{MaxAge: time.Hour, MaxCasesPerURL: 1},
+			[]stored{{age: 2 * time.Hour, url: "/a"}, {age: 3 * time.Minute, url: "/b"}, {age: 2 * time.Minute, url: "/b"}, {age: 5 * time.Minute, url: "/c"}, {age: time.Minute, url: "/d"}},
+			2, []int{2, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := setStorage(t)
+			idx := setIndex(t)
+			now := time.Now()
+			var caseSize int64
+			keys := make([][]string, len(tt.stored))
+			ids := make([]string, len(tt.stored))
+			for i, s := range tt.stored {
+				ct := now.Add(-s.age)
+				if s.url == "" {
+					keys[i] = []string{fmt.Sprintf("other/key%d", i)}
+					continue
+				}
+				r := &client.Request{Method: http.MethodGet, Host: "a.com", URL: s.url}
+				ids[i] = newCaseID(ct, r, "test")
+				prefix := ""
+				if s.archived {
+					prefix = archivePrefix
+				}
+				if !s.noMeta {
+					meta, _ := json.Marshal(&Case{ID: ids[i], Host: r.Host, URL: r.URL})
+					keys[i] = append(keys[i], prefix+caseKey(ids[i], caseKeyMeta))
+					_ = m.writeAt(keys[i][0], meta, ct)
+					caseSize = int64(len(meta)) + 1000
+				}
+				keys[i] = append(keys[i], prefix+caseKey(ids[i], caseKeyBaseline))
+				_ = m.writeAt(keys[i][len(keys[i])-1], make([]byte, 1000), ct)
+				if !s.archived {
+					_ = idx.Add(&index.Entry{ID: ids[i], Time: ct, Host: r.Host, Path: s.url})
+				}
+			}
+			// other keys are as large as cases, and their time is when they are written
+			for i, s := range tt.stored {
+				if s.url == "" {
+					_ = m.writeAt(keys[i][0], make([]byte, caseSize), now.Add(-s.age))
+				}
+			}
+
+			opt := tt.opt
+			opt.MaxSize = int64(tt.maxSizeCases) * caseSize
+			NewJanitor(opt).Sweep()
+
+			var kept []int
+			for i := range tt.stored {
+				n := 0
+				for _, k := range keys[i] {
+					if _, ok := m.Read(k); ok {
+						n++
+					}
+				}
+				if n != 0 && n != len(keys[i]) {
+					t.Fatalf("case %d: %d of %d keys are evicted", i, len(keys[i])-n, len(keys[i]))
+				}
+				if n > 0 {
+					kept = append(kept, i)
+				}
+			}
+			if !reflect.DeepEqual(kept, tt.wantKept) {
+				t.Fatalf("Sweep: got kept %v, want %v", kept, tt.wantKept)
+			}
+
+			// evicted cases are removed from index
+			res, err := idx.Search(index.Query{Limit: index.MaxLimit})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var indexed []string
+			for _, e := range res.Cases {
+				indexed = append(indexed, e.ID)
+			}
+			var want []string
+			for _, i := range kept {
+				if tt.stored[i].url != "" && !tt.stored[i].archived {
+					want = append(want, ids[i])
+				}
+			}
+			sort.Strings(indexed)
+			sort.Strings(want)
+			if strings.Join(indexed, ",") != strings.Join(want, ",") {
+				t.Fatalf("index: got %v, want %v", indexed, want)
+			}
+		})
+	}
+}
+
+func TestCaseTime(t *testing.T) {
+	ct := time.Date(2026, 10, 18, 9, 21, 6, 123e6, time.UTC)
+	id := newCaseID(ct, &client.Request{Host: "a.com", URL: "/a"}, "test")
+	if got, ok := caseTime(id); !ok || !got.Equal(ct) {
+		t.Fatalf("caseTime(%s): got %s, %v, want %s", id, got, ok, ct)
+	}
+	for _, id := range []string{"", "key", "20261018-x"} {
+		if _, ok := caseTime(id); ok {
+			t.Fatalf("caseTime(%s): got ok", id)
+		}
+	}
+	c := &storedCase{id: id[:8] + "/" + id}
+	if c.caseID() != id {
+		t.Fatalf("caseID: got %q, want %s", c.caseID(), id)
+	}
+	for _, sid := range []string{archivePrefix + id[:8] + "/" + id, "other/key"} {
+		if got := (&storedCase{id: sid}).caseID(); got != "" {
+			t.Fatalf("caseID of %s: got %q, want none", sid, got)
+		}
+	}
+}
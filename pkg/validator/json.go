@@ -0,0 +1,278 @@
+package validator
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"mime"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+)
+
+// max differing paths reported per result
+const maxJSONDiffPaths = 20
+
+var errJSONTrailingData = errors.New("invalid json: data after top-level value")
+
+type JSONOptions struct {
+	Enable bool
+	Masks  []JSONMask
+}
+
+// JSONMask ignores Paths for requests whose path matches URL regex.
+// Paths are JSON pointers (/data/0/id) or JSONPath ($.data[0].id, $.data[*].id)
+type JSONMask struct {
+	URL   string   `mapstructure:"url"`
+	Paths []string `mapstructure:"paths"`
+}
+
+type jsonMask struct {
+	url   *regexp.Regexp
+	paths [][]string
+}
+
+type jsonComparator struct {
+	masks []jsonMask
+}
+
+// newJSONComparator builds the comparator even if disabled, comparator chains may still select "json".
+func newJSONComparator(opt JSONOptions) (*jsonComparator, error) {
+	c := &jsonComparator{}
+	for _, m := range opt.Masks {
+		re, err := regexp.Compile(m.URL)
+		if err != nil {
+			return nil, err
+		}
+		mask := jsonMask{url: re}
+		for _, p := range m.Paths {
+			tokens, err := parseJSONPath(p)
+			if err != nil {
+				return nil, err
+			}
+			mask.paths = append(mask.paths, tokens)
+		}
+		c.masks = append(c.masks, mask)
+	}
+	return c, nil
+}
+
+// parseJSONPath parses JSON pointer or simple JSONPath into tokens, "*" matches any key or index.
+func parseJSONPath(p string) ([]string, error) {
+	switch {
+	case p == "" || p == "$":
+		return []string{}, nil
+	case strings.HasPrefix(p, "/"):
+		tokens := strings.Split(p[1:], "/")
+		for i, t := range tokens {
+			tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+		}
+		return tokens, nil
+	case strings.HasPrefix(p, "$"):
+		var tokens []string
+		rest := p[1:]
+		for rest != "" {
+			switch {
+			case strings.HasPrefix(rest, "."):
+				rest = rest[1:]
+				end := strings.IndexAny(rest, ".[")
+				if end < 0 {
+					end = len(rest)
+				}
+				if end == 0 {
+					return nil, fmt.Errorf("invalid json path: %s", p)
+				}
+				tokens = append(tokens, rest[:end])
+				rest = rest[end:]
+			case strings.HasPrefix(rest, "["):
+				end := strings.Index(rest, "]")
+				if end < 0 {
+					return nil, fmt.Errorf("invalid json path: %s", p)
+				}
+				tokens = append(tokens, strings.Trim(rest[1:end], `'"`))
+				rest = rest[end+1:]
+			default:
+				return nil, fmt.Errorf("invalid json path: %s", p)
+			}
+		}
+		return tokens, nil
+	}
+	return nil, fmt.Errorf("invalid json path: %s", p)
+}
+
+func isJSONContent(c *client.Content) bool {
+	mediaType, _, err := mime.ParseMediaType(c.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// Compare compares json bodies structurally, ok is false if either body is not json.
+func (c *jsonComparator) Compare(r *client.Request, b *client.Content, t *client.Content) (Verdict, bool) {
+	if b.Truncated || t.Truncated || !isJSONContent(b) || !isJSONContent(t) {
+		return Verdict{}, false
+	}
+	bv, err := decodeJSON(b.Content)
+	if err != nil {
+		return Verdict{}, false
+	}
+	tv, err := decodeJSON(t.Content)
+	if err != nil {
+		return Verdict{}, false
+	}
+
+	var masks [][]string
+	path := r.Path()
+	for _, m := range c.masks {
+		if m.url.MatchString(path) {
+			masks = append(masks, m.paths...)
+		}
+	}
+	var paths []string
+	diffJSON(nil, bv, tv, masks, &paths)
+	if len(paths) > 0 {
+		return Verdict{State: StateContentNotMatch, Offset: -1, Reason: fmt.Sprintf("%d json paths differ", len(paths)), Paths: paths}, true
+	}
+	return Verdict{State: StatePass, Offset: -1}, true
+}
+
+func decodeJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	// keep numbers as they are, eg: 1.0 and 1 are the same, but 1e400 doesn't overflow
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errJSONTrailingData
+	}
+	return v, nil
+}
+
+// diffJSON collects differing paths as JSON pointers, masked paths are skipped.
+func diffJSON(path []string, b, t interface{}, masks [][]string, paths *[]string) {
+	if len(*paths) >= maxJSONDiffPaths || masked(path, masks) {
+		return
+	}
+	switch bv := b.(type) {
+	case map[string]interface{}:
+		tv, ok := t.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(bv)+len(tv))
+		for k := range bv {
+			keys = append(keys, k)
+		}
+		for k := range tv {
+			if _, ok := bv[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			bk, bok := bv[k]
+			tk, tok := tv[k]
+			p := append(path[:len(path):len(path)], k)
+			if bok != tok {
+				if !masked(p, masks) && len(*paths) < maxJSONDiffPaths {
+					*paths = append(*paths, jsonPointer(p))
+				}
+				continue
+			}
+			diffJSON(p, bk, tk, masks, paths)
+		}
+		return
+	case []interface{}:
+		tv, ok := t.([]interface{})
+		if !ok {
+			break
+		}
+		n := len(bv)
+		if len(tv) > n {
+			n = len(tv)
+		}
+		for i := 0; i < n; i++ {
+			p := append(path[:len(path):len(path)], strconv.Itoa(i))
+			if i >= len(bv) || i >= len(tv) {
+				if !masked(p, masks) && len(*paths) < maxJSONDiffPaths {
+					*paths = append(*paths, jsonPointer(p))
+				}
+				continue
+			}
+			diffJSON(p, bv[i], tv[i], masks, paths)
+		}
+		return
+	case json.Number:
+		if tv, ok := t.(json.Number); ok && sameNumber(bv, tv) {
+			return
+		}
+	default:
+		if b == t {
+			return
+		}
+	}
+	*paths = append(*paths, jsonPointer(path))
+}
+
+// sameNumber compares integers exactly, float64 can't tell integers above 2^53 apart.
+// Numbers with fractions or exponents are compared as float64.
+func sameNumber(a, b json.Number) bool {
+	if a == b {
+		return true
+	}
+	if isJSONInteger(a) && isJSONInteger(b) {
+		if ai, err := a.Int64(); err == nil {
+			bi, err := b.Int64()
+			return err == nil && ai == bi
+		}
+		ai, okA := new(big.Int).SetString(string(a), 10)
+		bi, okB := new(big.Int).SetString(string(b), 10)
+		return okA && okB && ai.Cmp(bi) == 0
+	}
+	af, errA := a.Float64()
+	bf, errB := b.Float64()
+	return errA == nil && errB == nil && af == bf
+}
+
+func isJSONInteger(n json.Number) bool {
+	return !strings.ContainsAny(string(n), ".eE")
+}
+
+func masked(path []string, masks [][]string) bool {
+	for _, m := range masks {
+		if len(m) > len(path) {
+			continue
+		}
+		match := true
+		for i, token := range m {
+			if token != "*" && token != path[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonPointer(path []string) string {
+	if len(path) == 0 {
+		return "/"
+	}
+	escaped := make([]string, len(path))
+	for i, p := range path {
+		escaped[i] = strings.NewReplacer("~", "~0", "/", "~1").Replace(p)
+	}
+	return "/" + strings.Join(escaped, "/")
+}
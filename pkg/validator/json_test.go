@@ -0,0 +1,159 @@
+package validator
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+)
+
+func TestSameNumber(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"1", "1", true},
+		{"1", "2", false},
+		{"0", "-0", true},
+		{"1", "1.0", true},
+		{"1.5", "1.50", true},
+		{"100", "1e2", true},
+		{"1E2", "1e2", true},
+		{"0.1", "0.2", false},
+		// above 2^53, float64 can't tell them apart
+		{"9007199254740993", "9007199254740992", false},
+		{"9007199254740993", "9007199254740993", true},
+		{"-9223372036854775808", "-9223372036854775808", true},
+		// above int64
+		{"18446744073709551616", "18446744073709551617", false},
+		{"018446744073709551617", "18446744073709551617", true},
+		{"9223372036854775807", "9223372036854775808", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+"_"+tt.b, func(t *testing.T) {
+			if got := sameNumber(json.Number(tt.a), json.Number(tt.b)); got != tt.want {
+				t.Fatalf("sameNumber(%s, %s): got %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+			if got := sameNumber(json.Number(tt.b), json.Number(tt.a)); got != tt.want {
+				t.Fatalf("sameNumber(%s, %s): got %v, want %v", tt.b, tt.a, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{"object", `{"a":1}`, false},
+		{"surrounding spaces", " [1,2]\n", false},
+		{"scalar", `"a"`, false},
+		{"large number", `1e400`, false},
+		{"empty", ``, true},
+		{"invalid", `{"a":}`, true},
+		{"trailing value", `{"a":1}{"a":2}`, true},
+		{"trailing garbage", `{"a":1} x`, true},
+		{"trailing bracket", `[1]]`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeJSON([]byte(tt.data)); (err != nil) != tt.wantErr {
+				t.Fatalf("decodeJSON(%q): got error %v, want error %v", tt.data, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseJSONPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    []string
+		wantErr bool
+	}{
+		{"", []string{}, false},
+		{"$", []string{}, false},
+		{"/data/0/id", []string{"data", "0", "id"}, false},
+		{"/a~1b/c~0d", []string{"a/b", "c~d"}, false},
+		{"$.data[0].id", []string{"data", "0", "id"}, false},
+		{"$.data[*].id", []string{"data", "*", "id"}, false},
+		{"$['a.b'].c", []string{"a.b", "c"}, false},
+		{"data.id", nil, true},
+		{"$.", nil, true},
+		{"$.a[0", nil, true},
+		{"$a", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := parseJSONPath(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseJSONPath(%q): got error %v, want error %v", tt.path, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parseJSONPath(%q): got %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONComparator(t *testing.T) {
+	c, err := newJSONComparator(JSONOptions{Masks: []JSONMask{
+		{URL: "^/api/", Paths: []string{"/ts", "$.items[*].id"}},
+		{URL: "^/api/user$", Paths: []string{"/session"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonHeader := http.Header{"Content-Type": []string{"application/json; charset=utf-8"}}
+
+	tests := []struct {
+		name      string
+		url       string
+		b, t      string
+		header    http.Header
+		wantOK    bool
+		wantState string
+		wantPaths []string
+	}{
+		{"same", "/x", `{"a":1,"b":[1,2]}`, `{"b":[1,2],"a":1}`, jsonHeader, true, StatePass, nil},
+		{"same numbers", "/x", `{"a":1.0}`, `{"a":1}`, jsonHeader, true, StatePass, nil},
+		{"value differs", "/x", `{"a":1,"b":"x"}`, `{"a":1,"b":"y"}`, jsonHeader, true, StateContentNotMatch, []string{"/b"}},
+		{"key added and removed", "/x", `{"a":1}`, `{"b":1}`, jsonHeader, true, StateContentNotMatch, []string{"/a", "/b"}},
+		{"array length", "/x", `[1,2]`, `[1,2,3]`, jsonHeader, true, StateContentNotMatch, []string{"/2"}},
+		{"type differs", "/x", `{"a":{"b":1}}`, `{"a":[1]}`, jsonHeader, true, StateContentNotMatch, []string{"/a"}},
+		{"escaped key", "/x", `{"a/b":1}`, `{"a/b":2}`, jsonHeader, true, StateContentNotMatch, []string{"/a~1b"}},
+		{"masked", "/api/list", `{"ts":1,"items":[{"id":1,"v":1}]}`, `{"ts":2,"items":[{"id":2,"v":1}]}`,
+			jsonHeader, true, StatePass, nil},
+		{"masked key removed", "/api/list", `{"ts":1}`, `{}`, jsonHeader, true, StatePass, nil},
+		{"not masked by other url", "/x", `{"ts":1}`, `{"ts":2}`, jsonHeader, true, StateContentNotMatch, []string{"/ts"}},
+		{"masks of all matching urls", "/api/user", `{"ts":1,"session":"a","v":1}`, `{"ts":2,"session":"b","v":2}`,
+			jsonHeader, true, StateContentNotMatch, []string{"/v"}},
+		{"masked path is not a prefix of siblings", "/api/list", `{"items":[{"id":1,"v":1}]}`, `{"items":[{"id":1,"v":2}]}`,
+			jsonHeader, true, StateContentNotMatch, []string{"/items/0/v"}},
+		{"not json content", "/x", `{"a":1}`, `{"a":2}`, http.Header{"Content-Type": []string{"text/plain"}}, false, "", nil},
+		{"json suffix content", "/x", `{"a":1}`, `{"a":2}`, http.Header{"Content-Type": []string{"application/problem+json"}},
+			true, StateContentNotMatch, []string{"/a"}},
+		{"invalid json", "/x", `{"a":1}`, `{"a":`, jsonHeader, false, "", nil},
+		{"trailing data", "/x", `{"a":1}`, `{"a":1}{}`, jsonHeader, false, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &client.Request{Method: http.MethodGet, URL: tt.url, Header: http.Header{}}
+			b := &client.Content{Status: http.StatusOK, Header: tt.header, Content: []byte(tt.b)}
+			tc := &client.Content{Status: http.StatusOK, Header: tt.header, Content: []byte(tt.t)}
+			verdict, ok := c.Compare(r, b, tc)
+			if ok != tt.wantOK {
+				t.Fatalf("Compare: got ok %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if verdict.State != tt.wantState || !reflect.DeepEqual(verdict.Paths, tt.wantPaths) {
+				t.Fatalf("Compare: got (%s, %v), want (%s, %v)", verdict.State, verdict.Paths, tt.wantState, tt.wantPaths)
+			}
+		})
+	}
+}
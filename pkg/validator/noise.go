@@ -0,0 +1,288 @@
+package validator
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+	"github.com/bocchi-the-cache/inspector/pkg/common/logger"
+	"github.com/bocchi-the-cache/inspector/pkg/monitor"
+)
+
+// StateNoise means test differs from baseline, but all differences are learned noise between baseline and secondary.
+const StateNoise = "NOISE"
+
+// max url patterns and urls whose noise is learned, new ones are not learned beyond it until old ones expire
+const (
+	maxNoisePatterns = 10000
+	maxNoiseURLs     = 100000
+)
+
+const (
+	defaultNoiseMinCount = 3
+	defaultNoiseTTL      = time.Hour
+)
+
+// NoiseOptions learns noise between baseline and secondary baseline, and suppresses it in test results.
+type NoiseOptions struct {
+	Enable bool
+	// Patterns are url path regexes grouping json and header noise, paths with ids replaced by {id} if none matches.
+	// Body noise of non-json contents is learned per url.
+	Patterns []string
+	// MinCount is how many times a difference is seen between baselines before it's suppressed.
+	MinCount int
+	// TTL is how long a difference is kept since it's last seen, its count restarts after it expires.
+	TTL time.Duration
+}
+
+// noiseCounter counts a difference seen between baselines, it expires ttl after last seen.
+type noiseCounter struct {
+	count int
+	last  time.Time
+}
+
+func (c *noiseCounter) see(now time.Time, ttl time.Duration) {
+	if now.Sub(c.last) > ttl {
+		c.count = 0
+	}
+	c.count++
+	c.last = now
+}
+
+// learned returns whether the difference is seen at least minCount times, and not expired.
+func (c *noiseCounter) learned(now time.Time, ttl time.Duration, minCount int) bool {
+	return c != nil && c.count >= minCount && now.Sub(c.last) <= ttl
+}
+
+// noise is learned json and header differences of one url pattern.
+type noise struct {
+	headers map[string]*noiseCounter
+	paths   map[string]*noiseCounter
+	last    time.Time
+}
+
+type noiseLearner struct {
+	patterns []*regexp.Regexp
+	minCount int
+	ttl      time.Duration
+
+	mu    sync.RWMutex
+	noise map[string]*noise
+	// body noise of non-json contents, by host and url
+	bodies map[string]*noiseCounter
+}
+
+func newNoiseLearner(opt NoiseOptions) (*noiseLearner, error) {
+	if !opt.Enable {
+		return nil, nil
+	}
+	n := &noiseLearner{
+		minCount: opt.MinCount,
+		ttl:      opt.TTL,
+		noise:    map[string]*noise{},
+		bodies:   map[string]*noiseCounter{},
+	}
+	if n.minCount <= 0 {
+		n.minCount = defaultNoiseMinCount
+	}
+	if n.ttl <= 0 {
+		n.ttl = defaultNoiseTTL
+	}
+	for _, p := range opt.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		n.patterns = append(n.patterns, re)
+	}
+	return n, nil
+}
+
+var (
+	uuidSegment = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	hexSegment  = regexp.MustCompile(`^[0-9a-fA-F]{16,}$`)
+)
+
+// pattern returns the first matching pattern of url path, or path with numeric, uuid and long hex segments replaced by {id}.
+func (n *noiseLearner) pattern(r *client.Request) string {
+	path := r.Path()
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	for _, re := range n.patterns {
+		if re.MatchString(path) {
+			return re.String()
+		}
+	}
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if _, err := strconv.ParseInt(s, 10, 64); err == nil || uuidSegment.MatchString(s) || hexSegment.MatchString(s) {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// urlKey is the key of body noise, the exact request url.
+func urlKey(r *client.Request) string {
+	return r.Host + r.URL
+}
+
+// expire removes expired noise, n.mu must be locked.
+func (n *noiseLearner) expire(now time.Time) {
+	for k, c := range n.bodies {
+		if now.Sub(c.last) > n.ttl {
+			delete(n.bodies, k)
+		}
+	}
+	for k, e := range n.noise {
+		if now.Sub(e.last) > n.ttl {
+			delete(n.noise, k)
+		}
+	}
+}
+
+// generalizePath replaces array indexes of json pointer by "*", so noise of one element applies to all.
+func generalizePath(p string) string {
+	tokens := strings.Split(p, "/")
+	for i, t := range tokens {
+		if _, err := strconv.Atoi(t); err == nil {
+			tokens[i] = "*"
+		}
+	}
+	return strings.Join(tokens, "/")
+}
+
+// learnNoise records differences between baseline and secondary contents as noise of the request's url pattern.
+// Bodies are compared only if status is 200/206, headers are compared as test results are checked.
+func (v *Validator) learnNoise(r *client.Request, b *client.Content, s *client.Content) {
+	if b.Status != s.Status || isEmptyContent(b) || isEmptyContent(s) {
+		return
+	}
+	vd := Verdict{State: StatePass, Offset: -1}
+	if b.Status == http.StatusOK || b.Status == http.StatusPartialContent {
+		vd = v.compare(r, b, s)
+	}
+	headerDiff := v.header.diff(b.Header, s.Header)
+	monitor.ResultTotalCounterIncr("NoiseLearn", client.SecondaryName, vd.State)
+	if vd.State == StatePass && len(headerDiff) == 0 {
+		return
+	}
+
+	n := v.noise
+	now := time.Now()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.bodies) >= maxNoiseURLs || len(n.noise) >= maxNoisePatterns {
+		n.expire(now)
+	}
+
+	if vd.State != StatePass && len(vd.Paths) == 0 {
+		key := urlKey(r)
+		c, ok := n.bodies[key]
+		if !ok && len(n.bodies) < maxNoiseURLs {
+			c = &noiseCounter{}
+			n.bodies[key] = c
+		}
+		if c != nil {
+			c.see(now, n.ttl)
+		}
+	}
+	if len(vd.Paths) == 0 && len(headerDiff) == 0 {
+		return
+	}
+
+	key := n.pattern(r)
+	e, ok := n.noise[key]
+	if !ok {
+		if len(n.noise) >= maxNoisePatterns {
+			return
+		}
+		e = &noise{headers: map[string]*noiseCounter{}, paths: map[string]*noiseCounter{}}
+		n.noise[key] = e
+		logger.Infof("learn noise of new url pattern: %s", key)
+	}
+	e.last = now
+	see := func(m map[string]*noiseCounter, k string) {
+		c, ok := m[k]
+		if !ok {
+			c = &noiseCounter{}
+			m[k] = c
+		}
+		c.see(now, n.ttl)
+	}
+	if vd.State != StatePass {
+		for _, p := range vd.Paths {
+			see(e.paths, generalizePath(p))
+		}
+	}
+	for _, h := range headerDiff {
+		see(e.headers, h)
+	}
+}
+
+// suppressBody returns whether body differences of the verdict are all learned noise.
+func (n *noiseLearner) suppressBody(r *client.Request, vd Verdict) bool {
+	if n == nil || vd.State != StateContentNotMatch {
+		return false
+	}
+	now := time.Now()
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if len(vd.Paths) == 0 {
+		return n.bodies[urlKey(r)].learned(now, n.ttl, n.minCount)
+	}
+	e, ok := n.noise[n.pattern(r)]
+	if !ok {
+		return false
+	}
+	if len(vd.Paths) >= maxJSONDiffPaths {
+		// more paths may differ than reported
+		return false
+	}
+	for _, p := range vd.Paths {
+		if !n.hasPath(e, generalizePath(p), now) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasPath checks whether the json pointer or one of its parents is learned noise.
+func (n *noiseLearner) hasPath(e *noise, p string, now time.Time) bool {
+	for {
+		if e.paths[p].learned(now, n.ttl, n.minCount) {
+			return true
+		}
+		i := strings.LastIndexByte(p, '/')
+		if i < 0 {
+			return false
+		}
+		p = p[:i]
+	}
+}
+
+// suppressHeaders removes headers which are learned noise, and returns the remaining ones.
+func (n *noiseLearner) suppressHeaders(r *client.Request, headerDiff []string) []string {
+	if n == nil || len(headerDiff) == 0 {
+		return headerDiff
+	}
+	now := time.Now()
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	e, ok := n.noise[n.pattern(r)]
+	if !ok {
+		return headerDiff
+	}
+	var remain []string
+	for _, h := range headerDiff {
+		if !e.headers[h].learned(now, n.ttl, n.minCount) {
+			remain = append(remain, h)
+		}
+	}
+	return remain
+}
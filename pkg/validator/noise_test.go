@@ -0,0 +1,174 @@
+package validator
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+)
+
+func TestNoiseCounter(t *testing.T) {
+	start := time.Now()
+	ttl := time.Minute
+	tests := []struct {
+		name string
+		// seen are offsets from start when the difference is seen
+		seen []time.Duration
+		at   time.Duration
+		want bool
+	}{
+		{"never seen", nil, 0, false},
+		{"below min count", []time.Duration{0, time.Second}, 2 * time.Second, false},
+		{"min count", []time.Duration{0, time.Second, 2 * time.Second}, 3 * time.Second, true},
+		{"kept within ttl since last seen", []time.Duration{0, 50 * time.Second, 100 * time.Second}, 150 * time.Second, true},
+		{"expired", []time.Duration{0, time.Second, 2 * time.Second}, 2*time.Second + ttl + time.Nanosecond, false},
+		{"count restarts after expired", []time.Duration{0, time.Second, 2 * time.Second, 3 * time.Minute, 3*time.Minute + time.Second}, 3*time.Minute + time.Second, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c *noiseCounter
+			for _, d := range tt.seen {
+				if c == nil {
+					c = &noiseCounter{}
+				}
+				c.see(start.Add(d), ttl)
+			}
+			if got := c.learned(start.Add(tt.at), ttl, 3); got != tt.want {
+				t.Fatalf("learned: got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNoisePattern(t *testing.T) {
+	n, err := newNoiseLearner(NoiseOptions{Enable: true, Patterns: []string{`^/api/v1/users/`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"/items/123", "/items/{id}"},
+		{"/items/123/detail?x=1", "/items/{id}/detail"},
+		{"/items/550e8400-e29b-41d4-a716-446655440000", "/items/{id}"},
+		{"/items/0123456789abcdef0123", "/items/{id}"},
+		{"/items/abc", "/items/abc"},
+		{"/items/beef", "/items/beef"},
+		{"/api/v1/users/1/profile", `^/api/v1/users/`},
+	}
+	for _, tt := range tests {
+		r := &client.Request{Method: http.MethodGet, Host: "a.com", URL: tt.url}
+		if got := n.pattern(r); got != tt.want {
+			t.Errorf("pattern(%s): got %s, want %s", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestNoiseLearner(t *testing.T) {
+	jsonHeader := http.Header{"Content-Type": {"application/json"}}
+	content := func(status int, header http.Header, body string) *client.Content {
+		return &client.Content{Status: status, Header: header, Content: []byte(body), Size: int64(len(body))}
+	}
+	request := func(url string) *client.Request {
+		return &client.Request{Method: http.MethodGet, Host: "a.com", URL: url, Header: http.Header{}}
+	}
+	tests := []struct {
+		name string
+		// differences between baseline and secondary are learned so many times
+		learn           *client.Request
+		baseline        *client.Content
+		secondary       *client.Content
+		times           int
+		expire          bool
+		check           *client.Request
+		test            *client.Content
+		wantState       string
+		wantHeaderDiffs []string
+	}{
+		{"body noise", request("/a.txt"),
+			content(200, http.Header{}, "a1"), content(200, http.Header{}, "a2"), 2, false,
+			request("/a.txt"), content(200, http.Header{}, "a3"), StateNoise, nil},
+		{"body noise below min count", request("/a.txt"),
+			content(200, http.Header{}, "a1"), content(200, http.Header{}, "a2"), 1, false,
+			request("/a.txt"), content(200, http.Header{}, "a3"), StateContentNotMatch, nil},
+		{"body noise is per url", request("/a.txt?v=1"),
+			content(200, http.Header{}, "a1"), content(200, http.Header{}, "a2"), 2, false,
+			request("/a.txt?v=2"), content(200, http.Header{}, "a3"), StateContentNotMatch, nil},
+		{"body noise expired", request("/a.txt"),
+			content(200, http.Header{}, "a1"), content(200, http.Header{}, "a2"), 2, true,
+			request("/a.txt"), content(200, http.Header{}, "a3"), StateContentNotMatch, nil},
+		{"json noise not covering new elements", request("/items/1"),
+			content(200, jsonHeader, `{"id":1,"list":[{"ts":1}]}`), content(200, jsonHeader, `{"id":1,"list":[{"ts":2}]}`), 2, false,
+			request("/items/2"), content(200, jsonHeader, `{"id":1,"list":[{"ts":3},{"ts":4}]}`), StateContentNotMatch, nil},
+		{"json noise of array elements", request("/items/1"),
+			content(200, jsonHeader, `{"id":1,"list":[{"ts":1},{"ts":1}]}`), content(200, jsonHeader, `{"id":1,"list":[{"ts":2},{"ts":2}]}`), 2, false,
+			request("/items/2"), content(200, jsonHeader, `{"id":1,"list":[{"ts":3},{"ts":4}]}`), StateNoise, nil},
+		{"json noise other paths", request("/items/1"),
+			content(200, jsonHeader, `{"id":1,"ts":1}`), content(200, jsonHeader, `{"id":1,"ts":2}`), 2, false,
+			request("/items/2"), content(200, jsonHeader, `{"id":2,"ts":3}`), StateContentNotMatch, nil},
+		{"header noise of url pattern", request("/items/1"),
+			content(200, http.Header{"X-Trace": {"1"}}, "a"), content(200, http.Header{"X-Trace": {"2"}}, "a"), 2, false,
+			request("/items/2"), content(200, http.Header{"X-Trace": {"3"}}, "a"), StateNoise, nil},
+		{"header noise other headers", request("/items/1"),
+			content(200, http.Header{"X-Trace": {"1"}}, "a"), content(200, http.Header{"X-Trace": {"2"}}, "a"), 2, false,
+			request("/items/2"), content(200, http.Header{"X-Trace": {"3"}, "Etag": {"x"}}, "a"), StateHeaderNotMatch, []string{"Etag"}},
+		{"header noise of redirects", request("/go/1"),
+			content(302, http.Header{"Location": {"/a?t=1"}}, ""), content(302, http.Header{"Location": {"/a?t=2"}}, ""), 2, false,
+			request("/go/2"), content(302, http.Header{"Location": {"/a?t=3"}}, ""), StateStatusSkip, nil},
+		{"different statuses not learned", request("/a.txt"),
+			content(200, http.Header{}, "a1"), content(404, http.Header{}, "a2"), 2, false,
+			request("/a.txt"), content(200, http.Header{}, "a3"), StateContentNotMatch, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewValidator(Options{
+				JSON:   JSONOptions{Enable: true},
+				Header: HeaderOptions{Enable: true},
+				Noise:  NoiseOptions{Enable: true, MinCount: 2, TTL: time.Minute},
+			})
+			for i := 0; i < tt.times; i++ {
+				v.learnNoise(tt.learn, tt.baseline, tt.secondary)
+			}
+			if tt.expire {
+				v.noise.mu.Lock()
+				for _, c := range v.noise.bodies {
+					c.last = c.last.Add(-2 * time.Minute)
+				}
+				v.noise.mu.Unlock()
+			}
+			res := v.check(tt.check, nil, tt.baseline, nil, tt.test, nil)
+			if res.state != tt.wantState || !reflect.DeepEqual(res.headerDiff, tt.wantHeaderDiffs) {
+				t.Fatalf("check: got %s %v, want %s %v", res.state, res.headerDiff, tt.wantState, tt.wantHeaderDiffs)
+			}
+		})
+	}
+}
+
+func TestNoiseLearnerLimits(t *testing.T) {
+	n, err := newNoiseLearner(NoiseOptions{Enable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.minCount != defaultNoiseMinCount || n.ttl != defaultNoiseTTL {
+		t.Fatalf("defaults: got min count %d, ttl %s", n.minCount, n.ttl)
+	}
+	now := time.Now()
+	n.bodies["fresh"] = &noiseCounter{count: 1, last: now}
+	n.bodies["old"] = &noiseCounter{count: 1, last: now.Add(-2 * defaultNoiseTTL)}
+	n.noise["fresh"] = &noise{last: now}
+	n.noise["old"] = &noise{last: now.Add(-2 * defaultNoiseTTL)}
+	n.expire(now)
+	if _, ok := n.bodies["old"]; ok || len(n.bodies) != 1 {
+		t.Fatalf("expire bodies: got %d left", len(n.bodies))
+	}
+	if _, ok := n.noise["old"]; ok || len(n.noise) != 1 {
+		t.Fatalf("expire patterns: got %d left", len(n.noise))
+	}
+
+	if _, err = newNoiseLearner(NoiseOptions{Enable: true, Patterns: []string{"("}}); err == nil {
+		t.Fatal("newNoiseLearner with invalid pattern: got no error")
+	}
+}
@@ -0,0 +1,135 @@
+package validator
+
+import (
+	"math/rand"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+	"github.com/bocchi-the-cache/inspector/pkg/common/logger"
+	"github.com/bocchi-the-cache/inspector/pkg/monitor"
+)
+
+const (
+	// PolicyDrop drops the request when queue is full.
+	PolicyDrop = "drop"
+	// PolicyBlock blocks the caller until queue has room.
+	PolicyBlock = "block"
+	// PolicySample blocks for a sample_rate fraction of requests, and drops the others.
+	PolicySample = "sample"
+
+	defaultWorkers     = 64
+	defaultQueueSize   = 1024
+	defaultMaxBodySize = 10 * 1024 * 1024 // 10MB
+)
+
+type task struct {
+	r *client.Request
+
+	// baseline content fetched by proxy mode
+	withBaseline bool
+	baseline     *client.Content
+	errBaseline  error
+
+	// id in spool, 0 means not spooled
+	spoolID uint64
+}
+
+// Start launches a fixed number of validate workers.
+func (v *Validator) Start() {
+	logger.Infof("validator start, workers: %d, queue size: %d, queue full policy: %s",
+		v.workers, cap(v.queue), v.policy)
+	for i := 0; i < v.workers; i++ {
+		go v.work()
+	}
+	if len(v.recovered) > 0 {
+		go v.recover()
+	}
+}
+
+// recover validates requests left in spool by the last run, snapshotted before any request of this run is spooled.
+func (v *Validator) recover() {
+	entries := v.recovered
+	v.recovered = nil
+	logger.Infof("recover %d requests from spool", len(entries))
+	for _, e := range entries {
+		// never drop recovered requests
+		v.queue <- &task{r: e.Request, spoolID: e.ID}
+		monitor.ValidateQueueDepthSet(len(v.queue))
+	}
+	logger.Infof("all requests in spool recovered")
+}
+
+func (v *Validator) work() {
+	for t := range v.queue {
+		monitor.ValidateQueueDepthSet(len(v.queue))
+		monitor.ValidateBusyWorkersIncr()
+		if t.withBaseline {
+			v.ValidateWithBaseline(t.r, t.baseline, t.errBaseline)
+		} else {
+			v.Validate(t.r)
+		}
+		v.spoolDone(t)
+		monitor.ValidateBusyWorkersDecr()
+	}
+}
+
+// enqueue pushes t into validate queue, policy decides what to do when the queue is full.
+// It returns false if t is dropped. Only accepted requests are spooled.
+func (v *Validator) enqueue(t *task, policy string) bool {
+	full := len(v.queue) >= cap(v.queue)
+	if full && !v.admit(t, policy) {
+		return false
+	}
+	// spooled before queued, a worker may take it and mark it done at once
+	v.spoolAdd(t)
+	select {
+	case v.queue <- t:
+	default:
+		// filled up by others since checking
+		if !full && !v.admit(t, policy) {
+			v.spoolDone(t)
+			return false
+		}
+		v.queue <- t
+	}
+	monitor.ValidateQueueDepthSet(len(v.queue))
+	return true
+}
+
+// admit decides by policy whether t waits for room of the full queue, dropped ones are counted.
+func (v *Validator) admit(t *task, policy string) bool {
+	switch policy {
+	case PolicyBlock:
+		return true
+	case PolicySample:
+		if rand.Float64() < v.sampleRate {
+			return true
+		}
+		monitor.RequestDropTotalCounterIncr(t.r.Method, "QueueFullSampledOut")
+	default:
+		monitor.RequestDropTotalCounterIncr(t.r.Method, "QueueFull")
+	}
+	return false
+}
+
+func (v *Validator) spoolAdd(t *task) {
+	if v.spool == nil {
+		return
+	}
+	id, err := v.spool.Add(t.r)
+	if err != nil {
+		logger.Errorf("add request to spool error, err: %s", err)
+		monitor.ErrorTotalCounterIncr("Spool", "add", "errSpoolAdd")
+		return
+	}
+	t.spoolID = id
+}
+
+func (v *Validator) spoolDone(t *task) {
+	if v.spool == nil || t.spoolID == 0 {
+		return
+	}
+	if err := v.spool.Done(t.spoolID); err != nil {
+		logger.Errorf("mark request done in spool error, err: %s", err)
+		monitor.ErrorTotalCounterIncr("Spool", "done", "errSpoolDone")
+	}
+}
@@ -0,0 +1,185 @@
+package validator
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+	"github.com/bocchi-the-cache/inspector/pkg/spool"
+)
+
+func newTask(url string) *task {
+	return &task{r: &client.Request{Method: http.MethodGet, Host: "a.com", URL: url, Header: http.Header{}}}
+}
+
+// waitEnqueue waits a while for the result of enqueue, returned is false if it's still blocked.
+func waitEnqueue(done <-chan bool) (ok bool, returned bool) {
+	select {
+	case ok = <-done:
+		return ok, true
+	case <-time.After(50 * time.Millisecond):
+		return false, false
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     string
+		sampleRate float64
+		// a full queue blocks, or drops
+		wantBlock bool
+	}{
+		{"drop", PolicyDrop, 0, false},
+		{"block", PolicyBlock, 0, true},
+		{"sample none", PolicySample, 0, false},
+		{"sample all", PolicySample, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewValidator(Options{QueueSize: 2, QueueFullPolicy: tt.policy, SampleRate: tt.sampleRate})
+			for _, u := range []string{"/1", "/2"} {
+				if !v.enqueue(newTask(u), v.policy) {
+					t.Fatalf("enqueue %s: dropped before the queue is full", u)
+				}
+			}
+
+			done := make(chan bool, 1)
+			go func() { done <- v.enqueue(newTask("/3"), v.policy) }()
+			ok, returned := waitEnqueue(done)
+			if !tt.wantBlock {
+				if !returned {
+					t.Fatal("enqueue on a full queue: blocked, want dropped")
+				}
+				if ok {
+					t.Fatal("enqueue on a full queue: got true, want dropped")
+				}
+				if len(v.queue) != 2 {
+					t.Fatalf("queue depth: got %d, want 2", len(v.queue))
+				}
+				return
+			}
+			if returned {
+				t.Fatal("enqueue on a full queue: returned, want blocked")
+			}
+			// a worker takes one, so the blocked one gets in
+			if got := (<-v.queue).r.URL; got != "/1" {
+				t.Fatalf("dequeue: got %s, want /1", got)
+			}
+			if !<-done {
+				t.Fatal("enqueue after room: got false, want true")
+			}
+			for _, want := range []string{"/2", "/3"} {
+				if got := (<-v.queue).r.URL; got != want {
+					t.Fatalf("dequeue: got %s, want %s", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestProxyRequestNeverBlocks(t *testing.T) {
+	baseline := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer baseline.Close()
+	setBaseline(t, baseline)
+
+	for _, policy := range []string{PolicyBlock, PolicySample} {
+		t.Run(policy, func(t *testing.T) {
+			v := NewValidator(Options{QueueSize: 1, QueueFullPolicy: policy, SampleRate: 1})
+			v.enqueue(newTask("/full"), v.policy)
+
+			done := make(chan bool, 1)
+			w := httptest.NewRecorder()
+			go func() { done <- v.ProxyRequest(w, httptest.NewRequest(http.MethodGet, "/a", nil)) == nil }()
+			select {
+			case ok := <-done:
+				if !ok || w.Body.String() != "ok" {
+					t.Fatalf("ProxyRequest: got %q", w.Body.String())
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("ProxyRequest: blocked on a full queue")
+			}
+			if got := (<-v.queue).r.URL; got != "/full" || len(v.queue) != 0 {
+				t.Fatalf("queue: got %s and %d more, want only /full", got, len(v.queue))
+			}
+		})
+	}
+}
+
+func TestPushWaitsOnFullQueue(t *testing.T) {
+	v := NewValidator(Options{QueueSize: 1, QueueFullPolicy: PolicyDrop})
+	if !v.Push(newTask("/1").r) {
+		t.Fatal("Push /1: got false")
+	}
+	done := make(chan bool, 1)
+	go func() { done <- v.Push(newTask("/2").r) }()
+	if _, returned := waitEnqueue(done); returned {
+		t.Fatal("Push on a full queue: returned, want waiting")
+	}
+	<-v.queue
+	if !<-done {
+		t.Fatal("Push after room: got false, want true")
+	}
+	if got := (<-v.queue).r.URL; got != "/2" {
+		t.Fatalf("dequeue: got %s, want /2", got)
+	}
+
+	// filtered out requests are not pushed
+	if v.Push(&client.Request{Method: http.MethodPost, URL: "/3", Header: http.Header{}}) {
+		t.Fatal("Push POST: got true, want filtered out")
+	}
+}
+
+func TestEnqueueSpool(t *testing.T) {
+	dir := t.TempDir()
+	s, err := spool.Open(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	// left by the last run
+	for _, u := range []string{"/left1", "/left2"} {
+		if _, err = s.Add(newTask(u).r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	v := NewValidator(Options{QueueSize: 3, QueueFullPolicy: PolicyDrop, Spool: s})
+	// accepted ones are spooled, dropped ones are not
+	for _, u := range []string{"/1", "/2", "/3", "/4"} {
+		v.enqueue(newTask(u), v.policy)
+	}
+	if got := len(s.Pending()); got != 5 {
+		t.Fatalf("spool pending: got %d, want 5", got)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "spool.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(b), "\n"); got != 5 {
+		t.Fatalf("spool records: got %d, want 5 adds only", got)
+	}
+
+	// only requests of the last run are recovered, even if recovering starts later
+	for range []string{"/1", "/2", "/3"} {
+		v.spoolDone(<-v.queue)
+	}
+	go v.recover()
+	for _, want := range []string{"/left1", "/left2"} {
+		if got := (<-v.queue).r.URL; got != want {
+			t.Fatalf("recovered: got %s, want %s", got, want)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if len(v.queue) != 0 {
+		t.Fatalf("recovered: got %d more, want only requests of the last run", len(v.queue))
+	}
+}
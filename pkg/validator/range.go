@@ -0,0 +1,329 @@
+package validator
+
+// RFC 7233 byte ranges.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+	"github.com/bocchi-the-cache/inspector/pkg/common/logger"
+)
+
+// rangeSpec is one byte-range-spec of Range header.
+// eg: "0-99" is {0, 99}, "100-" is {100, -1}, suffix "-500" is {-1, 500}
+type rangeSpec struct {
+	first int64
+	last  int64
+}
+
+// parseRange parses Range header, eg: "bytes=0-99,200-,-500"
+func parseRange(s string) ([]rangeSpec, error) {
+	s = strings.TrimSpace(s)
+	const prefix = "bytes="
+	if !strings.HasPrefix(s, prefix) {
+		return nil, fmt.Errorf("unsupported range unit: %s", s)
+	}
+	var specs []rangeSpec
+	for _, ra := range strings.Split(s[len(prefix):], ",") {
+		ra = strings.TrimSpace(ra)
+		if ra == "" {
+			// empty list elements are allowed
+			continue
+		}
+		i := strings.Index(ra, "-")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid range: %s", ra)
+		}
+		first, last := strings.TrimSpace(ra[:i]), strings.TrimSpace(ra[i+1:])
+		spec := rangeSpec{first: -1, last: -1}
+		var err error
+		if first == "" {
+			// suffix-byte-range-spec
+			if spec.last, err = strconv.ParseInt(last, 10, 64); err != nil || spec.last < 0 {
+				return nil, fmt.Errorf("invalid suffix range: %s", ra)
+			}
+		} else {
+			if spec.first, err = strconv.ParseInt(first, 10, 64); err != nil || spec.first < 0 {
+				return nil, fmt.Errorf("invalid range: %s", ra)
+			}
+			if last != "" {
+				if spec.last, err = strconv.ParseInt(last, 10, 64); err != nil || spec.last < spec.first {
+					return nil, fmt.Errorf("invalid range: %s", ra)
+				}
+			}
+		}
+		specs = append(specs, spec)
+	}
+	if len(specs) == 0 {
+		return nil, errors.New("empty range")
+	}
+	return specs, nil
+}
+
+// resolve returns the satisfiable byte interval of spec for a representation of size bytes.
+func (r rangeSpec) resolve(size int64) (interval, bool) {
+	if r.first < 0 {
+		// suffix, the last r.last bytes
+		if r.last == 0 || size == 0 {
+			return interval{}, false
+		}
+		start := size - r.last
+		if start < 0 {
+			start = 0
+		}
+		return interval{start, size - 1}, true
+	}
+	if r.first >= size {
+		return interval{}, false
+	}
+	end := r.last
+	if end < 0 || end >= size {
+		end = size - 1
+	}
+	return interval{r.first, end}, true
+}
+
+// interval is a closed byte interval [start, end]
+type interval struct {
+	start int64
+	end   int64
+}
+
+// contentRange is Content-Range header, eg: "bytes 0-99/1000", size is -1 for "bytes 0-99/*"
+type contentRange struct {
+	interval
+	size int64
+}
+
+func parseContentRange(s string) (contentRange, error) {
+	cr := contentRange{}
+	s = strings.TrimSpace(s)
+	const prefix = "bytes "
+	if !strings.HasPrefix(s, prefix) {
+		return cr, fmt.Errorf("invalid content range: %s", s)
+	}
+	s = s[len(prefix):]
+	slash := strings.Index(s, "/")
+	dash := strings.Index(s, "-")
+	if slash < 0 || dash < 0 || dash > slash {
+		return cr, fmt.Errorf("invalid content range: %s", s)
+	}
+	var err error
+	if cr.start, err = strconv.ParseInt(s[:dash], 10, 64); err != nil {
+		return cr, fmt.Errorf("invalid content range: %s", s)
+	}
+	if cr.end, err = strconv.ParseInt(s[dash+1:slash], 10, 64); err != nil || cr.end < cr.start {
+		return cr, fmt.Errorf("invalid content range: %s", s)
+	}
+	if s[slash+1:] == "*" {
+		cr.size = -1
+	} else if cr.size, err = strconv.ParseInt(s[slash+1:], 10, 64); err != nil || cr.end >= cr.size {
+		return cr, fmt.Errorf("invalid content range: %s", s)
+	}
+	return cr, nil
+}
+
+// rangePart is one part of 206 response
+type rangePart struct {
+	contentRange
+	body []byte
+}
+
+// rangeParts parses 206 response into parts, by Content-Range or multipart/byteranges body.
+func rangeParts(c *client.Content) ([]rangePart, error) {
+	mediaType, params, _ := mime.ParseMediaType(c.Header.Get("Content-Type"))
+	if mediaType != "multipart/byteranges" {
+		cr, err := parseContentRange(c.Header.Get("Content-Range"))
+		if err != nil {
+			return nil, err
+		}
+		part := rangePart{contentRange: cr, body: c.Content}
+		return []rangePart{part}, part.check()
+	}
+
+	if params["boundary"] == "" {
+		return nil, errors.New("multipart/byteranges without boundary")
+	}
+	var parts []rangePart
+	mr := multipart.NewReader(bytes.NewReader(c.Content), params["boundary"])
+	for {
+		p, err := mr.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		cr, err := parseContentRange(p.Header.Get("Content-Range"))
+		if err != nil {
+			return nil, err
+		}
+		body, err := ioutil.ReadAll(p)
+		if err != nil {
+			return nil, err
+		}
+		part := rangePart{contentRange: cr, body: body}
+		if err = part.check(); err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return nil, errors.New("multipart/byteranges without parts")
+	}
+	return parts, nil
+}
+
+func (p rangePart) check() error {
+	if int64(len(p.body)) != p.end-p.start+1 {
+		return fmt.Errorf("content range %d-%d doesn't match body length %d", p.start, p.end, len(p.body))
+	}
+	return nil
+}
+
+// checkRangeParts checks parts are what the request asks for, parts may be coalesced by server.
+func checkRangeParts(specs []rangeSpec, parts []rangePart) error {
+	size := int64(-1)
+	for _, p := range parts {
+		if p.size < 0 {
+			continue
+		}
+		if size >= 0 && p.size != size {
+			return fmt.Errorf("complete length not match between parts, %d != %d", size, p.size)
+		}
+		size = p.size
+	}
+	if size < 0 {
+		// unknown complete length, nothing to check against
+		return nil
+	}
+
+	var requested []interval
+	for _, spec := range specs {
+		if iv, ok := spec.resolve(size); ok {
+			requested = append(requested, iv)
+		}
+	}
+	requested = mergeIntervals(requested)
+	for _, p := range parts {
+		if !covered(requested, p.interval) {
+			return fmt.Errorf("content range %d-%d/%d is not requested", p.start, p.end, p.size)
+		}
+	}
+	return nil
+}
+
+// compareRangeParts checks each test part against the corresponding bytes of baseline parts.
+// It returns the first differing offset in the whole representation, -1 if same.
+func compareRangeParts(baseline []rangePart, test []rangePart) int64 {
+	bIntervals := make([]interval, 0, len(baseline))
+	for _, p := range baseline {
+		bIntervals = append(bIntervals, p.interval)
+	}
+	tIntervals := make([]interval, 0, len(test))
+	for _, p := range test {
+		tIntervals = append(tIntervals, p.interval)
+	}
+	bIntervals, tIntervals = mergeIntervals(bIntervals), mergeIntervals(tIntervals)
+	// both should cover the same bytes
+	for i := 0; i < len(bIntervals) || i < len(tIntervals); i++ {
+		if i >= len(bIntervals) {
+			return tIntervals[i].start
+		}
+		if i >= len(tIntervals) || bIntervals[i] != tIntervals[i] {
+			return bIntervals[i].start
+		}
+	}
+
+	offset := int64(-1)
+	for _, tp := range test {
+		for _, bp := range baseline {
+			start, end := tp.start, tp.end
+			if bp.start > start {
+				start = bp.start
+			}
+			if bp.end < end {
+				end = bp.end
+			}
+			if start > end {
+				continue
+			}
+			idx := firstDiff(bp.body[start-bp.start:end-bp.start+1], tp.body[start-tp.start:end-tp.start+1])
+			if idx >= 0 && (offset < 0 || start+int64(idx) < offset) {
+				offset = start + int64(idx)
+			}
+		}
+	}
+	return offset
+}
+
+func mergeIntervals(ivs []interval) []interval {
+	if len(ivs) == 0 {
+		return ivs
+	}
+	sorted := append([]interval(nil), ivs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].start < sorted[j].start })
+	merged := []interval{sorted[0]}
+	for _, iv := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if iv.start <= last.end+1 {
+			if iv.end > last.end {
+				last.end = iv.end
+			}
+			continue
+		}
+		merged = append(merged, iv)
+	}
+	return merged
+}
+
+// covered checks iv is inside one of merged intervals.
+func covered(merged []interval, iv interval) bool {
+	for _, m := range merged {
+		if iv.start >= m.start && iv.end <= m.end {
+			return true
+		}
+	}
+	return false
+}
+
+// compareRange validates Content-Range of test 206 response, and checks each part against baseline.
+func compareRange(r *client.Request, b *client.Content, t *client.Content) Verdict {
+	specs, err := parseRange(r.Header.Get("Range"))
+	if err != nil {
+		// invalid Range should be ignored by servers, compare as a whole
+		if ok, offset := compareContent(b, t); !ok {
+			return Verdict{State: StateContentNotMatch, Offset: offset}
+		}
+		return Verdict{State: StatePass, Offset: -1}
+	}
+	testParts, err := rangeParts(t)
+	if err == nil {
+		err = checkRangeParts(specs, testParts)
+	}
+	if err != nil {
+		logger.Infof("invalid range response of test, path: %s, range: %s, err: %s", r.Path(), r.Header.Get("Range"), err)
+		return Verdict{State: StateRangeInvalid, Offset: -1, Reason: err.Error()}
+	}
+	baselineParts, err := rangeParts(b)
+	if err != nil {
+		logger.Infof("invalid range response of baseline, path: %s, range: %s, err: %s", r.Path(), r.Header.Get("Range"), err)
+		if ok, offset := compareContent(b, t); !ok {
+			return Verdict{State: StateContentNotMatch, Offset: offset}
+		}
+		return Verdict{State: StatePass, Offset: -1}
+	}
+	if offset := compareRangeParts(baselineParts, testParts); offset >= 0 {
+		return Verdict{State: StateContentNotMatch, Offset: offset}
+	}
+	return Verdict{State: StatePass, Offset: -1}
+}
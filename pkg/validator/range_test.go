@@ -0,0 +1,327 @@
+package validator
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"reflect"
+	"testing"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		want    []rangeSpec
+		wantErr bool
+	}{
+		{"closed", "bytes=0-99", []rangeSpec{{0, 99}}, false},
+		{"single byte", "bytes=5-5", []rangeSpec{{5, 5}}, false},
+		{"open-ended", "bytes=100-", []rangeSpec{{100, -1}}, false},
+		{"suffix", "bytes=-500", []rangeSpec{{-1, 500}}, false},
+		{"zero suffix", "bytes=-0", []rangeSpec{{-1, 0}}, false},
+		{"multiple", "bytes=0-99,200-,-500", []rangeSpec{{0, 99}, {200, -1}, {-1, 500}}, false},
+		{"overlapping", "bytes=0-99,50-149", []rangeSpec{{0, 99}, {50, 149}}, false},
+		{"spaces and empty elements", " bytes= 0-9 , ,20-29,", []rangeSpec{{0, 9}, {20, 29}}, false},
+		{"unsupported unit", "items=0-9", nil, true},
+		{"no unit", "0-9", nil, true},
+		{"empty", "bytes=", nil, true},
+		{"only commas", "bytes=,,", nil, true},
+		{"no dash", "bytes=10", nil, true},
+		{"dash only", "bytes=-", nil, true},
+		{"last before first", "bytes=10-5", nil, true},
+		{"not a number", "bytes=a-b", nil, true},
+		{"negative suffix", "bytes=--5", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRange(tt.header)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRange(%q): got error %v, want error %v", tt.header, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parseRange(%q): got %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRangeSpecResolve(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   rangeSpec
+		size   int64
+		want   interval
+		wantOK bool
+	}{
+		{"closed", rangeSpec{0, 99}, 1000, interval{0, 99}, true},
+		{"last beyond size", rangeSpec{900, 5000}, 1000, interval{900, 999}, true},
+		{"open-ended", rangeSpec{100, -1}, 1000, interval{100, 999}, true},
+		{"last byte", rangeSpec{999, -1}, 1000, interval{999, 999}, true},
+		{"suffix", rangeSpec{-1, 500}, 1000, interval{500, 999}, true},
+		{"suffix longer than size", rangeSpec{-1, 5000}, 1000, interval{0, 999}, true},
+		{"first at size", rangeSpec{1000, -1}, 1000, interval{}, false},
+		{"first beyond size", rangeSpec{2000, 2999}, 1000, interval{}, false},
+		{"zero suffix", rangeSpec{-1, 0}, 1000, interval{}, false},
+		{"suffix of empty", rangeSpec{-1, 10}, 0, interval{}, false},
+		{"empty", rangeSpec{0, -1}, 0, interval{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.spec.resolve(tt.size)
+			if ok != tt.wantOK || got != tt.want {
+				t.Fatalf("resolve(%d): got (%v, %v), want (%v, %v)", tt.size, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		want    contentRange
+		wantErr bool
+	}{
+		{"closed", "bytes 0-99/1000", contentRange{interval{0, 99}, 1000}, false},
+		{"last byte", "bytes 999-999/1000", contentRange{interval{999, 999}, 1000}, false},
+		{"unknown size", "bytes 0-99/*", contentRange{interval{0, 99}, -1}, false},
+		{"unsatisfied", "bytes */1000", contentRange{}, true},
+		{"end at size", "bytes 0-1000/1000", contentRange{}, true},
+		{"end before start", "bytes 10-5/1000", contentRange{}, true},
+		{"no unit", "0-99/1000", contentRange{}, true},
+		{"no size", "bytes 0-99", contentRange{}, true},
+		{"not a number", "bytes a-b/c", contentRange{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseContentRange(tt.header)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseContentRange(%q): got error %v, want error %v", tt.header, err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Fatalf("parseContentRange(%q): got %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeIntervals(t *testing.T) {
+	tests := []struct {
+		name string
+		ivs  []interval
+		want []interval
+	}{
+		{"empty", nil, nil},
+		{"single", []interval{{0, 9}}, []interval{{0, 9}}},
+		{"disjoint unsorted", []interval{{20, 29}, {0, 9}}, []interval{{0, 9}, {20, 29}}},
+		{"adjacent", []interval{{0, 9}, {10, 19}}, []interval{{0, 19}}},
+		{"overlapping", []interval{{0, 50}, {40, 99}}, []interval{{0, 99}}},
+		{"contained", []interval{{0, 99}, {10, 20}}, []interval{{0, 99}}},
+		{"chain", []interval{{30, 39}, {0, 9}, {10, 29}, {50, 59}}, []interval{{0, 39}, {50, 59}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeIntervals(tt.ivs); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("mergeIntervals(%v): got %v, want %v", tt.ivs, got, tt.want)
+			}
+		})
+	}
+}
+
+// testBody is a representation of 1000 bytes, whose bytes differ from their neighbours.
+var testBody = func() []byte {
+	b := make([]byte, 1000)
+	for i := range b {
+		b[i] = byte(i % 251)
+	}
+	return b
+}()
+
+// partContent builds a 206 response of one part.
+func partContent(start, end int64, size string, body []byte) *client.Content {
+	h := http.Header{}
+	h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%s", start, end, size))
+	return &client.Content{Status: http.StatusPartialContent, Header: h, Content: body}
+}
+
+// multipartContent builds a multipart/byteranges 206 response, each part is [start, end] of testBody.
+// A part whose body is given in bodies replaces the bytes of testBody.
+func multipartContent(t *testing.T, parts []interval, bodies map[int][]byte) *client.Content {
+	t.Helper()
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for i, p := range parts {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", "application/octet-stream")
+		h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", p.start, p.end, len(testBody)))
+		pw, err := w.CreatePart(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, ok := bodies[i]
+		if !ok {
+			body = testBody[p.start : p.end+1]
+		}
+		if _, err = pw.Write(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	h := http.Header{}
+	h.Set("Content-Type", "multipart/byteranges; boundary="+w.Boundary())
+	return &client.Content{Status: http.StatusPartialContent, Header: h, Content: buf.Bytes()}
+}
+
+func TestRangeParts(t *testing.T) {
+	tests := []struct {
+		name    string
+		content func(t *testing.T) *client.Content
+		want    []interval
+		wantErr bool
+	}{
+		{"single part", func(t *testing.T) *client.Content {
+			return partContent(10, 19, "1000", testBody[10:20])
+		}, []interval{{10, 19}}, false},
+		{"single part of unknown size", func(t *testing.T) *client.Content {
+			return partContent(0, 9, "*", testBody[:10])
+		}, []interval{{0, 9}}, false},
+		{"single part length mismatch", func(t *testing.T) *client.Content {
+			return partContent(10, 19, "1000", testBody[10:15])
+		}, nil, true},
+		{"no content range", func(t *testing.T) *client.Content {
+			return &client.Content{Status: http.StatusPartialContent, Header: http.Header{}, Content: testBody}
+		}, nil, true},
+		{"multipart", func(t *testing.T) *client.Content {
+			return multipartContent(t, []interval{{0, 9}, {500, 599}, {990, 999}}, nil)
+		}, []interval{{0, 9}, {500, 599}, {990, 999}}, false},
+		{"multipart part length mismatch", func(t *testing.T) *client.Content {
+			return multipartContent(t, []interval{{0, 9}, {500, 599}}, map[int][]byte{1: testBody[500:550]})
+		}, nil, true},
+		{"multipart without parts", func(t *testing.T) *client.Content {
+			return multipartContent(t, nil, nil)
+		}, nil, true},
+		{"multipart without boundary", func(t *testing.T) *client.Content {
+			c := multipartContent(t, []interval{{0, 9}}, nil)
+			c.Header.Set("Content-Type", "multipart/byteranges")
+			return c
+		}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts, err := rangeParts(tt.content(t))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("rangeParts: got error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var got []interval
+			for _, p := range parts {
+				got = append(got, p.interval)
+				if !bytes.Equal(p.body, testBody[p.start:p.end+1]) {
+					t.Fatalf("body of part %d-%d doesn't match", p.start, p.end)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("rangeParts: got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckRangeParts(t *testing.T) {
+	part := func(start, end, size int64) rangePart {
+		return rangePart{contentRange: contentRange{interval{start, end}, size}}
+	}
+	tests := []struct {
+		name    string
+		header  string
+		parts   []rangePart
+		wantErr bool
+	}{
+		{"exact", "bytes=0-99", []rangePart{part(0, 99, 1000)}, false},
+		{"open-ended", "bytes=900-", []rangePart{part(900, 999, 1000)}, false},
+		{"suffix", "bytes=-100", []rangePart{part(900, 999, 1000)}, false},
+		{"last beyond size", "bytes=900-5000", []rangePart{part(900, 999, 1000)}, false},
+		{"coalesced", "bytes=0-9,10-19", []rangePart{part(0, 19, 1000)}, false},
+		{"overlapping coalesced", "bytes=0-50,40-99", []rangePart{part(0, 99, 1000)}, false},
+		{"overlapping answered apart", "bytes=0-50,40-99", []rangePart{part(0, 50, 1000), part(40, 99, 1000)}, false},
+		{"multiple", "bytes=0-9,-10", []rangePart{part(0, 9, 1000), part(990, 999, 1000)}, false},
+		{"unknown size", "bytes=0-9", []rangePart{part(0, 99, -1)}, false},
+		{"more than requested", "bytes=0-9", []rangePart{part(0, 10, 1000)}, true},
+		{"not requested", "bytes=0-9", []rangePart{part(100, 109, 1000)}, true},
+		{"unsatisfiable", "bytes=1000-", []rangePart{part(0, 999, 1000)}, true},
+		{"sizes differ", "bytes=0-9,20-29", []rangePart{part(0, 9, 1000), part(20, 29, 999)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			specs, err := parseRange(tt.header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = checkRangeParts(specs, tt.parts); (err != nil) != tt.wantErr {
+				t.Fatalf("checkRangeParts: got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompareRange(t *testing.T) {
+	changed := append([]byte(nil), testBody[500:600]...)
+	changed[42] ^= 0xff
+
+	tests := []struct {
+		name       string
+		header     string
+		baseline   func(t *testing.T) *client.Content
+		test       func(t *testing.T) *client.Content
+		wantState  string
+		wantOffset int64
+	}{
+		{"multipart against multipart", "bytes=0-9,500-599",
+			func(t *testing.T) *client.Content { return multipartContent(t, []interval{{0, 9}, {500, 599}}, nil) },
+			func(t *testing.T) *client.Content { return multipartContent(t, []interval{{0, 9}, {500, 599}}, nil) },
+			StatePass, -1},
+		{"multipart differs", "bytes=0-9,500-599",
+			func(t *testing.T) *client.Content { return multipartContent(t, []interval{{0, 9}, {500, 599}}, nil) },
+			func(t *testing.T) *client.Content {
+				return multipartContent(t, []interval{{0, 9}, {500, 599}}, map[int][]byte{1: changed})
+			},
+			StateContentNotMatch, 542},
+		{"coalesced by baseline", "bytes=0-9,10-19",
+			func(t *testing.T) *client.Content { return partContent(0, 19, "1000", testBody[:20]) },
+			func(t *testing.T) *client.Content { return multipartContent(t, []interval{{0, 9}, {10, 19}}, nil) },
+			StatePass, -1},
+		{"test covers less", "bytes=0-9,500-599",
+			func(t *testing.T) *client.Content { return multipartContent(t, []interval{{0, 9}, {500, 599}}, nil) },
+			func(t *testing.T) *client.Content { return partContent(0, 9, "1000", testBody[:10]) },
+			StateContentNotMatch, 500},
+		{"test answers unrequested", "bytes=0-9",
+			func(t *testing.T) *client.Content { return partContent(0, 9, "1000", testBody[:10]) },
+			func(t *testing.T) *client.Content { return partContent(0, 19, "1000", testBody[:20]) },
+			StateRangeInvalid, -1},
+		{"invalid range is compared as a whole", "lines=1-2",
+			func(t *testing.T) *client.Content { return &client.Content{Status: http.StatusOK, Content: testBody} },
+			func(t *testing.T) *client.Content {
+				return &client.Content{Status: http.StatusOK, Content: testBody[:10]}
+			},
+			StateContentNotMatch, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &client.Request{Method: http.MethodGet, URL: "/a", Header: http.Header{}}
+			r.Header.Set("Range", tt.header)
+			got := compareRange(r, tt.baseline(t), tt.test(t))
+			if got.State != tt.wantState || got.Offset != tt.wantOffset {
+				t.Fatalf("compareRange: got (%s, %d), want (%s, %d)", got.State, got.Offset, tt.wantState, tt.wantOffset)
+			}
+		})
+	}
+}
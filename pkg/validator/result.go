@@ -0,0 +1,189 @@
+package validator
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+)
+
+// ResultVersion is the version of Result records.
+// Fields may be added within a version, it's bumped when fields are removed, renamed or change meaning.
+const ResultVersion = 1
+
+// kinds of records in log/result.txt
+const (
+	// KindCompare is a Result of comparing a request against a test target.
+	KindCompare = "COMPARE"
+	// KindRange is a RangeResult of range assembly.
+	KindRange = "RANGE"
+	// KindReverify is a ReverifyResult of re-verifying a stored case.
+	KindReverify = "REVERIFY"
+)
+
+// error classes of fetch errors
+const (
+	ErrorTimeout     = "TIMEOUT"
+	ErrorCanceled    = "CANCELED"
+	ErrorDNS         = "DNS"
+	ErrorConnRefused = "CONNECTION_REFUSED"
+	ErrorConnReset   = "CONNECTION_RESET"
+	ErrorTLS         = "TLS"
+	// ErrorEOF means the connection is closed before the whole response is read.
+	ErrorEOF   = "EOF"
+	ErrorOther = "OTHER"
+)
+
+// headers of baseline and test contents in result records by default
+var defaultResultHeaders = []string{
+	"Content-Type",
+	"Content-Length",
+	"Content-Encoding",
+	"Cache-Control",
+	"ETag",
+	"Last-Modified",
+	"Age",
+	"X-Cache",
+}
+
+// Result is the record of validating a request against a test target,
+// written as one json object per line in log/result.txt.
+type Result struct {
+	Version int
+	// Kind is KindCompare.
+	Kind   string
+	Time   time.Time
+	Target string
+	Method string
+	Host   string
+	Path   string
+	// URL is the request uri with query
+	URL    string
+	State  string
+	Reason string `json:",omitempty"`
+	// ErrorClass is the class of baseline fetch error, or test fetch error if baseline is fetched.
+	ErrorClass string `json:",omitempty"`
+	// DiffOffset is the first differing offset of bodies, -1 if unknown or not differing.
+	DiffOffset int64
+	// DiffPaths are differing json paths.
+	DiffPaths  []string `json:",omitempty"`
+	HeaderDiff []string `json:",omitempty"`
+	// CaseID is the id of stored bad case, DiffKey is the storage key of its diff artifact.
+	CaseID   string `json:",omitempty"`
+	DiffKey  string `json:",omitempty"`
+	Baseline ResultContent
+	Test     ResultContent
+}
+
+// ResultContent is the baseline or test part of a result record.
+type ResultContent struct {
+	// Status is 0 if fetching failed.
+	Status int
+	// Hash is md5 hex of the body as received, empty if not available. Size is the body size as received.
+	Hash     string `json:",omitempty"`
+	Size     int64
+	Encoding string `json:",omitempty"`
+	// LatencyMS is the time in milliseconds from sending the request until the body is read.
+	LatencyMS float64
+	// Headers are selected response headers, by validator.result.headers.
+	Headers    map[string]string `json:",omitempty"`
+	Error      string            `json:",omitempty"`
+	ErrorClass string            `json:",omitempty"`
+}
+
+// RangeResult is the record of pulling an object from a test target as byte ranges.
+type RangeResult struct {
+	Version int
+	// Kind is KindRange.
+	Kind   string
+	Time   time.Time
+	Target string
+	Method string
+	Host   string
+	Path   string
+	URL    string
+	State  string
+	Size   int64
+	Ranges int
+	// FailedRange is the first failing range, eg: bytes=0-1023.
+	FailedRange string `json:",omitempty"`
+	Error       string `json:",omitempty"`
+	ErrorClass  string `json:",omitempty"`
+}
+
+// ReverifyResult is the record of re-verifying a stored case.
+type ReverifyResult struct {
+	Version int
+	// Kind is KindReverify.
+	Kind string
+	Time time.Time
+	ReverifyCase
+}
+
+// newResultContent returns the result record part of content, or of err if fetching failed.
+func (v *Validator) newResultContent(c *client.Content, err error) ResultContent {
+	rc := ResultContent{}
+	// decode errors are reported as the reason of DECODE_ERROR
+	if err != nil && !isDecodeError(err) {
+		rc.Error = err.Error()
+		rc.ErrorClass = errorClass(err)
+	}
+	if c == nil {
+		return rc
+	}
+	rc.Status = c.Status
+	rc.Hash = contentHash(c)
+	rc.Size = c.Size
+	if c.Decoded && c.RawSize > 0 {
+		rc.Size = c.RawSize
+	}
+	rc.Encoding = contentEncoding(c)
+	rc.LatencyMS = float64(c.Latency) / float64(time.Millisecond)
+	for _, h := range v.resultHeaders {
+		if vv := c.Header.Values(h); len(vv) > 0 {
+			if rc.Headers == nil {
+				rc.Headers = make(map[string]string, len(v.resultHeaders))
+			}
+			rc.Headers[http.CanonicalHeaderKey(h)] = strings.Join(vv, ", ")
+		}
+	}
+	return rc
+}
+
+// errorClass classifies fetch errors, "" if err is nil.
+func errorClass(err error) string {
+	if err == nil {
+		return ""
+	}
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	var recordErr tls.RecordHeaderError
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ErrorCanceled
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, client.ErrStreamReadTimeout), errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorTimeout
+	case errors.As(err, &dnsErr):
+		return ErrorDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrorConnRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return ErrorConnReset
+	case errors.As(err, &recordErr), errors.As(err, &certErr), errors.As(err, &unknownAuthErr), errors.As(err, &hostnameErr):
+		return ErrorTLS
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return ErrorEOF
+	}
+	return ErrorOther
+}
@@ -0,0 +1,359 @@
+package validator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+	"github.com/bocchi-the-cache/inspector/pkg/common/logger"
+	"github.com/bocchi-the-cache/inspector/pkg/common/result_logger"
+	"github.com/bocchi-the-cache/inspector/pkg/index"
+	"github.com/bocchi-the-cache/inspector/pkg/storage"
+)
+
+// number of cases re-verified at the same time
+const reverifyConcurrency = 8
+
+// storage key prefixes of archived cases and reports
+const (
+	archivePrefix = "archive/"
+	reportPrefix  = "report/"
+)
+
+// max finished reverify jobs kept in memory, their reports are still in storage
+const maxReverifyJobs = 100
+
+// reverify job states
+const (
+	JobRunning = "RUNNING"
+	JobDone    = "DONE"
+	JobFailed  = "FAILED"
+)
+
+// results of re-verifying a case
+const (
+	// ReverifyFixed means the case is no longer a bad case, eg: PASS, NOISE, or the same non-200 status.
+	ReverifyFixed = "FIXED"
+	// ReverifyBroken means the case is still a bad case.
+	ReverifyBroken = "BROKEN"
+	// ReverifyInconclusive means it can't tell, eg: FETCH_ERROR, BASELINE_UNSTABLE.
+	ReverifyInconclusive = "INCONCLUSIVE"
+	// ReverifyFailed means the case can't be re-verified, eg: it can't be loaded, or its target is unknown.
+	ReverifyFailed = "FAILED"
+)
+
+var (
+	// ErrReverifyNoFilter means no filter is set, and All is not set either.
+	ErrReverifyNoFilter = errors.New("no case filter, set all=true to re-verify all cases")
+	// ErrReverifyRunning means another reverify job is running.
+	ErrReverifyRunning = errors.New("another reverify job is running")
+	// ErrJobNotFound means the reverify job is unknown, or evicted.
+	ErrJobNotFound = errors.New("reverify job not found")
+)
+
+// ReverifyOptions selects stored cases to re-verify, Limit and Cursor of Query are ignored.
+type ReverifyOptions struct {
+	Query index.Query
+	// All must be set to re-verify all cases, if Query has no filter.
+	All bool
+	// Archive moves fixed cases to archive/ of storage, and removes them from index.
+	Archive bool
+}
+
+// ReverifyJob is a reverify running in background, its ID is the ID of the report.
+type ReverifyJob struct {
+	ID       string
+	State    string
+	Started  time.Time
+	Finished *time.Time `json:",omitempty"`
+	Error    string     `json:",omitempty"`
+	// Report is set when the job is done.
+	Report *ReverifyReport `json:",omitempty"`
+}
+
+type reverifyJobs struct {
+	mu      sync.Mutex
+	running bool
+	jobs    map[string]*ReverifyJob
+	order   []string
+}
+
+var jobs = &reverifyJobs{jobs: map[string]*ReverifyJob{}}
+
+// ReverifyCase is the result of re-verifying a stored case.
+type ReverifyCase struct {
+	ID     string
+	Target string
+	Host   string
+	URL    string
+	// OldState is the state when the case was stored.
+	OldState string
+	State    string `json:",omitempty"`
+	// Result is FIXED, BROKEN, INCONCLUSIVE or FAILED.
+	Result   string
+	Archived bool   `json:",omitempty"`
+	Error    string `json:",omitempty"`
+}
+
+// ReverifyReport is stored as report/reverify-<ID> of storage.
+type ReverifyReport struct {
+	ID   string
+	Time time.Time
+	// Fixed cases are no longer bad cases, Broken cases still are, Inconclusive cases can't tell, Failed cases can't be re-verified.
+	Total        int
+	Fixed        int
+	Broken       int
+	Inconclusive int
+	Failed       int
+	Cases        []*ReverifyCase
+}
+
+// Reverify re-issues requests of stored cases to baseline and their test targets, and reports which ones are fixed.
+func Reverify(opt ReverifyOptions) (*ReverifyReport, error) {
+	return DefaultValidator.Reverify(opt)
+}
+
+func (v *Validator) Reverify(opt ReverifyOptions) (*ReverifyReport, error) {
+	if !opt.All && !opt.Query.Filtered() {
+		return nil, ErrReverifyNoFilter
+	}
+	now := time.Now()
+	return v.reverify(now.UTC().Format(caseTimeLayout), now, opt)
+}
+
+// StartReverify starts a reverify job in background, only one job runs at a time.
+func StartReverify(opt ReverifyOptions) (*ReverifyJob, error) {
+	return DefaultValidator.StartReverify(opt)
+}
+
+func (v *Validator) StartReverify(opt ReverifyOptions) (*ReverifyJob, error) {
+	if !opt.All && !opt.Query.Filtered() {
+		return nil, ErrReverifyNoFilter
+	}
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	if jobs.running {
+		return nil, ErrReverifyRunning
+	}
+	now := time.Now()
+	job := &ReverifyJob{ID: now.UTC().Format(caseTimeLayout), State: JobRunning, Started: now}
+	jobs.running = true
+	jobs.jobs[job.ID] = job
+	jobs.order = append(jobs.order, job.ID)
+	if len(jobs.order) > maxReverifyJobs {
+		delete(jobs.jobs, jobs.order[0])
+		jobs.order = jobs.order[1:]
+	}
+	logger.Infof("reverify job started, id: %s", job.ID)
+
+	go func() {
+		report, err := v.reverify(job.ID, now, opt)
+		jobs.mu.Lock()
+		defer jobs.mu.Unlock()
+		jobs.running = false
+		finished := time.Now()
+		job.Finished = &finished
+		if err != nil {
+			logger.Errorf("reverify job error, id: %s, err: %s", job.ID, err)
+			job.State, job.Error = JobFailed, err.Error()
+			return
+		}
+		job.State, job.Report = JobDone, report
+	}()
+	c := *job
+	return &c, nil
+}
+
+// GetReverifyJob returns a copy of the job, its report is shared and must not be modified.
+func GetReverifyJob(id string) (*ReverifyJob, error) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	job, ok := jobs.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	c := *job
+	return &c, nil
+}
+
+func (v *Validator) reverify(id string, now time.Time, opt ReverifyOptions) (report *ReverifyReport, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("reverify panic: %v", r)
+		}
+	}()
+	ids, err := reverifyCaseIDs(opt.Query)
+	if err != nil {
+		return nil, err
+	}
+	report = &ReverifyReport{
+		ID:    id,
+		Time:  now,
+		Cases: make([]*ReverifyCase, len(ids)),
+	}
+
+	wg := sync.WaitGroup{}
+	sem := make(chan struct{}, reverifyConcurrency)
+	for i, id := range ids {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, id string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			defer handlePanic()
+			report.Cases[i] = v.reverifyCase(id, opt)
+		}(i, id)
+	}
+	wg.Wait()
+
+	cases := report.Cases[:0]
+	for _, rc := range report.Cases {
+		// nil if filtered out, or panicked
+		if rc == nil {
+			continue
+		}
+		cases = append(cases, rc)
+		switch rc.Result {
+		case ReverifyFixed:
+			report.Fixed++
+		case ReverifyBroken:
+			report.Broken++
+		case ReverifyInconclusive:
+			report.Inconclusive++
+		default:
+			report.Failed++
+		}
+	}
+	report.Cases = cases
+	report.Total = len(cases)
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+	if err = storage.Write(reportPrefix+"reverify-"+report.ID, data); err != nil {
+		logger.Errorf("write reverify report error, id: %s, err: %s", report.ID, err)
+	}
+	logger.Infof("reverify done, report: %s, total: %d, fixed: %d, broken: %d, inconclusive: %d, failed: %d",
+		report.ID, report.Total, report.Fixed, report.Broken, report.Inconclusive, report.Failed)
+	return report, nil
+}
+
+// reverifyCaseIDs returns ids of cases matching the query, by index if enabled, or by walking storage.
+func reverifyCaseIDs(q index.Query) ([]string, error) {
+	var ids []string
+	if !index.Enabled() {
+		err := storage.Walk(func(key string, size int64, modTime time.Time) error {
+			if parts := strings.Split(key, "/"); len(parts) == 3 && parts[2] == caseKeyMeta {
+				ids = append(ids, parts[1])
+			}
+			return nil
+		})
+		return ids, err
+	}
+	q.Cursor = ""
+	q.Limit = index.MaxLimit
+	for {
+		res, err := index.Search(q)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range res.Cases {
+			ids = append(ids, e.ID)
+		}
+		if res.Next == "" {
+			return ids, nil
+		}
+		q.Cursor = res.Next
+	}
+}
+
+// reverifyCase returns nil if the case doesn't match the query, which is only checked without index.
+func (v *Validator) reverifyCase(id string, opt ReverifyOptions) *ReverifyCase {
+	c, err := ReadCase(id)
+	if err != nil {
+		return &ReverifyCase{ID: id, Result: ReverifyFailed, Error: err.Error()}
+	}
+	if !index.Enabled() && !caseEntry(c).Match(&opt.Query) {
+		return nil
+	}
+	rc := &ReverifyCase{
+		ID:       id,
+		Target:   c.Target,
+		Host:     c.Host,
+		URL:      c.URL,
+		OldState: c.State,
+	}
+	var f *client.Fetcher
+	for _, tf := range client.TestFetchers {
+		if tf.Name == c.Target {
+			f = tf
+		}
+	}
+	if f == nil {
+		rc.Result, rc.Error = ReverifyFailed, fmt.Sprintf("unknown test target: %s", c.Target)
+		return rc
+	}
+
+	BaselineContent, errBaseline := v.fetchContent(client.BaselineFetcher, c.Request)
+	TestContent, errTest := v.fetchContent(f, c.Request)
+	res := v.check(c.Request, f, BaselineContent, errBaseline, TestContent, errTest)
+	rc.State = res.state
+	rc.Result = reverifyResult(res.state)
+	if res.state == StateFetchError {
+		rc.Error = fmt.Sprintf("baseline: %v, test: %v", errBaseline, errTest)
+	}
+	if rc.Result == ReverifyFixed && opt.Archive {
+		if err = archiveCase(id); err != nil {
+			logger.Errorf("archive bad case error, id: %s, err: %s", id, err)
+		} else {
+			rc.Archived = true
+		}
+	}
+	result_logger.Record(&ReverifyResult{Version: ResultVersion, Kind: KindReverify, Time: time.Now(), ReverifyCase: *rc})
+	return rc
+}
+
+// reverifyResult classifies the new state of a case, it's fixed once it's no longer a bad case state.
+func reverifyResult(state string) string {
+	switch {
+	case state == StateFetchError || state == StateBaselineUnstable || state == StateEmptyContent:
+		return ReverifyInconclusive
+	case isBadCase(state):
+		return ReverifyBroken
+	}
+	return ReverifyFixed
+}
+
+// archiveCase moves the case bundle to archive/ of storage, and removes it from index.
+func archiveCase(id string) error {
+	var names []string
+	for _, name := range []string{caseKeyBaseline, caseKeyTest, caseKeyDiff, caseKeyMeta} {
+		data, ok := storage.Read(caseKey(id, name))
+		if !ok && name == caseKeyDiff {
+			// only CONTENT_NOT_MATCH cases have diff
+			continue
+		}
+		if !ok {
+			return fmt.Errorf("%w: missing %s of case %s", ErrCaseNotFound, name, id)
+		}
+		if err := storage.Write(archivePrefix+caseKey(id, name), data); err != nil {
+			return err
+		}
+		names = append(names, name)
+	}
+	if err := index.Delete(id); err != nil {
+		return err
+	}
+	// meta first, a case without meta is incomplete
+	for i := len(names) - 1; i >= 0; i-- {
+		if err := storage.Delete(caseKey(id, names[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
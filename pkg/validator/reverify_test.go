@@ -0,0 +1,184 @@
+package validator
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+	"github.com/bocchi-the-cache/inspector/pkg/index"
+)
+
+// setTest points the only test fetcher, named "test", to srv during the test.
+func setTest(t *testing.T, srv *httptest.Server) {
+	old := client.TestFetchers
+	f := client.NewHttpFetcher(strings.TrimPrefix(srv.URL, "http://"))
+	f.Name = "test"
+	client.TestFetchers = []*client.Fetcher{f}
+	t.Cleanup(func() { client.TestFetchers = old })
+}
+
+func TestReverifyResult(t *testing.T) {
+	tests := []struct {
+		state string
+		want  string
+	}{
+		{StatePass, ReverifyFixed},
+		{StateNoise, ReverifyFixed},
+		{StateStatusSkip, ReverifyFixed},
+		{StateStatusNotMatch, ReverifyBroken},
+		{StateContentNotMatch, ReverifyBroken},
+		{StateHeaderNotMatch, ReverifyBroken},
+		{StateTestUnstable, ReverifyBroken},
+		{StateDecodeError, ReverifyBroken},
+		{StateFetchError, ReverifyInconclusive},
+		{StateBaselineUnstable, ReverifyInconclusive},
+		{StateEmptyContent, ReverifyInconclusive},
+	}
+	for _, tt := range tests {
+		if got := reverifyResult(tt.state); got != tt.want {
+			t.Errorf("reverifyResult(%s): got %s, want %s", tt.state, got, tt.want)
+		}
+	}
+}
+
+func TestReverify(t *testing.T) {
+	var unstable int32
+	baseline := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gone":
+			w.WriteHeader(http.StatusNotFound)
+		case "/unstable":
+			_, _ = fmt.Fprintf(w, "v%d", atomic.AddInt32(&unstable, 1))
+		default:
+			_, _ = io.WriteString(w, "a")
+		}
+	}))
+	defer baseline.Close()
+	setBaseline(t, baseline)
+	test := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gone":
+			w.WriteHeader(http.StatusNotFound)
+		case "/broken", "/unstable":
+			_, _ = io.WriteString(w, "b")
+		case "/down":
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		default:
+			_, _ = io.WriteString(w, "a")
+		}
+	}))
+	defer test.Close()
+	setTest(t, test)
+
+	m := setStorage(t)
+	setIndex(t)
+	now := time.Now().UTC()
+	stored := []struct {
+		url        string
+		state      string
+		target     string
+		noTest     bool
+		wantState  string
+		wantResult string
+	}{
+		{"/fixed", StateContentNotMatch, "test", false, StatePass, ReverifyFixed},
+		{"/gone", StateStatusNotMatch, "test", false, StateStatusSkip, ReverifyFixed},
+		{"/broken", StateContentNotMatch, "test", false, StateContentNotMatch, ReverifyBroken},
+		{"/unstable", StateContentNotMatch, "test", false, StateBaselineUnstable, ReverifyInconclusive},
+		{"/down", StateContentNotMatch, "test", false, StateFetchError, ReverifyInconclusive},
+		{"/other", StateContentNotMatch, "other", false, "", ReverifyFailed},
+		{"/lost", StateContentNotMatch, "test", true, "", ReverifyFailed},
+	}
+	ids := map[string]int{}
+	for i, s := range stored {
+		c := newCase(now.Add(time.Duration(i)*time.Second), s.url, s.state)
+		c.Target = s.target
+		if err := WriteCase(c); err != nil {
+			t.Fatal(err)
+		}
+		if s.noTest {
+			_ = m.Delete(caseKey(c.ID, caseKeyTest))
+		}
+		ids[c.ID] = i
+	}
+
+	v := NewValidator(Options{ConfirmRetries: 1})
+	if _, err := v.Reverify(ReverifyOptions{}); err != ErrReverifyNoFilter {
+		t.Fatalf("Reverify without filter: got %v, want %v", err, ErrReverifyNoFilter)
+	}
+	report, err := v.Reverify(ReverifyOptions{All: true, Archive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total != 7 || report.Fixed != 2 || report.Broken != 1 || report.Inconclusive != 2 || report.Failed != 2 {
+		t.Fatalf("report: got total %d, fixed %d, broken %d, inconclusive %d, failed %d, want 7, 2, 1, 2, 2",
+			report.Total, report.Fixed, report.Broken, report.Inconclusive, report.Failed)
+	}
+	for _, rc := range report.Cases {
+		s := stored[ids[rc.ID]]
+		if rc.State != s.wantState || rc.Result != s.wantResult {
+			t.Errorf("%s: got %s %s, want %s %s", s.url, rc.State, rc.Result, s.wantState, s.wantResult)
+		}
+		if (rc.Error != "") != (s.wantState == StateFetchError || s.wantResult == ReverifyFailed) {
+			t.Errorf("%s: unexpected error %q", s.url, rc.Error)
+		}
+		// only fixed ones are archived
+		archived := rc.Result == ReverifyFixed
+		if rc.Archived != archived {
+			t.Errorf("%s: archived: got %v, want %v", s.url, rc.Archived, archived)
+		}
+		if _, ok := m.Read(archivePrefix + caseKey(rc.ID, caseKeyMeta)); ok != archived {
+			t.Errorf("%s: archived in storage: got %v, want %v", s.url, ok, archived)
+		}
+	}
+	if _, ok := m.Read(reportPrefix + "reverify-" + report.ID); !ok {
+		t.Fatal("report is not stored")
+	}
+	res, err := index.Search(index.Query{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Cases) != 5 {
+		t.Fatalf("indexed cases after archiving: got %d, want 5", len(res.Cases))
+	}
+}
+
+func TestReverifyWithoutIndex(t *testing.T) {
+	baseline := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "a")
+	}))
+	defer baseline.Close()
+	setBaseline(t, baseline)
+	setTest(t, baseline)
+	setStorage(t)
+	old := index.DefaultIndex
+	index.DefaultIndex = nil
+	t.Cleanup(func() { index.DefaultIndex = old })
+
+	now := time.Now().UTC()
+	for _, u := range []string{"/a", "/b", "/a/c"} {
+		if err := WriteCase(newCase(now, u, StateContentNotMatch)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// cases are filtered by walking storage
+	report, err := NewValidator(Options{}).Reverify(ReverifyOptions{Query: index.Query{PathPrefix: "/a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total != 2 || report.Fixed != 2 {
+		t.Fatalf("report: got total %d, fixed %d, want 2, 2", report.Total, report.Fixed)
+	}
+	for _, rc := range report.Cases {
+		if !strings.HasPrefix(rc.URL, "/a") || rc.Archived {
+			t.Errorf("%s: got archived %v, want not archived /a cases only", rc.URL, rc.Archived)
+		}
+	}
+}
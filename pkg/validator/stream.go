@@ -0,0 +1,361 @@
+package validator
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+	"github.com/bocchi-the-cache/inspector/pkg/monitor"
+)
+
+const (
+	defaultChunkSize    = 64 * 1024        // 64KB
+	defaultKeepBodySize = 10 * 1024 * 1024 // 10MB
+)
+
+// bodyReader reads a body chunk by chunk, hashing it incrementally,
+// and keeps the whole body only if it is not larger than keep.
+type bodyReader struct {
+	r    io.Reader
+	h    hash.Hash
+	keep int64
+	buf  []byte
+	body []byte
+	size int64
+	eof  bool
+	// stopped before EOF, the rest of body is not read
+	stopped bool
+	err     error
+	// body is decoded by Content-Encoding while reading, raw is the body as received, hashed by rawHash
+	decoded bool
+	raw     *recordReader
+	rawHash hash.Hash
+	// doneAt is when reading is done
+	doneAt time.Time
+}
+
+func newBodyReader(r io.Reader, chunkSize int, keep int64) *bodyReader {
+	return &bodyReader{
+		r:    r,
+		h:    md5.New(),
+		keep: keep,
+		buf:  make([]byte, chunkSize),
+	}
+}
+
+// next reads up to n bytes, n <= chunk size.
+func (b *bodyReader) next(n int) []byte {
+	if b.done() {
+		return nil
+	}
+	m, err := io.ReadFull(b.r, b.buf[:n])
+	chunk := b.buf[:m]
+	b.h.Write(chunk)
+	if b.size+int64(m) <= b.keep {
+		b.body = append(b.body, chunk...)
+	}
+	b.size += int64(m)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		b.eof = true
+		if b.decoded {
+			// hash the rest of the body as received, eg: bytes after the end of a gzip stream
+			if _, err = io.Copy(io.Discard, b.raw); err != nil {
+				b.err = err
+			}
+		}
+	} else if err != nil {
+		if b.decoded && b.raw.err == nil {
+			// the body is received well, but can't be decoded
+			err = &decodeError{err: err}
+		}
+		b.err = err
+	}
+	if b.done() {
+		b.doneAt = time.Now()
+	}
+	return chunk
+}
+
+func (b *bodyReader) done() bool {
+	return b.eof || b.stopped || b.err != nil
+}
+
+// stop stops reading, only when the kept body is already useless.
+func (b *bodyReader) stop() {
+	if b.size > b.keep && !b.done() {
+		b.stopped = true
+		b.doneAt = time.Now()
+	}
+}
+
+// content returns the content read, the request was sent at start.
+func (b *bodyReader) content(resp *http.Response, start time.Time) *client.Content {
+	if b.doneAt.IsZero() {
+		// left unread after the first difference
+		b.doneAt = time.Now()
+	}
+	c := &client.Content{
+		Status:  resp.StatusCode,
+		Header:  resp.Header,
+		Size:    b.size,
+		Decoded: b.decoded,
+		Latency: b.doneAt.Sub(start),
+	}
+	if b.eof && b.decoded {
+		c.MD5 = hex.EncodeToString(b.rawHash.Sum(nil))
+		c.DecodedMD5 = hex.EncodeToString(b.h.Sum(nil))
+		c.RawSize = b.raw.n
+	} else if b.eof {
+		c.MD5 = hex.EncodeToString(b.h.Sum(nil))
+	}
+	c.Content = b.body
+	if b.eof && b.size <= b.keep {
+		if c.Content == nil {
+			c.Content = []byte{}
+		}
+	} else {
+		// the prefix is kept for diffs
+		c.Truncated = true
+	}
+	return c
+}
+
+// streamCompare reads baseline and test bodies chunk by chunk in lockstep, and hashes them incrementally.
+// A test body stops reading on its first difference, so does baseline when all tests differ,
+// unless mismatches are confirmed by re-fetching, or noise is learned, which compare against the whole baseline md5.
+// offsets are the first differing offsets of each test, -1 if no difference found.
+func (v *Validator) streamCompare(baseline *bodyReader, tests []*bodyReader) []int64 {
+	offsets := make([]int64, len(tests))
+	for i := range offsets {
+		offsets[i] = -1
+	}
+	for !baseline.done() {
+		pos := baseline.size
+		bChunk := baseline.next(len(baseline.buf))
+		allDiffer := true
+		for i, t := range tests {
+			if t == nil || t.done() {
+				continue
+			}
+			tChunk := t.next(len(bChunk))
+			if offsets[i] < 0 {
+				if idx := firstDiff(bChunk, tChunk); idx >= 0 {
+					offsets[i] = pos + int64(idx)
+				}
+			}
+			if offsets[i] >= 0 {
+				// early exit
+				t.stop()
+			} else {
+				allDiffer = false
+			}
+		}
+		if allDiffer && v.confirmRetries == 0 && v.noise == nil {
+			baseline.stop()
+		}
+	}
+
+	// baseline reaches the end, test may be longer
+	for i, t := range tests {
+		if t == nil {
+			continue
+		}
+		for !t.done() {
+			pos := t.size
+			if chunk := t.next(len(t.buf)); len(chunk) > 0 && offsets[i] < 0 && baseline.eof {
+				offsets[i] = pos
+			}
+			if offsets[i] >= 0 {
+				t.stop()
+			}
+		}
+	}
+	return offsets
+}
+
+// firstDiff returns the first differing index, or -1 if equal.
+func firstDiff(b, t []byte) int {
+	n := len(b)
+	if len(t) < n {
+		n = len(t)
+	}
+	for i := 0; i < n; i++ {
+		if b[i] != t[i] {
+			return i
+		}
+	}
+	if len(b) != len(t) {
+		return n
+	}
+	return -1
+}
+
+// validateStream validates in stream mode, baseline is fetched unless it's given by proxy mode.
+func (v *Validator) validateStream(r *client.Request, BaselineContent *client.Content, errBaseline error, fetchBaseline bool) {
+	wg := sync.WaitGroup{}
+	var baselineResp *http.Response
+	var baselineStart time.Time
+	if fetchBaseline {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t := time.Now()
+			baselineStart = t
+			baselineResp, errBaseline = client.BaselineFetcher.Open(r)
+			elapsed := time.Since(t)
+			monitor.ElapsedMonitorIncr("BaselineFetch", client.BaselineName, float64(elapsed/10e6))
+		}()
+	}
+	testResps := make([]*http.Response, len(client.TestFetchers))
+	errTests := make([]error, len(client.TestFetchers))
+	testStarts := make([]time.Time, len(client.TestFetchers))
+	// secondary is read on its own, it's not compared in lockstep with tests
+	secondaryWg := sync.WaitGroup{}
+	secondary := v.getSecondaryContent(r, &secondaryWg)
+	for i, f := range client.TestFetchers {
+		wg.Add(1)
+		go func(i int, f *client.Fetcher) {
+			defer wg.Done()
+			t := time.Now()
+			testStarts[i] = t
+			testResps[i], errTests[i] = f.Open(r)
+			elapsed := time.Since(t)
+			monitor.ElapsedMonitorIncr("TestFetch", f.Name, float64(elapsed/10e6))
+		}(i, f)
+	}
+	wg.Wait()
+	defer func() {
+		if baselineResp != nil {
+			baselineResp.Body.Close()
+		}
+		for _, resp := range testResps {
+			if resp != nil {
+				resp.Body.Close()
+			}
+		}
+	}()
+
+	var baseline *bodyReader
+	if fetchBaseline && errBaseline == nil {
+		baseline = v.newBodyReader(baselineResp.Body, baselineResp.Header)
+	} else if errBaseline == nil {
+		baseline = v.newBodyReader(bytes.NewReader(BaselineContent.Content), BaselineContent.Header)
+	} else {
+		baseline = v.newBodyReader(bytes.NewReader(nil), nil)
+	}
+	tests := make([]*bodyReader, len(testResps))
+	for i, resp := range testResps {
+		if errTests[i] == nil {
+			tests[i] = v.newBodyReader(resp.Body, resp.Header)
+		}
+	}
+
+	offsets := v.streamCompare(baseline, tests)
+
+	if fetchBaseline && errBaseline == nil {
+		BaselineContent, errBaseline = baseline.content(baselineResp, baselineStart), baseline.err
+	}
+	TestContents := make([]*client.Content, len(tests))
+	for i, t := range tests {
+		if t != nil {
+			TestContents[i], errTests[i] = t.content(testResps[i], testStarts[i]), t.err
+		}
+	}
+	if errBaseline != nil {
+		// offsets are meaningless without baseline
+		offsets = nil
+	}
+	secondaryWg.Wait()
+	v.report(r, BaselineContent, errBaseline, secondary, TestContents, errTests, offsets)
+}
+
+// streamContent fetches content in stream mode, body is hashed and kept only if it's small.
+func (v *Validator) streamContent(f *client.Fetcher, r *client.Request) (*client.Content, error) {
+	start := time.Now()
+	resp, err := f.Open(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b := v.newBodyReader(resp.Body, resp.Header)
+	for !b.done() {
+		b.next(len(b.buf))
+	}
+	if b.err != nil && !isDecodeError(b.err) {
+		return nil, b.err
+	}
+	return b.content(resp, start), b.err
+}
+
+// newBodyReader decodes body by Content-Encoding while reading, unless in strict mode.
+// Body which can't be decoded is read as it is, from the beginning.
+func (v *Validator) newBodyReader(r io.Reader, header http.Header) *bodyReader {
+	if v.strictEncoding || header == nil {
+		return newBodyReader(r, v.chunkSize, v.keepBodySize)
+	}
+	encodings := contentEncodings(&client.Content{Header: header})
+	if len(encodings) == 0 {
+		return newBodyReader(r, v.chunkSize, v.keepBodySize)
+	}
+	rawHash := md5.New()
+	rec := &recordReader{r: io.TeeReader(r, rawHash)}
+	dr, err := newDecodeReader(rec, encodings)
+	if err != nil {
+		// decoders may have consumed the header already
+		return newBodyReader(io.MultiReader(bytes.NewReader(rec.buf), r), v.chunkSize, v.keepBodySize)
+	}
+	rec.off, rec.buf = true, nil
+	b := newBodyReader(dr, v.chunkSize, v.keepBodySize)
+	b.decoded = true
+	b.raw, b.rawHash = rec, rawHash
+	return b
+}
+
+// recordReader records bytes read until it's turned off, so bytes read by a failed decoder can be read again.
+type recordReader struct {
+	r   io.Reader
+	buf []byte
+	off bool
+	// n is the number of bytes read
+	n int64
+	// err is the error of reading the body, other than EOF
+	err error
+}
+
+func (r *recordReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	if !r.off {
+		r.buf = append(r.buf, p[:n]...)
+	}
+	return n, err
+}
+
+// decodeError means body is received, but can't be decoded by its Content-Encoding while reading.
+// Its content is still returned, and it's reported as DECODE_ERROR instead of FETCH_ERROR.
+type decodeError struct {
+	err error
+}
+
+func (e *decodeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *decodeError) Unwrap() error {
+	return e.err
+}
+
+func isDecodeError(err error) bool {
+	var de *decodeError
+	return errors.As(err, &de)
+}
@@ -0,0 +1,86 @@
+package validator
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+)
+
+// gzipped returns body gzipped, its crc32 is broken if corrupt.
+func gzipped(body string, corrupt bool) []byte {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	_, _ = io.WriteString(w, body)
+	_ = w.Close()
+	b := buf.Bytes()
+	if corrupt {
+		b[len(b)-8] ^= 0xff
+	}
+	return b
+}
+
+// brokenReader returns data, then fails as a broken connection.
+type brokenReader struct {
+	r io.Reader
+}
+
+func (b *brokenReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err == io.EOF {
+		return n, io.ErrClosedPipe
+	}
+	return n, err
+}
+
+func TestStreamDecodeError(t *testing.T) {
+	body := "hello, inspector"
+	gzipHeader := http.Header{"Content-Encoding": {"gzip"}}
+	tests := []struct {
+		name          string
+		body          io.Reader
+		header        http.Header
+		wantErr       bool
+		wantDecodeErr bool
+		wantState     string
+	}{
+		{"identity", bytes.NewReader([]byte(body)), http.Header{}, false, false, StatePass},
+		{"gzip", bytes.NewReader(gzipped(body, false)), gzipHeader, false, false, StatePass},
+		{"corrupt gzip", bytes.NewReader(gzipped(body, true)), gzipHeader, true, true, StateDecodeError},
+		{"connection broken", &brokenReader{bytes.NewReader(gzipped(body, false)[:10])}, gzipHeader, true, false, StateFetchError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewValidator(Options{Stream: true, ChunkSize: 4, KeepBodySize: 4})
+			r := &client.Request{Method: http.MethodGet, Host: "a.com", URL: "/a", Header: http.Header{}}
+			resp := &http.Response{StatusCode: http.StatusOK, Header: tt.header}
+			b := v.newBodyReader(tt.body, tt.header)
+			for !b.done() {
+				b.next(len(b.buf))
+			}
+			if (b.err != nil) != tt.wantErr || isDecodeError(b.err) != tt.wantDecodeErr {
+				t.Fatalf("read body: got error %v, want error %v, decode error %v", b.err, tt.wantErr, tt.wantDecodeErr)
+			}
+
+			baseline := &client.Content{Status: http.StatusOK, Header: http.Header{}, Content: []byte(body), Size: int64(len(body))}
+			res := v.check(r, nil, baseline, nil, b.content(resp, time.Now()), b.err)
+			if res.state != tt.wantState {
+				t.Fatalf("check: got %s, want %s, reason: %s", res.state, tt.wantState, res.verdict.Reason)
+			}
+			// the same as comparing whole bodies
+			if tt.wantDecodeErr {
+				whole := &client.Content{Status: http.StatusOK, Header: tt.header, Content: gzipped(body, true)}
+				if got := v.check(r, nil, baseline, nil, whole, nil).state; got != StateDecodeError {
+					t.Fatalf("check whole body: got %s, want %s", got, StateDecodeError)
+				}
+				if rc := v.newResultContent(whole, b.err); rc.Error != "" || rc.ErrorClass != "" {
+					t.Fatalf("result content: got error %q, class %q, want none", rc.Error, rc.ErrorClass)
+				}
+			}
+		})
+	}
+}
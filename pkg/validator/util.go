@@ -17,7 +17,7 @@ var noCacheHeaders = map[string]struct{}{
 	"max-age=0":       {},
 }
 
-func isRangeRequest(r *http.Request) bool {
+func isRangeRequest(r *client.Request) bool {
 	return r.Header.Get("Range") != ""
 }
 
@@ -1,14 +1,22 @@
 package validator
 
 import (
+	"bytes"
 	"crypto/md5"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"github.com/bocchi-the-cache/inspector/pkg/client"
 	"github.com/bocchi-the-cache/inspector/pkg/common/logger"
 	"github.com/bocchi-the-cache/inspector/pkg/common/result_logger"
+	"github.com/bocchi-the-cache/inspector/pkg/filter"
+	"github.com/bocchi-the-cache/inspector/pkg/index"
 	"github.com/bocchi-the-cache/inspector/pkg/monitor"
-	"github.com/bocchi-the-cache/inspector/pkg/storage"
+	"github.com/bocchi-the-cache/inspector/pkg/spool"
+	"github.com/spf13/viper"
+	"io"
 	"net/http"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -16,56 +24,514 @@ import (
 
 var DefaultValidator *Validator
 
-func init() {
-	DefaultValidator = &Validator{}
+// States of checking result, in checking order.
+const (
+	StateFetchError      = "FETCH_ERROR"
+	StateStatusNotMatch  = "STATUS_NOT_MATCH"
+	StateEmptyContent    = "EMPTY_CONTENT"
+	StateStatusSkip      = "STATUS_NOT_200/206_SKIP"
+	StateContentNotMatch = "CONTENT_NOT_MATCH"
+	// StateBaselineUnstable means baseline content changes between re-fetches on mismatch.
+	StateBaselineUnstable = "BASELINE_UNSTABLE"
+	// StateTestUnstable means test content matches baseline sometimes on re-fetches.
+	StateTestUnstable = "TEST_UNSTABLE"
+	// StateDecodeError means body can't be decoded by its Content-Encoding.
+	StateDecodeError = "DECODE_ERROR"
+	// StateRangeInvalid means 206 response of test doesn't satisfy the Range request.
+	StateRangeInvalid = "RANGE_INVALID"
+	StatePass         = "PASS"
+)
+
+func Init() {
+	var sp *spool.Spool
+	var err error
+	if viper.GetBool("spool.enable") {
+		sp, err = spool.Open(viper.GetString("spool.path"), viper.GetBool("spool.sync"))
+		if err != nil {
+			logger.Panicf("open spool error, path: %s, err: %s", viper.GetString("spool.path"), err)
+		}
+	}
+	flt, err := filter.NewFromConfig()
+	if err != nil {
+		logger.Panicf("init request filter error, err: %s", err)
+	}
+	normalize := map[string][]string{}
+	for h := range viper.GetStringMap("validator.header.normalize") {
+		normalize[h] = viper.GetStringSlice("validator.header.normalize." + h)
+	}
+	var jsonMasks []JSONMask
+	if err = viper.UnmarshalKey("validator.json.masks", &jsonMasks); err != nil {
+		logger.Panicf("parse json masks error, err: %s", err)
+	}
+	var comparatorRules []ComparatorRule
+	if err = viper.UnmarshalKey("validator.comparators", &comparatorRules); err != nil {
+		logger.Panicf("parse comparator rules error, err: %s", err)
+	}
+	DefaultValidator = NewValidator(Options{
+		Workers:         viper.GetInt("validator.workers"),
+		QueueSize:       viper.GetInt("validator.queue_size"),
+		QueueFullPolicy: viper.GetString("validator.queue_full_policy"),
+		SampleRate:      viper.GetFloat64("validator.sample_rate"),
+		Methods:         viper.GetStringSlice("validator.methods"),
+		MaxBodySize:     viper.GetInt64("validator.max_body_size"),
+		Spool:           sp,
+		Filter:          flt,
+		ConfirmRetries:  viper.GetInt("validator.confirm.retries"),
+		ConfirmTest:     viper.GetBool("validator.confirm.refetch_test"),
+		Stream:          viper.GetBool("validator.stream.enable"),
+		ChunkSize:       viper.GetInt("validator.stream.chunk_size"),
+		KeepBodySize:    viper.GetInt64("validator.stream.keep_body_size"),
+		StrictEncoding:  viper.GetBool("validator.encoding.strict"),
+		Assembly: AssemblyOptions{
+			Enable:    viper.GetBool("validator.range_assembly.enable"),
+			MinSize:   viper.GetInt64("validator.range_assembly.min_size"),
+			ChunkSize: viper.GetInt64("validator.range_assembly.chunk_size"),
+			Order:     viper.GetString("validator.range_assembly.order"),
+		},
+		JSON: JSONOptions{
+			Enable: viper.GetBool("validator.json.enable"),
+			Masks:  jsonMasks,
+		},
+		Comparators: comparatorRules,
+		Noise: NoiseOptions{
+			// enabled if secondary baseline is set
+			Enable:   client.SecondaryFetcher != nil,
+			Patterns: viper.GetStringSlice("validator.noise.patterns"),
+			MinCount: viper.GetInt("validator.noise.min_count"),
+			TTL:      viper.GetDuration("validator.noise.ttl"),
+		},
+		Header: HeaderOptions{
+			Enable:    viper.GetBool("validator.header.enable"),
+			Match:     viper.GetStringSlice("validator.header.match"),
+			Ignore:    viper.GetStringSlice("validator.header.ignore"),
+			Normalize: normalize,
+		},
+		Diff: DiffOptions{
+			Enable:       viper.GetBool("validator.diff.enable"),
+			Context:      viper.GetInt("validator.diff.context"),
+			Window:       viper.GetInt("validator.diff.window"),
+			MaxLines:     viper.GetInt("validator.diff.max_lines"),
+			MaxLineBytes: viper.GetInt("validator.diff.max_line_bytes"),
+		},
+		ResultHeaders: viper.GetStringSlice("validator.result.headers"),
+	})
+	DefaultValidator.Start()
+
+	if index.Enabled() && index.DefaultIndex.Empty() {
+		go func() {
+			if err := RebuildIndex(); err != nil {
+				logger.Errorf("rebuild case index error, err: %s", err)
+			}
+		}()
+	}
+
+	go NewJanitor(RetentionOptions{
+		MaxSize:        viper.GetInt64("storage.retention.max_size"),
+		MaxAge:         viper.GetDuration("storage.retention.max_age"),
+		MaxCasesPerURL: viper.GetInt("storage.retention.max_cases_per_url"),
+		Interval:       viper.GetDuration("storage.retention.interval"),
+	}).Run()
+}
+
+type Options struct {
+	Workers         int
+	QueueSize       int
+	QueueFullPolicy string
+	SampleRate      float64
+	// Methods is the allowlist of http methods to validate, GET only by default.
+	Methods []string
+	// MaxBodySize is the max request body size to buffer, in bytes.
+	MaxBodySize int64
+	// Spool keeps accepted requests on disk until validated, nil means disabled.
+	Spool *spool.Spool
+	// Filter filters out requests which should not be validated, nil means no filter.
+	Filter *filter.Chain
+	// ConfirmRetries is how many times to re-fetch baseline on content mismatch, 0 means disabled.
+	ConfirmRetries int
+	// ConfirmTest re-fetches test as well on content mismatch.
+	ConfirmTest bool
+	// Stream compares bodies chunk by chunk while reading, instead of reading whole bodies into memory.
+	Stream    bool
+	ChunkSize int
+	// KeepBodySize is the max body size kept in memory for storage in stream mode, in bytes.
+	KeepBodySize int64
+	// StrictEncoding compares encoded bodies, instead of decoding them by Content-Encoding.
+	StrictEncoding bool
+	// Assembly verifies large objects by pulling them from test as byte ranges.
+	Assembly AssemblyOptions
+	// JSON compares json bodies structurally.
+	JSON JSONOptions
+	// Comparators select comparator chains by content type or url, "default" chain if none matches.
+	Comparators []ComparatorRule
+	// Noise learns differences between baseline and secondary baseline, and suppresses them.
+	Noise NoiseOptions
+	// Header compares headers by policy, when bodies are the same, or statuses are the same but not 200/206.
+	Header HeaderOptions
+	// Diff stores a diff artifact with CONTENT_NOT_MATCH cases.
+	Diff DiffOptions
+	// ResultHeaders are response headers in result records.
+	ResultHeaders []string
+}
+
+type Validator struct {
+	workers     int
+	queue       chan *task
+	policy      string
+	sampleRate  float64
+	methods     map[string]struct{}
+	maxBodySize int64
+	spool       *spool.Spool
+	// recovered are requests left in spool by the last run
+	recovered []spool.Entry
+	filter    *filter.Chain
+
+	confirmRetries int
+	confirmTest    bool
+
+	stream       bool
+	chunkSize    int
+	keepBodySize int64
+
+	strictEncoding bool
+
+	assembly AssemblyOptions
+	header   *headerPolicy
+	json     *jsonComparator
+
+	jsonEnable      bool
+	comparatorRules []comparatorRule
+	noise           *noiseLearner
+
+	diff          DiffOptions
+	resultHeaders []string
+}
+
+func NewValidator(opt Options) *Validator {
+	if opt.Workers <= 0 {
+		opt.Workers = defaultWorkers
+	}
+	if opt.QueueSize <= 0 {
+		opt.QueueSize = defaultQueueSize
+	}
+	switch opt.QueueFullPolicy {
+	case PolicyDrop, PolicyBlock, PolicySample:
+	case "":
+		opt.QueueFullPolicy = PolicyDrop
+	default:
+		logger.Panicf("unknown queue full policy: %s", opt.QueueFullPolicy)
+	}
+	if len(opt.Methods) == 0 {
+		opt.Methods = []string{http.MethodGet}
+	}
+	if opt.MaxBodySize <= 0 {
+		opt.MaxBodySize = defaultMaxBodySize
+	}
+	if opt.ChunkSize <= 0 {
+		opt.ChunkSize = defaultChunkSize
+	}
+	if opt.KeepBodySize <= 0 {
+		opt.KeepBodySize = defaultKeepBodySize
+	}
+	if opt.Assembly.ChunkSize <= 0 {
+		opt.Assembly.ChunkSize = defaultAssemblyChunkSize
+	}
+	if opt.ResultHeaders == nil {
+		opt.ResultHeaders = defaultResultHeaders
+	}
+	if opt.Diff.Context <= 0 {
+		opt.Diff.Context = defaultDiffContext
+	}
+	if opt.Diff.Window <= 0 {
+		opt.Diff.Window = defaultDiffWindow
+	}
+	if opt.Diff.MaxLines <= 0 {
+		opt.Diff.MaxLines = defaultDiffMaxLines
+	}
+	if opt.Diff.MaxLineBytes <= 0 {
+		opt.Diff.MaxLineBytes = defaultDiffMaxLineBytes
+	}
+	switch opt.Assembly.Order {
+	case AssemblySequential, AssemblyRandom:
+	case "":
+		opt.Assembly.Order = AssemblySequential
+	default:
+		logger.Panicf("unknown range assembly order: %s", opt.Assembly.Order)
+	}
+
+	header, err := newHeaderPolicy(opt.Header, opt.StrictEncoding)
+	if err != nil {
+		logger.Panicf("init header policy error, err: %s", err)
+	}
+
+	jsonCmp, err := newJSONComparator(opt.JSON)
+	if err != nil {
+		logger.Panicf("init json comparator error, err: %s", err)
+	}
+
+	noise, err := newNoiseLearner(opt.Noise)
+	if err != nil {
+		logger.Panicf("init noise learner error, err: %s", err)
+	}
+
+	methods := make(map[string]struct{}, len(opt.Methods))
+	for _, m := range opt.Methods {
+		methods[strings.ToUpper(m)] = struct{}{}
+	}
+	var recovered []spool.Entry
+	if opt.Spool != nil {
+		recovered = opt.Spool.Pending()
+	}
+	v := &Validator{
+		workers:     opt.Workers,
+		queue:       make(chan *task, opt.QueueSize),
+		policy:      opt.QueueFullPolicy,
+		sampleRate:  opt.SampleRate,
+		methods:     methods,
+		maxBodySize: opt.MaxBodySize,
+		spool:       opt.Spool,
+		recovered:   recovered,
+		filter:      opt.Filter,
+
+		confirmRetries: opt.ConfirmRetries,
+		confirmTest:    opt.ConfirmTest,
+
+		stream:       opt.Stream,
+		chunkSize:    opt.ChunkSize,
+		keepBodySize: opt.KeepBodySize,
+
+		strictEncoding: opt.StrictEncoding,
+
+		assembly: opt.Assembly,
+		header:   header,
+		json:     jsonCmp,
+
+		jsonEnable: opt.JSON.Enable,
+		noise:      noise,
+
+		diff:          opt.Diff,
+		resultHeaders: opt.ResultHeaders,
+	}
+	if err = v.initComparators(opt.Comparators); err != nil {
+		logger.Panicf("init comparators error, err: %s", err)
+	}
+	return v
+}
+
+// Methods returns http methods which will be validated.
+func Methods() []string {
+	return DefaultValidator.Methods()
 }
 
-type Validator struct{}
+func (v *Validator) Methods() []string {
+	methods := make([]string, 0, len(v.methods))
+	for m := range v.methods {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	return methods
+}
 
 func PushRequest(r *http.Request) {
 	DefaultValidator.PushRequest(r)
 }
 
+// PushRequest buffers the request body, and pushes the request into validate queue.
+// It must be called before the handler returns, as r.Body is not readable after that.
+// Requests are filtered before buffering, bodies of filtered out requests are not read.
 func (v *Validator) PushRequest(r *http.Request) {
-	if ok := v.CheckRequest(r); ok {
-		go v.Validate(r)
+	req := client.NewRequestHead(r)
+	if ok := v.CheckRequest(req); !ok {
+		return
 	}
+	if err := req.ReadBody(r.Body, v.maxBodySize); err != nil {
+		logger.Errorf("buffer request body error, host: %s, url: %s, err: %s", r.Host, r.URL, err)
+		monitor.RequestDropTotalCounterIncr(r.Method, dropReason(err))
+		return
+	}
+	v.enqueue(&task{r: req}, v.policy)
+}
+
+func Push(r *client.Request) bool {
+	return DefaultValidator.Push(r)
 }
 
-func (v *Validator) CheckRequest(r *http.Request) bool {
-	if r.Method != http.MethodGet {
+// Push pushes a buffered request into validate queue, false if it's filtered out.
+// It's for replaying requests, which waits while the queue is full instead of dropping, whatever the policy is.
+func (v *Validator) Push(r *client.Request) bool {
+	if ok := v.CheckRequest(r); !ok {
 		return false
 	}
-	return true
+	return v.enqueue(&task{r: r}, PolicyBlock)
+}
+
+func CheckRequest(r *client.Request) bool {
+	return DefaultValidator.CheckRequest(r)
 }
 
-// isIncompleteRangeRequest false when "" or "Range: <unit>=<range-start>-<range-end>"
-func isIncompleteRangeRequest(r *http.Request) bool {
-	if r.Header.Get("Range") == "" {
+// CheckRequest checks whether the request should be validated, filtered out requests are counted with reason.
+func (v *Validator) CheckRequest(r *client.Request) bool {
+	if !v.CheckMethod(r.Method) {
 		return false
 	}
-	rg := strings.Trim(r.Header.Get("Range"), " ")
-	if strings.Contains(rg, ",") || // eg: Range: bytes=0-1,2-3
-		strings.Contains(rg, "=-") || // eg: Range: bytes=-1
-		strings.HasSuffix(rg, "-") { // eg: Range: bytes=0-
-		logger.Info("incompleted range request, range: %s", r.Header.Get("Range"))
-		return true
+	if reason := v.filter.Check(r); reason != "" {
+		monitor.RequestFilterTotalCounterIncr(r.Method, reason)
+		return false
+	}
+	return true
+}
+
+func (v *Validator) CheckMethod(method string) bool {
+	_, ok := v.methods[method]
+	return ok
+}
+
+func dropReason(err error) string {
+	if errors.Is(err, client.ErrBodyTooLarge) {
+		return "BodyTooLarge"
+	}
+	return "ErrorReadBody"
+}
+
+// ProxyRequest streams baseline response to the caller, and validates test content in background.
+func ProxyRequest(w http.ResponseWriter, r *http.Request) error {
+	return DefaultValidator.ProxyRequest(w, r)
+}
+
+// ProxyRequest filters the request before buffering its body. Bodies of filtered out requests, or larger than
+// max_body_size, are streamed to baseline without buffering, and such requests are not validated.
+// Baseline response is copied to the caller as it's read, and kept for validating up to keep_body_size,
+// larger responses are not validated.
+// An error is returned only if nothing is written to the caller, who should answer it with 502.
+func (v *Validator) ProxyRequest(w http.ResponseWriter, r *http.Request) error {
+	req := client.NewRequestHead(r)
+	if ok := v.CheckRequest(req); !ok {
+		_, err := v.proxyBaseline(w, req, r.Body, r.ContentLength)
+		return err
+	}
+	err := req.ReadBody(r.Body, v.maxBodySize)
+	if errors.Is(err, client.ErrBodyTooLarge) {
+		monitor.RequestDropTotalCounterIncr(r.Method, dropReason(err))
+		_, err = v.proxyBaseline(w, req, io.MultiReader(bytes.NewReader(req.Body), r.Body), r.ContentLength)
+		return err
+	}
+	if err != nil {
+		monitor.RequestDropTotalCounterIncr(r.Method, dropReason(err))
+		return err
+	}
+
+	BaselineContent, err := v.proxyBaseline(w, req, nil, 0)
+	// real clients are waiting for their responses, requests are dropped on a full queue whatever the policy is
+	var errBaseline *baselineError
+	switch {
+	case errors.As(err, &errBaseline):
+		// baseline failed before or while sending the body, test is still validated against the error
+		v.enqueue(&task{r: req, withBaseline: true, errBaseline: errBaseline.err}, PolicyDrop)
+		if BaselineContent == nil {
+			return err
+		}
+	case err != nil:
+		// the caller is gone, baseline body is not read to the end
+		logger.Infof("write response to caller error, host: %s, url: %s, err: %s", req.Host, req.URL, err)
+	case BaselineContent.Truncated:
+		monitor.RequestDropTotalCounterIncr(r.Method, "BaselineTooLarge")
+	default:
+		v.enqueue(&task{r: req, withBaseline: true, baseline: BaselineContent}, PolicyDrop)
 	}
-	return false
+	return nil
+}
+
+// baselineError is an error of fetching or reading baseline, as opposed to writing to the caller.
+type baselineError struct {
+	err error
+}
+
+func (e *baselineError) Error() string {
+	return e.err.Error()
+}
+
+func (e *baselineError) Unwrap() error {
+	return e.err
 }
 
-func (v *Validator) Validate(r *http.Request) {
+// proxyBaseline sends r to baseline with body instead of r.Body if body is not nil, size is -1 if unknown,
+// and copies the response to w. Content is nil if the response is not written to w at all.
+// The body is kept up to keep_body_size, Content is truncated to nil if it's larger.
+func (v *Validator) proxyBaseline(w http.ResponseWriter, r *client.Request, body io.Reader, size int64) (*client.Content, error) {
+	t := time.Now()
+	var resp *http.Response
+	var err error
+	if body != nil {
+		resp, err = client.BaselineFetcher.OpenWithBody(r, body, size)
+	} else {
+		resp, err = client.BaselineFetcher.Open(r)
+	}
+	if err != nil {
+		return nil, &baselineError{err}
+	}
+	defer resp.Body.Close()
+
+	for k, vv := range resp.Header {
+		for _, hv := range vv {
+			w.Header().Add(k, hv)
+		}
+	}
+	for _, h := range client.HopHeaders {
+		w.Header().Del(h)
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	c := &client.Content{Status: resp.StatusCode, Header: resp.Header, Content: []byte{}}
+	// bytes are sent to the caller as they arrive, not when the response writer's buffer is full
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, v.chunkSize)
+	for {
+		n, errRead := resp.Body.Read(buf)
+		if n > 0 {
+			c.Size += int64(n)
+			if c.Size <= v.keepBodySize {
+				c.Content = append(c.Content, buf[:n]...)
+			} else {
+				c.Content, c.Truncated = nil, true
+			}
+			if _, err = w.Write(buf[:n]); err != nil {
+				return c, err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if errRead == io.EOF {
+			break
+		}
+		if errRead != nil {
+			return c, &baselineError{errRead}
+		}
+	}
+	c.Latency = time.Since(t)
+	monitor.ElapsedMonitorIncr("BaselineFetch", client.BaselineName, float64(c.Latency/10e6))
+	return c, nil
+}
+
+// Validate fetches and compares the request synchronously, bypassing validate queue.
+func Validate(r *client.Request) {
+	DefaultValidator.Validate(r)
+}
+
+func (v *Validator) Validate(r *client.Request) {
 	defer handlePanic()
 	monitor.RequestReceiveTotalCounterIncr(r.Method, r.Host)
 
 	//host := r.Host // eg: localhost:4399
-	//url := r.URL   // eg: /blabla/123/abc.txt
+	//url := r.URL   // eg: /blabla/123/abc.txt?a=b
+
+	if v.stream {
+		v.validateStream(r, nil, nil, true)
+		return
+	}
 
 	wg := sync.WaitGroup{}
 	var BaselineContent *client.Content
 	var errBaseline error
-	var TestContent *client.Content
-	var errTest error
 
 	// Get Baseline Content
 	wg.Add(1)
@@ -74,51 +540,127 @@ func (v *Validator) Validate(r *http.Request) {
 		t := time.Now()
 		BaselineContent, errBaseline = GetBaselineContent(r)
 		elapsed := time.Since(t)
-		monitor.ElapsedMonitorIncr("BaselineFetch", float64(elapsed/10e6))
+		monitor.ElapsedMonitorIncr("BaselineFetch", client.BaselineName, float64(elapsed/10e6))
 	}()
 
-	// Get Test Content
+	// Get Secondary Content, if noise learning is enabled
+	secondary := v.getSecondaryContent(r, &wg)
+
+	// Get Test Contents
+	TestContents, errTests := getTestContents(r, &wg)
+
+	wg.Wait()
+	v.report(r, BaselineContent, errBaseline, secondary, TestContents, errTests, nil)
+}
+
+// ValidateWithBaseline validates test content against baseline content which has already been fetched.
+func (v *Validator) ValidateWithBaseline(r *client.Request, BaselineContent *client.Content, errBaseline error) {
+	defer handlePanic()
+	monitor.RequestReceiveTotalCounterIncr(r.Method, r.Host)
+
+	if v.stream {
+		v.validateStream(r, BaselineContent, errBaseline, false)
+		return
+	}
+
+	wg := sync.WaitGroup{}
+	secondary := v.getSecondaryContent(r, &wg)
+	TestContents, errTests := getTestContents(r, &wg)
+	wg.Wait()
+
+	v.report(r, BaselineContent, errBaseline, secondary, TestContents, errTests, nil)
+}
+
+// getTestContents fetches all test targets concurrently, results are ready after wg.Wait().
+func getTestContents(r *client.Request, wg *sync.WaitGroup) ([]*client.Content, []error) {
+	TestContents := make([]*client.Content, len(client.TestFetchers))
+	errTests := make([]error, len(client.TestFetchers))
+	for i, f := range client.TestFetchers {
+		wg.Add(1)
+		go func(i int, f *client.Fetcher) {
+			defer wg.Done()
+			t := time.Now()
+			TestContents[i], errTests[i] = GetTestContent(f, r)
+			elapsed := time.Since(t)
+			monitor.ElapsedMonitorIncr("TestFetch", f.Name, float64(elapsed/10e6))
+		}(i, f)
+	}
+	return TestContents, errTests
+}
+
+// secondaryContent is the fetching result of secondary baseline.
+type secondaryContent struct {
+	content *client.Content
+	err     error
+}
+
+// getSecondaryContent fetches secondary baseline concurrently if noise learning is enabled, result is ready after wg.Wait().
+func (v *Validator) getSecondaryContent(r *client.Request, wg *sync.WaitGroup) *secondaryContent {
+	if v.noise == nil {
+		return nil
+	}
+	s := &secondaryContent{}
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		t := time.Now()
-		TestContent, errTest = GetTestContent(r)
+		s.content, s.err = v.fetchContent(client.SecondaryFetcher, r)
 		elapsed := time.Since(t)
-		monitor.ElapsedMonitorIncr("TestFetch", float64(elapsed/10e6))
+		monitor.ElapsedMonitorIncr("SecondaryFetch", client.SecondaryName, float64(elapsed/10e6))
 	}()
+	return s
+}
 
-	wg.Wait()
-	if errBaseline != nil {
+// report checks and reports each test target, offsets are the first differing offsets found in stream mode, nil if unknown.
+// Noise between baseline and secondary is learned first, secondary is nil if not fetched.
+func (v *Validator) report(r *client.Request, BaselineContent *client.Content, errBaseline error, secondary *secondaryContent, TestContents []*client.Content, errTests []error, offsets []int64) {
+	if errBaseline != nil && !isDecodeError(errBaseline) {
 		logger.Errorf("get baseline content error, err: %s", errBaseline)
-		monitor.ErrorTotalCounterIncr("GetContent", "baseline", "errBaseline")
+		monitor.ErrorTotalCounterIncr("GetContent", client.BaselineName, "errBaseline")
+	}
+	if secondary != nil {
+		if secondary.err != nil {
+			logger.Errorf("get secondary content error, err: %s", secondary.err)
+			monitor.ErrorTotalCounterIncr("GetContent", client.SecondaryName, "errSecondary")
+		} else if errBaseline == nil {
+			v.learnNoise(r, BaselineContent, secondary.content)
+		}
 	}
-	if errTest != nil {
-		logger.Errorf("get test content error, err: %s", errTest)
-		monitor.ErrorTotalCounterIncr("GetContent", "test", "errTest")
+	for i, f := range client.TestFetchers {
+		if errTests[i] != nil && !isDecodeError(errTests[i]) {
+			logger.Errorf("get test content error, target: %s, err: %s", f.Name, errTests[i])
+			monitor.ErrorTotalCounterIncr("GetContent", f.Name, "errTest")
+		}
 	}
 
 	// Generate Report
-	t := time.Now()
-	CheckContentAndReport(r, BaselineContent, errBaseline, TestContent, errTest)
-	elapsed := time.Since(t)
-	monitor.ElapsedMonitorIncr("ContentCompare", float64(elapsed/10e6))
+	for i, f := range client.TestFetchers {
+		diffOffset := int64(-1)
+		if offsets != nil {
+			diffOffset = offsets[i]
+		}
+		t := time.Now()
+		v.CheckContentAndReport(r, f, BaselineContent, errBaseline, TestContents[i], errTests[i], diffOffset)
+		elapsed := time.Since(t)
+		monitor.ElapsedMonitorIncr("ContentCompare", f.Name, float64(elapsed/10e6))
+
+		if v.needAssembly(r, BaselineContent, errBaseline) {
+			t = time.Now()
+			v.CheckRangeAssembly(r, f, BaselineContent)
+			elapsed = time.Since(t)
+			monitor.ElapsedMonitorIncr("RangeAssembly", f.Name, float64(elapsed/10e6))
+		}
+	}
 }
 
-func GetBaselineContent(r *http.Request) (*client.Content, error) {
+func GetBaselineContent(r *client.Request) (*client.Content, error) {
 	// Don't Find in cache
-	status, header, content, err := client.BaselineFetcher.Do(r)
-	if err != nil {
-		return nil, err
-	}
-	return &client.Content{
-		Status:  status,
-		Header:  header,
-		Content: content,
-	}, nil
+	return GetTestContent(client.BaselineFetcher, r)
 }
 
-func GetTestContent(r *http.Request) (*client.Content, error) {
-	status, header, content, err := client.TestFetcher.Do(r)
+func GetTestContent(f *client.Fetcher, r *client.Request) (*client.Content, error) {
+	t := time.Now()
+	status, header, content, err := f.Do(r)
 	if err != nil {
 		return nil, err
 	}
@@ -126,93 +668,200 @@ func GetTestContent(r *http.Request) (*client.Content, error) {
 		Status:  status,
 		Header:  header,
 		Content: content,
+		Size:    int64(len(content)),
+		Latency: time.Since(t),
 	}, nil
 }
 
-func CheckContentAndReport(r *http.Request, BaselineContent *client.Content, errBaseline error, TestContent *client.Content, errTest error) {
-	state := "PASS"
+// checkResult is the result of checking test content against baseline content.
+type checkResult struct {
+	state      string
+	verdict    Verdict
+	headerDiff []string
+}
+
+// check compares test content of target f against baseline content, states are checked in order.
+func (v *Validator) check(r *client.Request, f *client.Fetcher, BaselineContent *client.Content, errBaseline error, TestContent *client.Content, errTest error) checkResult {
+	state := StatePass
+	var headerDiff []string
+	vd := Verdict{Offset: -1}
+	// some differences are suppressed as noise
+	noisy := false
 
-	if errBaseline != nil || errTest != nil {
+	if errBaseline != nil && !isDecodeError(errBaseline) || errTest != nil && !isDecodeError(errTest) {
 		// 1. Primary Error Check
-		state = "FETCH_ERROR"
+		state = StateFetchError
 	} else if BaselineContent.Status != TestContent.Status {
 		// 2. Status Check
-		state = "STATUS_NOT_MATCH"
+		state = StateStatusNotMatch
 	} else {
-		if BaselineContent.Content == nil || TestContent.Content == nil {
+		if isEmptyContent(BaselineContent) || isEmptyContent(TestContent) {
 			// 3.1 Empty Content Check
-			state = "EMPTY_CONTENT"
+			state = StateEmptyContent
 		} else if (BaselineContent.Status != http.StatusOK) && (BaselineContent.Status != http.StatusPartialContent) {
-			// 3.2 Skip if status is not 200
-			state = "STATUS_NOT_200/206_SKIP"
+			// 3.2 Skip body if status is not 200, headers are still checked, eg: Location of redirects, ETag of 304
+			state = StateStatusSkip
+			if headerDiff, noisy = v.diffHeaders(r, BaselineContent, TestContent); len(headerDiff) > 0 {
+				state = StateHeaderNotMatch
+			}
+		} else if errBaseline != nil {
+			// 3.3 Decode Error Check, bodies failed to decode in stream mode
+			state = StateDecodeError
+			vd.Reason = fmt.Sprintf("decode baseline content error: %s", errBaseline)
+		} else if errTest != nil {
+			state = StateDecodeError
+			vd.Reason = fmt.Sprintf("decode test content error: %s", errTest)
 		} else {
-			// 3.3 Content Check
-			ok := compareContent(BaselineContent, TestContent)
-			if !ok {
-				state = "CONTENT_NOT_MATCH"
-				go func() {
-					path := r.URL.Path + ".test"
-					err := storage.Write(path, TestContent.Content)
-					if err != nil {
-						logger.Errorf("write test content error, path: %s, err: %s", path, err)
-					}
-				}()
-				go func() {
-					path := r.URL.Path + ".baseline"
-					err := storage.Write(path, BaselineContent.Content)
-					if err != nil {
-						logger.Errorf("write baseline content error, path: %s, err: %s", path, err)
-					}
-				}()
+			// 3.4 Content Check
+			vd = v.compare(r, BaselineContent, TestContent)
+			state = vd.State
+			if v.noise.suppressBody(r, vd) {
+				// 3.5 Suppress differences learned between baselines
+				state = StatePass
+				noisy = true
+			}
+			if state == StateContentNotMatch {
+				// 3.6 Confirm by re-fetching, if enabled
+				state = v.confirmMismatch(r, f, BaselineContent)
+			}
+			if state == StatePass {
+				// 3.7 Header Check, if enabled
+				var noisyHeaders bool
+				headerDiff, noisyHeaders = v.diffHeaders(r, BaselineContent, TestContent)
+				noisy = noisy || noisyHeaders
+				if len(headerDiff) > 0 {
+					state = StateHeaderNotMatch
+				}
+			}
+			if state == StatePass && noisy {
+				state = StateNoise
 			}
 		}
 	}
-	var baselineHash string
-	var testHash string
-	if BaselineContent != nil && BaselineContent.Content != nil {
-		h := md5.New()
-		h.Write(BaselineContent.Content)
-		baselineHash = hex.EncodeToString(h.Sum(nil))
+	return checkResult{state: state, verdict: vd, headerDiff: headerDiff}
+}
+
+// diffHeaders returns headers which differ by header policy, noisy is true if some are suppressed as noise.
+func (v *Validator) diffHeaders(r *client.Request, b *client.Content, t *client.Content) ([]string, bool) {
+	headerDiff := v.header.diff(b.Header, t.Header)
+	remain := v.noise.suppressHeaders(r, headerDiff)
+	return remain, len(remain) < len(headerDiff)
+}
+
+// CheckContentAndReport compares test content of target f against baseline content, and reports the result.
+// diffOffset is the first differing offset found in stream mode, -1 if unknown.
+func (v *Validator) CheckContentAndReport(r *client.Request, f *client.Fetcher, BaselineContent *client.Content, errBaseline error, TestContent *client.Content, errTest error, diffOffset int64) {
+	target := f.Name
+	res := v.check(r, f, BaselineContent, errBaseline, TestContent, errTest)
+	state := res.state
+	if res.verdict.Offset >= 0 {
+		diffOffset = res.verdict.Offset
 	}
-	if TestContent != nil && TestContent.Content != nil {
-		h := md5.New()
-		h.Write(TestContent.Content)
-		testHash = hex.EncodeToString(h.Sum(nil))
+	var caseID, diffKey string
+	if isBadCase(state) {
+		caseID, diffKey = v.writeBadCase(r, target, state, res.verdict, res.headerDiff, diffOffset, BaselineContent, TestContent)
 	}
 
-	// process result
-	var baselineHeader, testHeader http.Header
-	var baselineStatus, testStatus int
+	monitor.ResultTotalCounterIncr("ContentCompare", target, state)
+	record := &Result{
+		Version:    ResultVersion,
+		Kind:       KindCompare,
+		Time:       time.Now(),
+		Target:     target,
+		Method:     r.Method,
+		Host:       r.Host,
+		Path:       r.Path(),
+		URL:        r.URL,
+		State:      state,
+		Reason:     res.verdict.Reason,
+		DiffOffset: diffOffset,
+		DiffPaths:  res.verdict.Paths,
+		HeaderDiff: res.headerDiff,
+		CaseID:     caseID,
+		DiffKey:    diffKey,
+		Baseline:   v.newResultContent(BaselineContent, errBaseline),
+		Test:       v.newResultContent(TestContent, errTest),
+	}
+	if record.ErrorClass = record.Baseline.ErrorClass; record.ErrorClass == "" {
+		record.ErrorClass = record.Test.ErrorClass
+	}
+	result_logger.Record(record)
+}
 
-	if BaselineContent == nil {
-		baselineHeader = http.Header{}
-		baselineStatus = 0
-	} else {
-		baselineHeader = BaselineContent.Header
-		baselineStatus = BaselineContent.Status
+// compareContent returns whether contents are the same, and the first differing offset, -1 if unknown.
+// Truncated contents in stream mode are compared by size and md5, of decoded bodies if they are decoded.
+func compareContent(b *client.Content, t *client.Content) (bool, int64) {
+	if b.Truncated || t.Truncated {
+		hash := compareHash(b)
+		return b.Size == t.Size && hash != "" && hash == compareHash(t), -1
 	}
-	if TestContent == nil {
-		testHeader = http.Header{}
-		testStatus = 0
-	} else {
-		testHeader = TestContent.Header
-		testStatus = TestContent.Status
+	if idx := firstDiff(b.Content, t.Content); idx >= 0 {
+		return false, int64(idx)
 	}
+	return true, -1
+}
 
-	monitor.ResultTotalCounterIncr("ContentCompare", state)
-	result_logger.Infof("[REQ]\t%v\t%v\t%v\t%v\t%v\t%v\t%v \t%v\t%v\t%v\t%v ", state, r.Host, r.URL.Path,
-		errBaseline, baselineStatus, baselineHash, baselineHeader,
-		errTest, testStatus, testHash, testHeader)
+// isEmptyContent is true when body is not fetched at all.
+func isEmptyContent(c *client.Content) bool {
+	return c.Content == nil && !c.Truncated
 }
 
-func compareContent(b *client.Content, t *client.Content) bool {
-	if len(b.Content) != len(t.Content) {
-		return false
+// compareHash returns md5 hex of the body as compared, decoded if it's decoded while reading.
+func compareHash(c *client.Content) string {
+	if c.Decoded && c.DecodedMD5 != "" {
+		return c.DecodedMD5
+	}
+	return contentHash(c)
+}
+
+// contentHash returns md5 hex of the body as received, "" if not available.
+// Stream mode hashes bodies as received too, before decoding.
+func contentHash(c *client.Content) string {
+	if c == nil {
+		return ""
+	}
+	if c.MD5 != "" {
+		return c.MD5
+	}
+	if c.Content == nil || c.Truncated {
+		return ""
 	}
-	for i := 0; i < len(b.Content); i++ {
-		if b.Content[i] != t.Content[i] {
-			return false
+	h := md5.New()
+	h.Write(c.Content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// confirmMismatch re-fetches on content mismatch, to tell unstable origins from real test failures.
+func (v *Validator) confirmMismatch(r *client.Request, f *client.Fetcher, BaselineContent *client.Content) string {
+	// baseline disagrees with itself
+	for i := 0; i < v.confirmRetries; i++ {
+		b, err := v.fetchContent(client.BaselineFetcher, r)
+		if err != nil {
+			continue
+		}
+		if !v.sameContent(r, BaselineContent, b) {
+			return StateBaselineUnstable
 		}
 	}
-	return true
+	// test agrees with baseline sometimes
+	if v.confirmTest {
+		for i := 0; i < v.confirmRetries; i++ {
+			t, err := v.fetchContent(f, r)
+			if err != nil {
+				continue
+			}
+			if v.sameContent(r, BaselineContent, t) {
+				return StateTestUnstable
+			}
+		}
+	}
+	return StateContentNotMatch
+}
+
+// fetchContent fetches content from f, in stream mode if enabled.
+func (v *Validator) fetchContent(f *client.Fetcher, r *client.Request) (*client.Content, error) {
+	if v.stream {
+		return v.streamContent(f, r)
+	}
+	return GetTestContent(f, r)
 }
@@ -0,0 +1,193 @@
+package validator
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/bocchi-the-cache/inspector/pkg/client"
+	"github.com/bocchi-the-cache/inspector/pkg/common/logger"
+)
+
+func TestMain(m *testing.M) {
+	// logs go next to the test binary
+	logger.InitLogger("log", "log.txt", "error")
+	os.Exit(m.Run())
+}
+
+// setBaseline points baseline fetcher to srv during the test.
+func setBaseline(t *testing.T, srv *httptest.Server) {
+	old := client.BaselineFetcher
+	client.BaselineFetcher = client.NewHttpFetcher(strings.TrimPrefix(srv.URL, "http://"))
+	client.BaselineFetcher.Name = client.BaselineName
+	t.Cleanup(func() { client.BaselineFetcher = old })
+}
+
+func TestCompareContent(t *testing.T) {
+	const (
+		md5A = "0cc175b9c0f1b6a831c399e269772661" // md5 of "a"
+		md5B = "92eb5ffee6ae2fec3ad71c777531578f" // md5 of "b"
+	)
+	tests := []struct {
+		name       string
+		b, t       client.Content
+		wantOK     bool
+		wantOffset int64
+	}{
+		{"same", client.Content{Content: []byte("abc")}, client.Content{Content: []byte("abc")}, true, -1},
+		{"both empty", client.Content{Content: []byte{}}, client.Content{Content: []byte{}}, true, -1},
+		{"differ", client.Content{Content: []byte("abc")}, client.Content{Content: []byte("abd")}, false, 2},
+		{"test shorter", client.Content{Content: []byte("abc")}, client.Content{Content: []byte("ab")}, false, 2},
+		{"test longer", client.Content{Content: []byte("ab")}, client.Content{Content: []byte("abc")}, false, 2},
+		{"truncated same md5",
+			client.Content{Content: []byte("x"), Truncated: true, Size: 1, MD5: md5A},
+			client.Content{Content: []byte("y"), Truncated: true, Size: 1, MD5: md5A}, true, -1},
+		{"truncated differ md5",
+			client.Content{Truncated: true, Size: 1, MD5: md5A},
+			client.Content{Truncated: true, Size: 1, MD5: md5B}, false, -1},
+		{"truncated differ size",
+			client.Content{Truncated: true, Size: 1, MD5: md5A},
+			client.Content{Truncated: true, Size: 2, MD5: md5A}, false, -1},
+		{"truncated without md5",
+			client.Content{Truncated: true, Size: 1},
+			client.Content{Truncated: true, Size: 1}, false, -1},
+		{"truncated against whole body",
+			client.Content{Truncated: true, Size: 1, MD5: md5A},
+			client.Content{Content: []byte("a"), Size: 1}, true, -1},
+		{"decoded are compared by decoded md5",
+			client.Content{Truncated: true, Size: 1, MD5: md5A, Decoded: true, DecodedMD5: md5B},
+			client.Content{Truncated: true, Size: 1, MD5: md5B, Decoded: true, DecodedMD5: md5B}, true, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, offset := compareContent(&tt.b, &tt.t)
+			if ok != tt.wantOK || offset != tt.wantOffset {
+				t.Fatalf("compareContent: got (%v, %d), want (%v, %d)", ok, offset, tt.wantOK, tt.wantOffset)
+			}
+		})
+	}
+}
+
+func TestProxyRequest(t *testing.T) {
+	baseline := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Request-Body", string(body))
+		w.Header().Set("Proxy-Authenticate", "Basic")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(w, strings.TrimPrefix(r.URL.Path, "/"))
+	}))
+	defer baseline.Close()
+	setBaseline(t, baseline)
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		body         string
+		wantBody     string
+		wantReqBody  string
+		wantValidate bool
+	}{
+		{"validated", http.MethodGet, "/0123456789", "", "0123456789", "", true},
+		{"larger than keep_body_size", http.MethodGet, "/0123456789a", "", "0123456789a", "", false},
+		{"with body", http.MethodPost, "/abc", "q=1", "abc", "q=1", true},
+		{"body larger than max_body_size", http.MethodPost, "/abc", "0123456789abcdef", "abc", "0123456789abcdef", false},
+		{"filtered out", http.MethodPut, "/abc", "q=1", "abc", "q=1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewValidator(Options{
+				Methods:      []string{http.MethodGet, http.MethodPost},
+				MaxBodySize:  8,
+				KeepBodySize: 10,
+				ChunkSize:    4,
+			})
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			if err := v.ProxyRequest(w, r); err != nil {
+				t.Fatalf("ProxyRequest: %v", err)
+			}
+			if w.Code != http.StatusCreated || w.Body.String() != tt.wantBody {
+				t.Fatalf("ProxyRequest: got %d %q, want %d %q", w.Code, w.Body.String(), http.StatusCreated, tt.wantBody)
+			}
+			if got := w.Header().Get("X-Request-Body"); got != tt.wantReqBody {
+				t.Fatalf("baseline got body %q, want %q", got, tt.wantReqBody)
+			}
+			for _, h := range client.HopHeaders {
+				if w.Header().Get(h) != "" {
+					t.Fatalf("hop-by-hop header %s is returned to the caller", h)
+				}
+			}
+
+			if validated := len(v.queue) == 1; validated != tt.wantValidate {
+				t.Fatalf("validated: got %v, want %v", validated, tt.wantValidate)
+			}
+			if tt.wantValidate {
+				task := <-v.queue
+				if !task.withBaseline || string(task.baseline.Content) != tt.wantBody || task.baseline.Status != http.StatusCreated {
+					t.Fatalf("queued baseline: got %+v", task.baseline)
+				}
+			}
+		})
+	}
+}
+
+func TestProxyRequestStreams(t *testing.T) {
+	release := make(chan struct{})
+	baseline := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "first")
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = io.WriteString(w, "second")
+	}))
+	defer baseline.Close()
+	setBaseline(t, baseline)
+
+	v := NewValidator(Options{ChunkSize: 4})
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := v.ProxyRequest(w, r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		}
+	}))
+	defer proxy.Close()
+
+	resp, err := http.Get(proxy.URL + "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	// the first bytes arrive before baseline finishes
+	first := make([]byte, len("first"))
+	if _, err = io.ReadFull(resp.Body, first); err != nil || string(first) != "first" {
+		t.Fatalf("read first bytes: got %q, err: %v", first, err)
+	}
+	close(release)
+	rest, err := io.ReadAll(resp.Body)
+	if err != nil || string(rest) != "second" {
+		t.Fatalf("read the rest: got %q, err: %v", rest, err)
+	}
+}
+
+func TestProxyRequestBaselineDown(t *testing.T) {
+	baseline := httptest.NewServer(http.NotFoundHandler())
+	setBaseline(t, baseline)
+	baseline.Close()
+
+	v := NewValidator(Options{})
+	w := httptest.NewRecorder()
+	if err := v.ProxyRequest(w, httptest.NewRequest(http.MethodGet, "/a", nil)); err == nil {
+		t.Fatal("ProxyRequest: got no error")
+	}
+	if w.Body.Len() != 0 {
+		t.Fatalf("ProxyRequest: wrote %q to the caller", w.Body.String())
+	}
+	// test is still validated against the baseline error
+	task := <-v.queue
+	if task.errBaseline == nil {
+		t.Fatal("queued task has no baseline error")
+	}
+}